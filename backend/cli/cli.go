@@ -0,0 +1,169 @@
+// Package cli has the headless client subcommands, they drive a back-end from shell scripts and Ansible,
+// orchestrate drives many back-ends at once and validate checks preseed files offline.
+//
+// The exit code of install mirrors the result of the installation: 0 when it succeeded,
+// the exit code of the installer when it failed, or ExitFailed when the installer did not report a usable one
+// or the verification of the installed system failed. The problems of the client itself
+// have exit codes above the ones installer.sh uses, following sysexits.h.
+package cli
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/client"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+const (
+	ExitOk     = 0
+	ExitFailed = 1
+	// ExitUsage is returned for unknown subcommands and invalid flags
+	ExitUsage = 64
+	// ExitInvalid is returned when the preseed or the installation parameters are invalid
+	ExitInvalid = 65
+	// ExitUnavailable is returned when the back-end can not be reached or refuses the request
+	ExitUnavailable = 69
+	// ExitState is returned when an installation is already running, or there is none
+	ExitState = 75
+)
+
+// Commands are the subcommands Run understands
+var Commands = []string{"install", "status", "logs", "image", "cancel", "validate", "orchestrate"}
+
+// statusPollInterval is how often install waits for the verification after the log stream ended
+var statusPollInterval = time.Second
+
+type command struct {
+	flags   *flag.FlagSet
+	baseUrl *string
+	token   *string
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+func newCommand(name string, stdout io.Writer, stderr io.Writer) *command {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return &command{
+		flags:  flags,
+		stdout: stdout,
+		stderr: stderr,
+	}
+}
+
+// remote adds the flags of the subcommands that talk to a back-end
+func (c *command) remote() {
+	c.baseUrl = c.flags.String("baseUrl", "http://localhost:5000", "base URL of the back-end")
+	c.token = c.flags.String("token", os.Getenv("API_TOKEN"), "API_TOKEN of the back-end, if it has one")
+}
+
+// parse parses the flags and returns the exit code when the subcommand should not run
+func (c *command) parse(args []string) (int, bool) {
+	if err := c.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOk, false
+		}
+		return ExitUsage, false
+	}
+	return ExitOk, true
+}
+
+func (c *command) printf(format string, args ...any) {
+	_, _ = fmt.Fprintf(c.stderr, format+"\n", args...)
+}
+
+// failed prints the error and returns the exit code for it
+func (c *command) failed(err error) int {
+	var apiErr *client.ApiError
+	if errors.As(err, &apiErr) {
+		c.printf("error: %s (%s)", apiErr.Message, apiErr.Code)
+		switch apiErr.Code {
+		case client.ErrAlreadyRunning, client.ErrNotRunning:
+			return ExitState
+		case client.ErrBadRequest, client.ErrInvalidConfig:
+			return ExitInvalid
+		}
+		return ExitUnavailable
+	}
+	if errors.Is(err, context.Canceled) {
+		c.printf("interrupted")
+		return ExitFailed
+	}
+	c.printf("error: %v", err)
+	return ExitUnavailable
+}
+
+// Run runs the subcommand with its arguments and returns the exit code for the process
+func Run(name string, args []string, stdout io.Writer, stderr io.Writer) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c := newCommand(name, stdout, stderr)
+	var run func(ctx context.Context, api *client.Client) int
+	switch name {
+	case "validate":
+		return c.validate(args)
+	case "orchestrate":
+		return c.orchestrate(ctx, args)
+	case "install":
+		run = c.install()
+	case "status":
+		run = c.status()
+	case "logs":
+		run = c.logs()
+	case "image":
+		run = c.image()
+	case "cancel":
+		run = c.cancel()
+	default:
+		c.printf("unknown subcommand %q, expected one of %v", name, Commands)
+		return ExitUsage
+	}
+	c.remote()
+	if code, ok := c.parse(args); !ok {
+		return code
+	}
+	if c.flags.NArg() > 0 {
+		c.printf("unexpected arguments %v", c.flags.Args())
+		return ExitUsage
+	}
+	api, err := client.New(*c.baseUrl, client.WithToken(*c.token))
+	if err != nil {
+		c.printf("%v", err)
+		return ExitUsage
+	}
+	return run(ctx, api)
+}
+
+// exitCode mirrors the result of a finished installation
+func exitCode(status client.ProcessStatus) int {
+	if !status.Failed() {
+		return ExitOk
+	}
+	if status.ReturnCode > 0 && status.ReturnCode < ExitUsage {
+		return status.ReturnCode
+	}
+	return ExitFailed
+}
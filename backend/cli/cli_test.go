@@ -0,0 +1,237 @@
+package cli
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/server"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const PRESEED = "DISK: /dev/vda\nLUKS_PASSWORD: luke\nUSER_PASSWORD: a\nROOT_PASSWORD: b\n"
+
+func simulatedBackend(t *testing.T, failAt string) string {
+	b, err := server.New(context.Background(), "", &server.SimulationOptions{StepDelay: time.Millisecond, FailAt: failAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(b.Handler(t.TempDir()))
+	t.Cleanup(s.Close)
+	return s.URL
+}
+
+func writePreseed(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "preseed.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInstall(t *testing.T) {
+	statusPollInterval = time.Millisecond
+	baseUrl := simulatedBackend(t, "")
+
+	var stdout, stderr bytes.Buffer
+	if code := Run("status", []string{"-baseUrl", baseUrl}, &stdout, &stderr); code != ExitState {
+		t.Errorf("status before install = %d; want %d", code, ExitState)
+	}
+	code := Run("install", []string{"-baseUrl", baseUrl, "-config", writePreseed(t, PRESEED)}, &stdout, &stderr)
+	if code != ExitOk {
+		t.Fatalf("install = %d; want %d\n%s", code, ExitOk, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "/dev/vda") {
+		t.Errorf("install output = %s; want the installer output", stdout.String())
+	}
+
+	stdout.Reset()
+	if code = Run("status", []string{"-baseUrl", baseUrl, "-json"}, &stdout, &stderr); code != ExitOk {
+		t.Errorf("status = %d; want %d", code, ExitOk)
+	}
+	var status client.ProcessStatus
+	if err := json.Unmarshal(stdout.Bytes(), &status); err != nil || status.Status != client.StatusFinished {
+		t.Errorf("status -json = %s, %v; want FINISHED", stdout.String(), err)
+	}
+	if code = Run("install", []string{"-baseUrl", baseUrl, "-config", writePreseed(t, PRESEED)}, &stdout, &stderr); code != ExitState {
+		t.Errorf("second install = %d; want %d", code, ExitState)
+	}
+	if code = Run("cancel", []string{"-baseUrl", baseUrl}, &stdout, &stderr); code != ExitState {
+		t.Errorf("cancel of a finished installation = %d; want %d", code, ExitState)
+	}
+	if code = Run("status", []string{"-baseUrl", baseUrl}, &stdout, &stderr); code != ExitOk {
+		t.Errorf("status after cancel = %d; want %d for the finished installation that was kept", code, ExitOk)
+	}
+	if code = Run("cancel", []string{"-baseUrl", baseUrl, "-clear"}, &stdout, &stderr); code != ExitOk {
+		t.Errorf("cancel -clear = %d; want %d", code, ExitOk)
+	}
+}
+
+func TestInstallFailed(t *testing.T) {
+	statusPollInterval = time.Millisecond
+	baseUrl := simulatedBackend(t, "packages")
+
+	var stdout, stderr bytes.Buffer
+	code := Run("install", []string{"-baseUrl", baseUrl, "-config", writePreseed(t, PRESEED)}, &stdout, &stderr)
+	if code == ExitOk || code >= ExitUsage {
+		t.Errorf("install = %d; want the exit code of the installer", code)
+	}
+	if !strings.Contains(stderr.String(), "packages step") {
+		t.Errorf("install errors = %s; want the failed step", stderr.String())
+	}
+	stdout.Reset()
+	if code = Run("logs", []string{"-baseUrl", baseUrl}, &stdout, &stderr); code != ExitOk || stdout.Len() == 0 {
+		t.Errorf("logs = %d with %d bytes; want the output", code, stdout.Len())
+	}
+}
+
+func TestInstallInvalid(t *testing.T) {
+	baseUrl := simulatedBackend(t, "")
+
+	var stdout, stderr bytes.Buffer
+	code := Run("install", []string{"-baseUrl", baseUrl, "-config", writePreseed(t, PRESEED+"SWAP_SIZE: many\n")}, &stdout, &stderr)
+	if code != ExitInvalid || !strings.Contains(stderr.String(), "SWAP_SIZE") {
+		t.Errorf("install = %d, %s; want %d for SWAP_SIZE", code, stderr.String(), ExitInvalid)
+	}
+	if code = Run("install", []string{"-baseUrl", baseUrl, "extra"}, &stdout, &stderr); code != ExitUsage {
+		t.Errorf("install with an argument = %d; want %d", code, ExitUsage)
+	}
+	if code = Run("cancel", []string{"-baseUrl", baseUrl}, &stdout, &stderr); code != ExitState {
+		t.Errorf("cancel without an installation = %d; want %d", code, ExitState)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := writePreseed(t, PRESEED)
+	invalid := filepath.Join(t.TempDir(), "installer.ini")
+	if err := os.WriteFile(invalid, []byte("DISK=/dev/vda\nDISABLE_LUKS=true\nENABLE_TPM=true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := Run("validate", []string{valid}, &stdout, &stderr); code != ExitOk {
+		t.Errorf("validate = %d, %s; want %d", code, stdout.String(), ExitOk)
+	}
+	prompted := writePreseed(t, "DISK: /dev/vda\nDISABLE_LUKS: true\n")
+	if code := Run("validate", []string{prompted}, &stdout, &stderr); code != ExitOk || !strings.Contains(stdout.String(), "ROOT_PASSWORD is not set, it will be prompted for") {
+		t.Errorf("validate without ROOT_PASSWORD = %d, %s; want %d and a warning", code, stdout.String(), ExitOk)
+	}
+	if code := Run("validate", []string{"-strict", prompted}, &stdout, &stderr); code != ExitInvalid {
+		t.Errorf("validate -strict without ROOT_PASSWORD = %d; want %d for the warning", code, ExitInvalid)
+	}
+	stdout.Reset()
+	if code := Run("validate", []string{"-json", valid, invalid}, &stdout, &stderr); code != ExitInvalid {
+		t.Errorf("validate = %d; want %d", code, ExitInvalid)
+	}
+	var results []ValidateResult
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatalf("validate -json = %s: %v", stdout.String(), err)
+	}
+	if len(results) != 2 || !results[0].Valid || results[1].Valid || results[1].Findings[0].Key != "ENABLE_TPM" || results[1].Findings[0].Line != 3 {
+		t.Errorf("validate -json = %+v; want the first valid and ENABLE_TPM on line 3 of the second", results)
+	}
+	if code := Run("validate", nil, &stdout, &stderr); code != ExitUsage {
+		t.Errorf("validate without a file = %d; want %d", code, ExitUsage)
+	}
+}
+
+// scriptBackend starts a back-end that runs script as INSTALLER_SCRIPT, like a real one would run installer.sh
+func scriptBackend(t *testing.T, script string) string {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "installer.sh")
+	if err := os.WriteFile(scriptPath, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "installer.ini")
+	if err := os.WriteFile(configPath, []byte("INSTALLER_RUNNER=script\nINSTALLER_SCRIPT="+scriptPath+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := server.New(context.Background(), configPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(b.Handler(dir))
+	t.Cleanup(s.Close)
+	return s.URL
+}
+
+func TestOrchestrate(t *testing.T) {
+	statusPollInterval = time.Millisecond
+	const SCRIPT = "#!/bin/sh\n" +
+		"echo setting up partitions on $DISK\n" +
+		"echo setup fstab\n" +
+		"[ \"$HOSTNAME\" != pc3 ] || { echo 'E: No space left on device'; exit 100; }\n" +
+		"echo installed $HOSTNAME\n"
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	hosts := "# classroom\n" +
+		scriptBackend(t, SCRIPT) + ",HOSTNAME=pc1,DISK=/dev/disk/by-id/disk1\n" +
+		scriptBackend(t, SCRIPT) + ",HOSTNAME=pc2\n"
+	if err := os.WriteFile(hostsPath, []byte(hosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "reports")
+
+	var stdout, stderr bytes.Buffer
+	code := Run("orchestrate", []string{"-config", writePreseed(t, PRESEED), "-hosts", hostsPath, "-parallel", "2", "-out", out,
+		scriptBackend(t, SCRIPT) + ",HOSTNAME=pc3"}, &stdout, &stderr)
+	if code != ExitFailed {
+		t.Errorf("orchestrate = %d; want %d for pc3\n%s%s", code, ExitFailed, stdout.String(), stderr.String())
+	}
+	data, err := os.ReadFile(filepath.Join(out, "summary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []HostResult
+	if err = json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Name+" "+r.Status)
+	}
+	if strings.Join(got, ",") != "pc1 succeeded,pc2 succeeded,pc3 failed" {
+		t.Errorf("summary = %v; want pc1 and pc2 succeeded, pc3 failed", got)
+	}
+	if results[2].ExitCode != 100 || results[2].Message != "the disk ran out of space" {
+		t.Errorf("pc3 = %+v; want exit code 100 and the explanation", results[2])
+	}
+	log, err := os.ReadFile(filepath.Join(out, "pc1.log"))
+	if err != nil || !strings.Contains(string(log), "/dev/disk/by-id/disk1") || !strings.Contains(string(log), "installed pc1") {
+		t.Errorf("pc1.log = %q, %v; want the output with the overrides", log, err)
+	}
+	for _, name := range []string{"pc2.report.json", "pc3.diagnostics.tar.gz"} {
+		if _, err = os.Stat(filepath.Join(out, name)); err != nil {
+			t.Errorf("%s was not collected: %v", name, err)
+		}
+	}
+	if !strings.Contains(stdout.String(), "pc2 running") {
+		t.Errorf("dashboard = %s; want a line for every change", stdout.String())
+	}
+
+	if code = Run("orchestrate", []string{"http://localhost:1,COLOR=blue"}, &stdout, &stderr); code != ExitInvalid {
+		t.Errorf("orchestrate with an unknown key = %d; want %d", code, ExitInvalid)
+	}
+}
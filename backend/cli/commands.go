@@ -0,0 +1,239 @@
+package cli
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/config"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// install validates the preseed against the back-end, starts the installation and follows its output
+func (c *command) install() func(ctx context.Context, api *client.Client) int {
+	configPath := c.flags.String("config", "", "preseed file (INI, JSON or YAML), empty to install with the pending parameters")
+	follow := c.flags.Bool("follow", true, "print the output until the installation finishes")
+	return func(ctx context.Context, api *client.Client) int {
+		parameters := map[string]string{}
+		if *configPath != "" {
+			var configErrors []config.ConfigError
+			var err error
+			parameters, configErrors, err = config.LoadFile(*configPath)
+			if err != nil {
+				c.printf("%v", err)
+				return ExitInvalid
+			}
+			for _, configError := range configErrors {
+				c.printf("%v", configError)
+			}
+			if len(configErrors) > 0 {
+				return ExitInvalid
+			}
+		}
+		plan, err := api.DryRun(ctx, parameters)
+		if err != nil {
+			return c.failed(err)
+		}
+		if !plan.Valid {
+			for _, issue := range plan.Issues {
+				c.printf("%s: %s", issue.Key, issue.Message)
+			}
+			return ExitInvalid
+		}
+		started, err := api.Install(ctx, parameters)
+		if err != nil {
+			return c.failed(err)
+		}
+		c.printf("installation %s started on %s", started.RunId, plan.Parameters["DISK"])
+		if !*follow {
+			return ExitOk
+		}
+		// the back-end may run other jobs on its other disks
+		api = api.Job(started.RunId)
+		if err = api.StreamLog(ctx, c.stdout); err != nil {
+			return c.failed(err)
+		}
+		status, err := waitForFinished(ctx, api)
+		if err != nil {
+			return c.failed(err)
+		}
+		c.printResult(status)
+		return exitCode(status)
+	}
+}
+
+// waitForFinished polls the status until the installation and its verification are finished
+func waitForFinished(ctx context.Context, api *client.Client) (client.ProcessStatus, error) {
+	for {
+		status, err := api.ProcessStatus(ctx)
+		if err != nil || status.Status == client.StatusFinished {
+			return status, err
+		}
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(statusPollInterval):
+		}
+	}
+}
+
+func (c *command) printResult(status client.ProcessStatus) {
+	for _, check := range status.Verification {
+		result := "PASS"
+		if !check.Passed {
+			result = "FAIL"
+		}
+		c.printf("verification %s %s: %s", result, check.Name, check.Message)
+	}
+	switch {
+	case status.Error != nil:
+		c.printf("installation failed in the %s step with exit code %d: %s", status.Error.Step, status.Error.ExitCode, status.Error.Cause)
+		c.printf("%s", status.Error.Remedy)
+	case status.Failed():
+		c.printf("installation finished, but the verification of the installed system failed")
+	default:
+		c.printf("installation finished successfully")
+	}
+}
+
+// jobFlag adds the -job flag to the subcommands that look at one installation
+func (c *command) jobFlag() *string {
+	return c.flags.String("job", "", "run id of the installation, the latest one by default")
+}
+
+// status prints the state and the progress of the installation
+func (c *command) status() func(ctx context.Context, api *client.Client) int {
+	asJson := c.flags.Bool("json", false, "print the status as JSON, without the output of the installer")
+	job := c.jobFlag()
+	return func(ctx context.Context, api *client.Client) int {
+		api = api.Job(*job)
+		status, err := api.ProcessStatus(ctx)
+		if err != nil {
+			return c.failed(err)
+		}
+		if *asJson {
+			status.Output = ""
+			encoder := json.NewEncoder(c.stdout)
+			encoder.SetIndent("", "  ")
+			if err = encoder.Encode(status); err != nil {
+				return c.failed(err)
+			}
+		} else {
+			_, _ = fmt.Fprintf(c.stdout, "status:   %s\nrun:      %s\nprogress: %d/%d %s\n",
+				status.Status, status.RunId, status.Progress.Current, status.Progress.Total, status.Progress.Step)
+			if status.Status == client.StatusFinished {
+				_, _ = fmt.Fprintf(c.stdout, "exit:     %d\n", status.ReturnCode)
+				c.printResult(status)
+			}
+		}
+		if status.Status != client.StatusFinished {
+			return ExitOk
+		}
+		return exitCode(status)
+	}
+}
+
+// logs prints the output of the installer, or follows it until the installation finishes
+func (c *command) logs() func(ctx context.Context, api *client.Client) int {
+	follow := c.flags.Bool("f", false, "follow the output until the installation finishes")
+	job := c.jobFlag()
+	return func(ctx context.Context, api *client.Client) int {
+		api = api.Job(*job)
+		var err error
+		if *follow {
+			err = api.StreamLog(ctx, c.stdout)
+		} else {
+			var output []byte
+			output, err = api.DownloadLog(ctx)
+			if err == nil {
+				_, err = c.stdout.Write(output)
+			}
+		}
+		if err != nil {
+			return c.failed(err)
+		}
+		return ExitOk
+	}
+}
+
+// image saves the finished disk image of an installation with TARGET_TYPE image
+func (c *command) image() func(ctx context.Context, api *client.Client) int {
+	output := c.flags.String("o", "", "file to save the disk image to, the name the back-end suggests in the current directory by default")
+	job := c.jobFlag()
+	return func(ctx context.Context, api *client.Client) int {
+		dir := "."
+		if *output != "" {
+			dir = filepath.Dir(*output)
+		}
+		// the image only gets its name once it is complete, an interrupted download leaves nothing behind
+		f, err := os.CreateTemp(dir, ".odi-image-*")
+		if err != nil {
+			return c.failed(err)
+		}
+		defer os.Remove(f.Name())
+		name, err := api.Job(*job).DownloadImage(ctx, f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return c.failed(err)
+		}
+		path := *output
+		if path == "" {
+			path = name
+		}
+		if err = os.Rename(f.Name(), path); err != nil {
+			return c.failed(err)
+		}
+		c.printf("disk image saved to %s", path)
+		return ExitOk
+	}
+}
+
+// cancel stops the running installation, or aborts the countdown of the automatic installation.
+// A finished installation is only removed with -clear, its log and result are gone afterwards
+func (c *command) cancel() func(ctx context.Context, api *client.Client) int {
+	clearFinished := c.flags.Bool("clear", false, "remove the installation when it is already finished")
+	job := c.jobFlag()
+	return func(ctx context.Context, api *client.Client) int {
+		api = api.Job(*job)
+		// the back-end decides, the installation may finish while this runs
+		cancel := api.Cancel
+		if *clearFinished {
+			cancel = api.Clear
+		}
+		result, err := cancel(ctx)
+		var apiErr *client.ApiError
+		if errors.As(err, &apiErr) && apiErr.Code == client.ErrNotRunning && apiErr.StatusCode == http.StatusConflict {
+			c.printf("%s; use -clear to remove it", apiErr.Message)
+			return ExitState
+		}
+		if err != nil {
+			return c.failed(err)
+		}
+		c.printf("%s", result.Status)
+		return ExitOk
+	}
+}
@@ -0,0 +1,438 @@
+package cli
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/config"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// the states of a host in the orchestrate dashboard
+const (
+	HostWaiting   = "waiting"
+	HostPushing   = "pushing"
+	HostRunning   = "running"
+	HostVerifying = "verifying"
+	HostSucceeded = "succeeded"
+	HostFailed    = "failed"
+)
+
+// dashboardInterval is how often the live dashboard is redrawn
+var dashboardInterval = 500 * time.Millisecond
+
+// host is a back-end orchestrate drives, with the parameters that differ from the shared preseed
+type host struct {
+	url       string
+	overrides map[string]string
+}
+
+var unsafeFileName = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// parseHost reads URL[,KEY=VALUE...], e.g. http://10.0.0.11:5000,HOSTNAME=pc11,DISK=/dev/disk/by-id/nvme-disk
+func parseHost(spec string) (host, error) {
+	fields := strings.Split(spec, ",")
+	u, err := url.Parse(fields[0])
+	if err != nil || u.Host == "" {
+		return host{}, fmt.Errorf("%q is not a back-end URL", fields[0])
+	}
+	h := host{url: fields[0], overrides: make(map[string]string)}
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return host{}, fmt.Errorf("%s: expected KEY=VALUE, not %q", h.url, field)
+		}
+		if _, known := config.FindParameter(key); !known {
+			return host{}, fmt.Errorf("%s: unknown key %s", h.url, key)
+		}
+		if err = config.CheckValue(key, value); err != nil {
+			return host{}, fmt.Errorf("%s: %s %v", h.url, key, err)
+		}
+		h.overrides[key] = value
+	}
+	return h, nil
+}
+
+// readHosts reads one host per line, empty lines and lines starting with # are skipped
+func readHosts(path string) ([]host, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hosts []host
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		spec := strings.TrimSpace(scanner.Text())
+		if spec == "" || strings.HasPrefix(spec, "#") {
+			continue
+		}
+		h, err := parseHost(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, scanner.Err()
+}
+
+// name is the HOSTNAME override, or the address of the back-end, usable as a file name
+func (h host) name() string {
+	name := h.overrides["HOSTNAME"]
+	if name == "" {
+		u, _ := url.Parse(h.url)
+		name = u.Host
+	}
+	return unsafeFileName.ReplaceAllString(name, "_")
+}
+
+// HostResult is the state of one back-end, orchestrate writes them to summary.json
+type HostResult struct {
+	Name     string          `json:"name"`
+	Url      string          `json:"url"`
+	Status   string          `json:"status"`
+	RunId    string          `json:"run_id,omitempty"`
+	Progress client.Progress `json:"progress"`
+	ExitCode int             `json:"exit_code"`
+	Message  string          `json:"message,omitempty"`
+	// Files are the logs and reports collected into the output folder
+	Files []string `json:"files,omitempty"`
+}
+
+func (r HostResult) done() bool {
+	return r.Status == HostSucceeded || r.Status == HostFailed
+}
+
+// dashboard aggregates the progress of all hosts. It redraws a table on a terminal,
+// otherwise it prints a line whenever the state of a host changes.
+type dashboard struct {
+	lock    sync.Mutex
+	results []HostResult
+	out     io.Writer
+	live    bool
+	changed bool
+}
+
+func (d *dashboard) update(i int, change func(r *HostResult)) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	before := d.results[i]
+	change(&d.results[i])
+	after := d.results[i]
+	if before.Status == after.Status && before.Progress == after.Progress && before.Message == after.Message {
+		return
+	}
+	d.changed = true
+	if !d.live {
+		_, _ = fmt.Fprintf(d.out, "%s %s %s\n", time.Now().Format(time.TimeOnly), after.Name, describe(after))
+	}
+}
+
+func describe(r HostResult) string {
+	s := r.Status
+	if r.Progress.Total > 0 && !r.done() {
+		s += fmt.Sprintf(" %d/%d %s", r.Progress.Current, r.Progress.Total, r.Progress.Step)
+	}
+	if r.Message != "" {
+		s += ": " + r.Message
+	}
+	return s
+}
+
+// draw redraws the table of the live dashboard, if something changed
+func (d *dashboard) draw() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.live || !d.changed {
+		return
+	}
+	d.changed = false
+	counts := make(map[string]int)
+	_, _ = fmt.Fprint(d.out, "\033[H\033[2J")
+	w := tabwriter.NewWriter(d.out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "HOST\tSTATUS\tPROGRESS\tSTEP\tMESSAGE")
+	for _, r := range d.results {
+		counts[r.Status]++
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\n", r.Name, r.Status, r.Progress.Current, r.Progress.Total, r.Progress.Step, r.Message)
+	}
+	_ = w.Flush()
+	_, _ = fmt.Fprintf(d.out, "\n%d hosts: %d waiting, %d running, %d succeeded, %d failed\n", len(d.results),
+		counts[HostWaiting], counts[HostPushing]+counts[HostRunning]+counts[HostVerifying], counts[HostSucceeded], counts[HostFailed])
+}
+
+// isTerminal is true when the dashboard can be redrawn in place
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// orchestrate pushes the shared preseed with the per-host overrides to many back-ends,
+// runs at most -parallel installations at once and collects their logs and reports
+func (c *command) orchestrate(ctx context.Context, args []string) int {
+	c.flags.Usage = func() {
+		_, _ = fmt.Fprintf(c.flags.Output(), "usage: %s [flags] [URL[,KEY=VALUE...]]...\n", c.flags.Name())
+		c.flags.PrintDefaults()
+	}
+	configPath := c.flags.String("config", "", "preseed file (INI, JSON or YAML) shared by all hosts")
+	hostsPath := c.flags.String("hosts", "", "file with one URL[,KEY=VALUE...] per line, in addition to the arguments")
+	parallel := c.flags.Int("parallel", 4, "how many installations run at the same time")
+	outPath := c.flags.String("out", "odi-reports", "folder for the logs and reports of every host")
+	plain := c.flags.Bool("plain", false, "print a line for every change instead of redrawing the dashboard")
+	token := c.flags.String("token", os.Getenv("API_TOKEN"), "API_TOKEN of the back-ends, if they have one")
+	if code, ok := c.parse(args); !ok {
+		return code
+	}
+
+	var hosts []host
+	if *hostsPath != "" {
+		var err error
+		if hosts, err = readHosts(*hostsPath); err != nil {
+			c.printf("%v", err)
+			return ExitInvalid
+		}
+	}
+	for _, spec := range c.flags.Args() {
+		h, err := parseHost(spec)
+		if err != nil {
+			c.printf("%v", err)
+			return ExitInvalid
+		}
+		hosts = append(hosts, h)
+	}
+	if len(hosts) == 0 || *parallel < 1 {
+		c.flags.Usage()
+		return ExitUsage
+	}
+	names := make(map[string]bool)
+	for _, h := range hosts {
+		if names[h.name()] {
+			c.printf("more than one host is named %s, set a different HOSTNAME for each", h.name())
+			return ExitInvalid
+		}
+		names[h.name()] = true
+	}
+	preseed := map[string]string{}
+	if *configPath != "" {
+		var configErrors []config.ConfigError
+		var err error
+		preseed, configErrors, err = config.LoadFile(*configPath)
+		if err != nil {
+			c.printf("%v", err)
+			return ExitInvalid
+		}
+		for _, configError := range configErrors {
+			c.printf("%v", configError)
+		}
+		if len(configErrors) > 0 {
+			return ExitInvalid
+		}
+	}
+	if err := os.MkdirAll(*outPath, 0700); err != nil {
+		c.printf("%v", err)
+		return ExitUnavailable
+	}
+
+	d := &dashboard{out: c.stdout, live: !*plain && isTerminal(c.stdout), changed: true}
+	for _, h := range hosts {
+		d.results = append(d.results, HostResult{Name: h.name(), Url: h.url, Status: HostWaiting, ExitCode: -1})
+	}
+	d.draw()
+	finished := make(chan struct{})
+	go func() {
+		redraw := time.NewTicker(dashboardInterval)
+		defer redraw.Stop()
+		for {
+			select {
+			case <-finished:
+				return
+			case <-redraw.C:
+				d.draw()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, *parallel)
+	for i, h := range hosts {
+		wg.Go(func() {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				d.update(i, func(r *HostResult) { r.Status, r.Message = HostFailed, "interrupted" })
+				return
+			}
+			defer func() { <-slots }()
+			parameters := maps.Clone(preseed)
+			maps.Copy(parameters, h.overrides)
+			c.orchestrateHost(ctx, d, i, h, *token, parameters, *outPath)
+		})
+	}
+	wg.Wait()
+	close(finished)
+	d.draw()
+
+	code := ExitOk
+	for _, r := range d.results {
+		if r.Status != HostSucceeded {
+			code = ExitFailed
+		}
+		if d.live {
+			_, _ = fmt.Fprintf(c.stdout, "%s %s\n", r.Name, describe(r))
+		}
+	}
+	summary, _ := json.MarshalIndent(d.results, "", "  ")
+	if err := os.WriteFile(filepath.Join(*outPath, "summary.json"), append(summary, '\n'), 0600); err != nil {
+		c.printf("%v", err)
+		return ExitUnavailable
+	}
+	return code
+}
+
+// orchestrateHost installs one host and collects its log, report and (when it failed) diagnostics
+func (c *command) orchestrateHost(ctx context.Context, d *dashboard, i int, h host, token string, parameters map[string]string, outPath string) {
+	failed := func(format string, args ...any) {
+		d.update(i, func(r *HostResult) { r.Status, r.Message = HostFailed, fmt.Sprintf(format, args...) })
+	}
+	d.update(i, func(r *HostResult) { r.Status = HostPushing })
+	api, err := client.New(h.url, client.WithToken(token))
+	if err != nil {
+		failed("%v", err)
+		return
+	}
+	preseed, err := config.Render(parameters, config.ConfigYaml, true)
+	if err == nil {
+		err = api.PutConfig(ctx, preseed, string(config.ConfigYaml))
+	}
+	if err != nil {
+		failed("failed to push the configuration: %v", err)
+		return
+	}
+	plan, err := api.DryRun(ctx, map[string]string{})
+	if err != nil {
+		failed("%v", err)
+		return
+	}
+	if !plan.Valid {
+		var issues []string
+		for _, issue := range plan.Issues {
+			issues = append(issues, issue.Key+": "+issue.Message)
+		}
+		failed("invalid configuration: %s", strings.Join(issues, "; "))
+		return
+	}
+	started, err := api.Install(ctx, map[string]string{})
+	if err != nil {
+		failed("failed to start: %v", err)
+		return
+	}
+	d.update(i, func(r *HostResult) { r.Status, r.RunId = HostRunning, started.RunId })
+	// the back-end may run other jobs on its other disks
+	api = api.Job(started.RunId)
+
+	var status client.ProcessStatus
+	for {
+		status, err = api.ProcessStatus(ctx)
+		if err != nil {
+			failed("%v", err)
+			return
+		}
+		d.update(i, func(r *HostResult) {
+			r.Progress = status.Progress
+			if status.Status == client.StatusVerifying {
+				r.Status = HostVerifying
+			}
+		})
+		if status.Status == client.StatusFinished {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			failed("interrupted, the installation is still running")
+			return
+		case <-time.After(statusPollInterval):
+		}
+	}
+
+	files, collectErr := collect(ctx, api, h.name(), started.RunId, status.Failed(), outPath)
+	d.update(i, func(r *HostResult) {
+		r.Files = files
+		r.ExitCode = status.ReturnCode
+		switch {
+		case status.Error != nil:
+			r.Status, r.Message = HostFailed, status.Error.Cause
+		case status.Failed():
+			r.Status, r.Message = HostFailed, "the verification of the installed system failed"
+		default:
+			r.Status = HostSucceeded
+		}
+		if collectErr != nil && r.Message != "" {
+			r.Message += "; " + collectErr.Error()
+		} else if collectErr != nil {
+			r.Message = collectErr.Error()
+		}
+	})
+}
+
+// collect saves the log and the report of the run, and the diagnostics bundle of a failed one
+func collect(ctx context.Context, api *client.Client, name string, runId string, failed bool, outPath string) ([]string, error) {
+	var files []string
+	var errs []error
+	save := func(fileName string, data []byte, err error) {
+		if err == nil {
+			err = os.WriteFile(filepath.Join(outPath, fileName), data, 0600)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to collect %s: %w", fileName, err))
+			return
+		}
+		files = append(files, fileName)
+	}
+
+	log, err := api.DownloadLog(ctx)
+	save(name+".log", log, err)
+	report, err := api.Report(ctx, runId)
+	var reportJson []byte
+	if err == nil {
+		reportJson, err = json.MarshalIndent(report, "", "  ")
+	}
+	save(name+".report.json", reportJson, err)
+	if failed {
+		diagnostics, _, err := api.Diagnostics(ctx)
+		save(name+".diagnostics.tar.gz", diagnostics, err)
+	}
+	return files, errors.Join(errs...)
+}
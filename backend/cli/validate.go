@@ -0,0 +1,91 @@
+package cli
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+)
+
+// ValidateResult is the -json output of validate for one preseed file
+type ValidateResult struct {
+	File     string           `json:"file"`
+	Valid    bool             `json:"valid"`
+	Findings []config.Finding `json:"findings"`
+}
+
+// validate checks preseed files offline, it fails with ExitInvalid when any of them has errors.
+// Warnings, like the secrets that will be prompted for, only fail it with -strict.
+func (c *command) validate(args []string) int {
+	c.flags.Usage = func() {
+		_, _ = fmt.Fprintf(c.flags.Output(), "usage: %s [-json] [-strict] <preseed file>...\n", c.flags.Name())
+		c.flags.PrintDefaults()
+	}
+	asJson := c.flags.Bool("json", false, "print the findings as JSON, for CI")
+	strict := c.flags.Bool("strict", false, "fail on warnings too")
+	if code, ok := c.parse(args); !ok {
+		return code
+	}
+	if c.flags.NArg() == 0 {
+		c.flags.Usage()
+		return ExitUsage
+	}
+
+	code := ExitOk
+	results := make([]ValidateResult, 0, c.flags.NArg())
+	for _, path := range c.flags.Args() {
+		findings, err := config.LintFile(path)
+		if err != nil {
+			c.printf("%v", err)
+			return ExitUsage
+		}
+		result := ValidateResult{File: path, Valid: !config.HasErrors(findings), Findings: findings}
+		if *strict && len(findings) > 0 {
+			result.Valid = false
+		}
+		if result.Findings == nil {
+			result.Findings = []config.Finding{}
+		}
+		if !result.Valid {
+			code = ExitInvalid
+		}
+		results = append(results, result)
+	}
+
+	if *asJson {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			return c.failed(err)
+		}
+		return code
+	}
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			_, _ = fmt.Fprintln(c.stdout, finding)
+		}
+		if result.Valid {
+			_, _ = fmt.Fprintf(c.stdout, "%s: valid\n", result.File)
+		} else {
+			_, _ = fmt.Fprintf(c.stdout, "%s: invalid\n", result.File)
+		}
+	}
+	return code
+}
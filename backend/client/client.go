@@ -0,0 +1,402 @@
+// Package client talks to the REST API of the installer back-end, as the text mode interface does.
+package client
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+const (
+	ApiPrefix = "/api/v1"
+	// connectTimeout limits connecting to the back-end, including the TLS handshake
+	connectTimeout = 30 * time.Second
+	// responseHeaderTimeout limits the wait for the back-end to answer, the body is not limited:
+	// disk images, diagnostics and the log stream take as long as they take, the context of the call stops them
+	responseHeaderTimeout = time.Minute
+)
+
+// Client calls one back-end, it is safe for concurrent use
+type Client struct {
+	baseUrl    *url.URL
+	httpClient *http.Client
+	token      string
+	// reconnectDelay is the first delay before the log stream is reconnected, it doubles with every attempt
+	reconnectDelay time.Duration
+	reconnects     int
+	// job is the id of the job the client follows, empty for the latest one
+	job string
+}
+
+type Option func(*Client)
+
+// WithToken authenticates to a back-end with API_TOKEN set
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHttpClient replaces the default http.Client, which gives up when the back-end does not answer within a minute
+func WithHttpClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithReconnects sets how often the log stream tries to reconnect after it lost the connection
+func WithReconnects(reconnects int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.reconnects = reconnects
+		c.reconnectDelay = delay
+	}
+}
+
+// New returns a client of the back-end at baseUrl, e.g. http://localhost:5000
+func New(baseUrl string, options ...Option) (*Client, error) {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid back-end URL %q: %w", baseUrl, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid back-end URL %q: the scheme must be http or https", baseUrl)
+	}
+	c := &Client{
+		baseUrl:        u,
+		httpClient:     &http.Client{Transport: newTransport()},
+		reconnectDelay: time.Second,
+		reconnects:     10,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c, nil
+}
+
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	return transport
+}
+
+// BaseUrl is the URL the client was created with
+func (c *Client) BaseUrl() string {
+	return c.baseUrl.String()
+}
+
+// Job returns a client of the same back-end that follows the job with the id, instead of the latest one
+func (c *Client) Job(id string) *Client {
+	job := *c
+	job.job = id
+	return &job
+}
+
+func (c *Client) endpoint(query url.Values, elem ...string) string {
+	u := c.baseUrl.JoinPath(append([]string{ApiPrefix}, elem...)...)
+	if c.job != "" {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("job", c.job)
+	}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// do sends the request and returns the response if it succeeded, otherwise the ApiError from its body
+func (c *Client) do(ctx context.Context, method string, endpoint string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err = ResponseError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// call sends the request and decodes the JSON response into result, unless it is nil
+func (c *Client) call(ctx context.Context, method string, endpoint string, contentType string, body io.Reader, result any) error {
+	resp, err := c.do(ctx, method, endpoint, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, result any) error {
+	return c.call(ctx, http.MethodGet, endpoint, "", nil, result)
+}
+
+func (c *Client) postJson(ctx context.Context, endpoint string, body any, result any) error {
+	var reader io.Reader
+	contentType := ""
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	return c.call(ctx, http.MethodPost, endpoint, contentType, reader, result)
+}
+
+// ResponseError returns nil for a successful response, otherwise the ApiError from its body
+func ResponseError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	var envelope ErrorEnvelope
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || envelope.Error.Code == "" {
+		// not the back-end, or an older one
+		return &ApiError{StatusCode: resp.StatusCode, Code: ErrInternal, Message: resp.Status}
+	}
+	envelope.Error.StatusCode = resp.StatusCode
+	return &envelope.Error
+}
+
+func (c *Client) Login(ctx context.Context) (LoginResponse, error) {
+	var login LoginResponse
+	err := c.get(ctx, c.endpoint(nil, "login"), &login)
+	return login, err
+}
+
+func (c *Client) BlockDevices(ctx context.Context) (BlockDevices, error) {
+	var devices BlockDevices
+	err := c.get(ctx, c.endpoint(nil, "block_devices"), &devices)
+	return devices, err
+}
+
+// Preflight runs the pre-flight checks, the parameters override the pending ones
+func (c *Client) Preflight(ctx context.Context, parameters map[string]string) (PreflightResponse, error) {
+	query := url.Values{}
+	for k, v := range parameters {
+		query.Set(k, v)
+	}
+	var preflight PreflightResponse
+	err := c.get(ctx, c.endpoint(query, "preflight"), &preflight)
+	return preflight, err
+}
+
+// Install starts a job installing on the disk in the parameters, they override the pending ones.
+// The RunId of the result is the id of the job.
+func (c *Client) Install(ctx context.Context, parameters map[string]string) (ActionResult, error) {
+	var result ActionResult
+	err := c.postJson(ctx, c.endpoint(nil, "install"), parameters, &result)
+	return result, err
+}
+
+// DryRun validates the parameters and returns what the installation would do
+func (c *Client) DryRun(ctx context.Context, parameters map[string]string) (InstallPlan, error) {
+	var plan InstallPlan
+	err := c.postJson(ctx, c.endpoint(url.Values{"dry_run": {"1"}}, "install"), parameters, &plan)
+	return plan, err
+}
+
+// Clear stops the job, forgets a finished one or aborts the automatic installation
+func (c *Client) Clear(ctx context.Context) (ActionResult, error) {
+	var result ActionResult
+	err := c.postJson(ctx, c.endpoint(nil, "clear"), nil, &result)
+	return result, err
+}
+
+// Cancel stops the running job or aborts the automatic installation, a finished job is kept
+// and refused with ErrNotRunning
+func (c *Client) Cancel(ctx context.Context) (ActionResult, error) {
+	var result ActionResult
+	err := c.postJson(ctx, c.endpoint(url.Values{"keep_finished": {"1"}}, "clear"), nil, &result)
+	return result, err
+}
+
+func (c *Client) ProcessStatus(ctx context.Context) (ProcessStatus, error) {
+	var status ProcessStatus
+	err := c.get(ctx, c.endpoint(nil, "process_status"), &status)
+	return status, err
+}
+
+// Jobs lists the jobs of the back-end, the oldest first
+func (c *Client) Jobs(ctx context.Context) ([]JobStatus, error) {
+	var jobs []JobStatus
+	err := c.get(ctx, c.endpoint(nil, "jobs"), &jobs)
+	return jobs, err
+}
+
+// DownloadLog returns the whole output of the installer
+func (c *Client) DownloadLog(ctx context.Context) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint(nil, "download_log"), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// DownloadImage writes the finished disk image of the job to w and returns the file name suggested by the back-end
+func (c *Client) DownloadImage(ctx context.Context, w io.Writer) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint(nil, "image"), "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	fileName := "disk.img"
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		fileName = path.Base(params["filename"])
+	}
+	_, err = io.Copy(w, resp.Body)
+	return fileName, err
+}
+
+func (c *Client) Report(ctx context.Context, runId string) (InstallReport, error) {
+	var report InstallReport
+	err := c.get(ctx, c.endpoint(nil, "runs", runId, "report"), &report)
+	return report, err
+}
+
+// Diagnostics downloads the diagnostics bundle and returns it with the file name suggested by the back-end
+func (c *Client) Diagnostics(ctx context.Context) ([]byte, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint(nil, "diagnostics"), "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	fileName := "odi-diagnostics.tar.gz"
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		fileName = path.Base(params["filename"])
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, fileName, err
+}
+
+// Config returns the pending configuration as a preseed in the format ini, json or yaml
+func (c *Client) Config(ctx context.Context, format string, secrets bool) ([]byte, error) {
+	query := url.Values{"format": {format}}
+	if secrets {
+		query.Set("secrets", "1")
+	}
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint(query, "config"), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// PutConfig sends a preseed in the format ini, json or yaml. When it is refused,
+// the reasons are in the ConfigErrors of the returned ApiError.
+func (c *Client) PutConfig(ctx context.Context, data []byte, format string) error {
+	return c.call(ctx, http.MethodPut, c.endpoint(url.Values{"format": {format}}, "config"), "", bytes.NewReader(data), nil)
+}
+
+// RememberConfig writes the non-secret parameters into installer.ini for the next boot
+func (c *Client) RememberConfig(ctx context.Context, parameters map[string]string) error {
+	form := url.Values{}
+	for k, v := range parameters {
+		form.Set(k, v)
+	}
+	return c.call(ctx, http.MethodPost, c.endpoint(nil, "config", "remember"),
+		"application/x-www-form-urlencoded", bytes.NewReader([]byte(form.Encode())), nil)
+}
+
+// MediaLocations lists the directories of the ESP and the USB sticks of the back-end, the ESP first
+func (c *Client) MediaLocations(ctx context.Context) ([]string, error) {
+	var locations []string
+	err := c.get(ctx, c.endpoint(nil, "media"), &locations)
+	return locations, err
+}
+
+// ReadMediaFile reads the file name in one of the MediaLocations
+func (c *Client) ReadMediaFile(ctx context.Context, location string, name string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint(url.Values{"location": {location}, "name": {name}}, "media", "file"), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// WriteMediaFile saves data as the file name in one of the MediaLocations, the back-end writes it as root
+func (c *Client) WriteMediaFile(ctx context.Context, location string, name string, data []byte) error {
+	return c.call(ctx, http.MethodPut, c.endpoint(url.Values{"location": {location}, "name": {name}}, "media", "file"),
+		"application/octet-stream", bytes.NewReader(data), nil)
+}
+
+func (c *Client) AutoInstall(ctx context.Context) (AutoInstallState, error) {
+	var state AutoInstallState
+	err := c.get(ctx, c.endpoint(nil, "auto_install"), &state)
+	return state, err
+}
+
+func (c *Client) AbortAutoInstall(ctx context.Context) error {
+	return c.postJson(ctx, c.endpoint(nil, "auto_install", "abort"), nil, nil)
+}
+
+func (c *Client) Presets(ctx context.Context) ([]Preset, error) {
+	var presets []Preset
+	err := c.get(ctx, c.endpoint(nil, "presets"), &presets)
+	return presets, err
+}
+
+func (c *Client) ApplyPreset(ctx context.Context, id string) error {
+	return c.postJson(ctx, c.endpoint(nil, "presets", id, "apply"), nil, nil)
+}
+
+// Steps lists the steps of an installation with INSTALLER_RUNNER=native
+func (c *Client) Steps(ctx context.Context) ([]StepResult, error) {
+	var steps []StepResult
+	err := c.get(ctx, c.endpoint(nil, "steps"), &steps)
+	return steps, err
+}
+
+func (c *Client) RetryStep(ctx context.Context, name string) error {
+	return c.postJson(ctx, c.endpoint(nil, "steps", name, "retry"), nil, nil)
+}
+
+func (c *Client) SkipStep(ctx context.Context, name string) error {
+	return c.postJson(ctx, c.endpoint(nil, "steps", name, "skip"), nil, nil)
+}
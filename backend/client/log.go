@@ -0,0 +1,104 @@
+package client
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// StreamLog copies the output of the installer to w until the installation finishes.
+// The back-end sends the whole output on every connection, so after a reconnect
+// only the part that was not received yet is written.
+func (c *Client) StreamLog(ctx context.Context, w io.Writer) error {
+	var received int64
+	delay := c.reconnectDelay
+	failures := 0
+	for {
+		n, err := c.streamOnce(ctx, w, received)
+		received += n
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if n > 0 {
+			failures, delay = 0, c.reconnectDelay
+		}
+		if err == nil {
+			// the back-end closes the connection when the installation finishes,
+			// anything else is a lost connection
+			status, statusErr := c.ProcessStatus(ctx)
+			var apiErr *ApiError
+			if statusErr == nil && status.Status != StatusRunning {
+				return nil
+			}
+			if errors.As(statusErr, &apiErr) && apiErr.Code == ErrNotRunning {
+				return nil
+			}
+			err = statusErr
+		}
+		failures++
+		if failures > c.reconnects {
+			return fmt.Errorf("lost the log stream: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// streamOnce copies one connection to w, skipping the first skip bytes, and returns how many bytes it wrote
+func (c *Client) streamOnce(ctx context.Context, w io.Writer, skip int64) (int64, error) {
+	wsUrl := c.baseUrl.JoinPath(ApiPrefix, "process_output")
+	if c.job != "" {
+		wsUrl.RawQuery = url.Values{"job": {c.job}}.Encode()
+	}
+	if c.baseUrl.Scheme == "https" {
+		wsUrl.Scheme = "wss"
+	} else {
+		wsUrl.Scheme = "ws"
+	}
+	config, err := websocket.NewConfig(wsUrl.String(), c.baseUrl.String())
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		config.Header = http.Header{"Authorization": {"Bearer " + c.token}}
+	}
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = ws.Close() })
+	defer stop()
+	defer ws.Close()
+	if _, err = io.CopyN(io.Discard, ws, skip); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, ws)
+}
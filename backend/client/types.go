@@ -0,0 +1,343 @@
+package client
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// The types of the request and response bodies, they are kept in sync with the back-end by its tests.
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ErrorCode tells what went wrong in a failed request
+type ErrorCode string
+
+const (
+	ErrInternal       ErrorCode = "internal"
+	ErrBadRequest     ErrorCode = "bad_request"
+	ErrInvalidConfig  ErrorCode = "invalid_config"
+	ErrAlreadyRunning ErrorCode = "already_running"
+	ErrNotRunning     ErrorCode = "not_running"
+	ErrNotFound       ErrorCode = "not_found"
+	ErrNotSupported   ErrorCode = "not_supported"
+	ErrConflict       ErrorCode = "conflict"
+	ErrUnauthorized   ErrorCode = "unauthorized"
+)
+
+// ApiError is returned by the methods of the Client when the back-end refuses a request
+type ApiError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int             `json:"-"`
+	Code       ErrorCode       `json:"code"`
+	Message    string          `json:"message"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+func (e *ApiError) Error() string {
+	return e.Message
+}
+
+// ConfigErrors returns the details of an ErrInvalidConfig error
+func (e *ApiError) ConfigErrors() []ConfigError {
+	var configErrors []ConfigError
+	if e.Code == ErrInvalidConfig {
+		_ = json.Unmarshal(e.Details, &configErrors)
+	}
+	return configErrors
+}
+
+type ErrorEnvelope struct {
+	Error ApiError `json:"error"`
+}
+
+// Model is the body of an installation request with the parameters the text mode interface edits.
+// when adding fields here, you need to add them to formValues() in tui/rest.go too
+type Model struct {
+	Disk          string `json:"DISK"`
+	TargetType    string `json:"TARGET_TYPE"`
+	ImageSize     string `json:"IMAGE_SIZE"`
+	ImageFormat   string `json:"IMAGE_FORMAT"`
+	DebianVersion string `json:"DEBIAN_VERSION"`
+	Username      string `json:"USERNAME"`
+	UserFullName  string `json:"USER_FULL_NAME"`
+	UserPassword  string `json:"USER_PASSWORD"`
+	RootPassword  string `json:"ROOT_PASSWORD"`
+	DisableLuks   string `json:"DISABLE_LUKS"`
+	LuksPassword  string `json:"LUKS_PASSWORD"`
+	EnableMokUki  string `json:"ENABLE_MOK_SIGNED_UKI"`
+	MokPassword   string `json:"MOK_ENROLL_PASSWORD"`
+	EnableTpm     string `json:"ENABLE_TPM"`
+	Hostname      string `json:"HOSTNAME"`
+	Timezone      string `json:"TIMEZONE"`
+	SwapSize      string `json:"SWAP_SIZE"`
+	NvidiaPackage string `json:"NVIDIA_PACKAGE"`
+	EnablePopcon  string `json:"ENABLE_POPCON"`
+	EnableFlathub string `json:"ENABLE_FLATHUB"`
+}
+
+type ActionResult struct {
+	Status string `json:"status"`
+	RunId  string `json:"run_id,omitempty"`
+}
+
+type ParameterSource string
+
+const (
+	SourceDefault     ParameterSource = "default"
+	SourceEnvironment ParameterSource = "environment"
+	SourceIni         ParameterSource = "ini"
+	SourceUrl         ParameterSource = "url"
+	SourceCmdline     ParameterSource = "cmdline"
+	SourcePreset      ParameterSource = "preset"
+	SourceUser        ParameterSource = "user"
+)
+
+type ConfigError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+}
+
+type AutoInstallState struct {
+	Pending   bool   `json:"pending"`
+	Remaining int    `json:"remaining"`
+	Disk      string `json:"disk"`
+	Aborted   bool   `json:"aborted"`
+	Error     string `json:"error,omitempty"`
+}
+
+type LoginResponse struct {
+	Hostname  string `json:"hostname"`
+	HasEfi    bool   `json:"has_efi"`
+	HasNvidia bool   `json:"has_nvidia"`
+	SBState   string `json:"sb_state"`
+	Running   bool   `json:"running"`
+	// Environ are the pending installation parameters
+	Environ          map[string]string          `json:"environ"`
+	ConfigErrors     []ConfigError              `json:"config_errors"`
+	ParameterSources map[string]ParameterSource `json:"parameter_sources"`
+	AutoInstall      AutoInstallState           `json:"auto_install"`
+}
+
+// BlockDevices is the part of the lsblk output the installer uses
+type BlockDevices struct {
+	Blockdevices []BlockDevice `json:"blockdevices"`
+}
+
+type BlockDevice struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Model  string `json:"model"`
+	Serial string `json:"serial"`
+	Size   string `json:"size"`
+}
+
+type PreflightStatus string
+
+const (
+	PreflightPass PreflightStatus = "PASS"
+	PreflightWarn PreflightStatus = "WARN"
+	PreflightFail PreflightStatus = "FAIL"
+)
+
+type PreflightResult struct {
+	Name    string          `json:"name"`
+	Status  PreflightStatus `json:"status"`
+	Message string          `json:"message"`
+}
+
+type PreflightResponse struct {
+	Passed  bool              `json:"passed"`
+	Results []PreflightResult `json:"results"`
+}
+
+type InstallPlan struct {
+	Valid             bool              `json:"valid"`
+	Issues            []ParameterIssue  `json:"issues"`
+	Parameters        map[string]string `json:"parameters"`
+	RepartDefinitions []PlanFile        `json:"repart_definitions"`
+	Fstab             PlanFile          `json:"fstab"`
+	KernelCommandLine string            `json:"kernel_command_line"`
+	Sources           []PlanFile        `json:"sources"`
+	Packages          PackageLists      `json:"packages"`
+}
+
+type ParameterIssue struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+type PlanFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+type PackageLists struct {
+	Base      []string `json:"base"`
+	Backports []string `json:"backports"`
+	Optional  []string `json:"optional"`
+}
+
+type Progress struct {
+	Step    string `json:"step"`
+	Current int    `json:"current"`
+	Total   int    `json:"total"`
+}
+
+type VerificationCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// InstallError explains why an installation failed
+type InstallError struct {
+	ExitCode int      `json:"exit_code"`
+	Step     string   `json:"step"`
+	Cause    string   `json:"cause"`
+	Remedy   string   `json:"remedy"`
+	Excerpt  []string `json:"excerpt"`
+}
+
+const (
+	StatusRunning   = "RUNNING"
+	StatusVerifying = "VERIFYING"
+	StatusFinished  = "FINISHED"
+)
+
+type ProcessStatus struct {
+	Status       string              `json:"status"`
+	Output       string              `json:"output"`
+	ReturnCode   int                 `json:"return_code"`
+	Command      string              `json:"command"`
+	Progress     Progress            `json:"progress"`
+	RunId        string              `json:"run_id"`
+	Verification []VerificationCheck `json:"verification,omitempty"`
+	Error        *InstallError       `json:"error,omitempty"`
+}
+
+// Failed tells whether the installation or the verification of the installed system failed
+func (s ProcessStatus) Failed() bool {
+	if s.Status != StatusFinished {
+		return false
+	}
+	if s.ReturnCode != 0 {
+		return true
+	}
+	for _, check := range s.Verification {
+		if !check.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+// JobStatus is one of the installations of a back-end, each on its own disk
+type JobStatus struct {
+	Id         string    `json:"id"`
+	Disk       string    `json:"disk"`
+	Hostname   string    `json:"hostname"`
+	Status     string    `json:"status"`
+	ReturnCode int       `json:"return_code"`
+	Progress   Progress  `json:"progress"`
+	Started    time.Time `json:"started"`
+	Resources  Resources `json:"resources"`
+	// Image is the finished disk image of an installation with TARGET_TYPE image
+	Image string `json:"image,omitempty"`
+}
+
+// Resources are the disk, working directory, mount points and device mapper name a job uses exclusively
+type Resources struct {
+	Disk          string `json:"disk"`
+	WorkDir       string `json:"work_dir"`
+	Target        string `json:"target"`
+	TopLevelMount string `json:"top_level_mount"`
+	LuksDevice    string `json:"luks_device"`
+}
+
+type InstallReport struct {
+	RunId         string              `json:"run_id"`
+	Disk          string              `json:"disk"`
+	Started       time.Time           `json:"started"`
+	Finished      *time.Time          `json:"finished,omitempty"`
+	ExitCode      *int                `json:"exit_code,omitempty"`
+	Parameters    map[string]string   `json:"parameters"`
+	System        *InstalledSystem    `json:"system,omitempty"`
+	MokEnrollment string              `json:"mok_enrollment"`
+	Steps         []StepTiming        `json:"steps"`
+	Verification  []VerificationCheck `json:"verification,omitempty"`
+	Warnings      []string            `json:"warnings"`
+}
+
+type InstalledSystem struct {
+	EfiPartUuid    string   `json:"efi_part_uuid"`
+	MainPartUuid   string   `json:"main_part_uuid"`
+	LuksUuid       string   `json:"luks_uuid,omitempty"`
+	BtrfsUuid      string   `json:"btrfs_uuid"`
+	KernelCmdline  string   `json:"kernel_cmdline"`
+	SwapfileOffset string   `json:"swapfile_offset,omitempty"`
+	MokKeysPending int      `json:"mok_keys_pending"`
+	Packages       []string `json:"packages"`
+}
+
+type StepTiming struct {
+	Name     string    `json:"name"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+}
+
+type Preset struct {
+	Id         string            `json:"id"`
+	Device     string            `json:"device"`
+	Label      string            `json:"label"`
+	File       string            `json:"file"`
+	Parameters map[string]string `json:"parameters"`
+	Errors     []ConfigError     `json:"errors"`
+}
+
+type StepStatus string
+
+const (
+	StepPending StepStatus = "PENDING"
+	StepRunning StepStatus = "RUNNING"
+	StepDone    StepStatus = "DONE"
+	StepSkipped StepStatus = "SKIPPED"
+	StepFailed  StepStatus = "FAILED"
+)
+
+type StepResult struct {
+	Name     string     `json:"name"`
+	Requires []string   `json:"requires"`
+	Status   StepStatus `json:"status"`
+	Attempts int        `json:"attempts"`
+	Started  time.Time  `json:"started"`
+	Finished time.Time  `json:"finished"`
+	Error    string     `json:"error,omitempty"`
+	Output   string     `json:"output"`
+}
@@ -0,0 +1,231 @@
+package config
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParameterSource tells where the effective value of a parameter came from, later sources override earlier ones
+type ParameterSource string
+
+const (
+	SourceDefault     ParameterSource = "default"
+	SourceEnvironment ParameterSource = "environment"
+	SourceIni         ParameterSource = "ini"
+	SourceUrl         ParameterSource = "url"
+	SourceCmdline     ParameterSource = "cmdline"
+	SourcePreset      ParameterSource = "preset"
+	SourceUser        ParameterSource = "user"
+)
+
+const (
+	KernelCmdlinePath     = "/proc/cmdline"
+	kernelCmdlinePrefix   = "odi."
+	defaultPreseedRetries = 5
+)
+
+var preseedRetryDelay = 2 * time.Second
+
+// KernelCmdline holds the odi.* options of the kernel command line:
+//
+//	odi.preseed=<url>           http, https or file url of a preseed in the -config format
+//	odi.preseed.sha256=<hex>    expected checksum of the preseed
+//	odi.preseed.key=<base64>    ed25519 public key, the signature is downloaded from <url>.sig
+//	odi.preseed.retries=<n>     number of attempts to download the preseed
+//	odi.<KEY>=<value>           overrides a single parameter, e.g. odi.DISK=/dev/vda
+type KernelCmdline struct {
+	PreseedUrl string
+	Sha256     string
+	PublicKey  string
+	Retries    int
+	Overrides  []Entry
+	Errors     []ConfigError
+}
+
+// splitKernelCmdline splits the command line on spaces, except inside double quotes
+func splitKernelCmdline(cmdline string) []string {
+	var words []string
+	var word strings.Builder
+	quoted := false
+	inWord := false
+	for _, c := range cmdline {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inWord = true
+		case !quoted && (c == ' ' || c == '\t' || c == '\n'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+func ParseKernelCmdline(cmdline string) KernelCmdline {
+	k := KernelCmdline{Retries: defaultPreseedRetries}
+	for _, word := range splitKernelCmdline(cmdline) {
+		name, value, _ := strings.Cut(word, "=")
+		option, found := strings.CutPrefix(name, kernelCmdlinePrefix)
+		if !found {
+			continue
+		}
+		switch option {
+		case "preseed":
+			k.PreseedUrl = value
+		case "preseed.sha256":
+			k.Sha256 = strings.ToLower(value)
+		case "preseed.key":
+			k.PublicKey = value
+		case "preseed.retries":
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 1 {
+				k.Errors = append(k.Errors, ConfigError{KernelCmdlinePath, 0, name, fmt.Sprintf("%s must be a positive number", name)})
+				continue
+			}
+			k.Retries = retries
+		default:
+			k.Overrides = append(k.Overrides, Entry{Key: strings.ToUpper(option), Value: value})
+		}
+	}
+	return k
+}
+
+// RedactUrl hides the credentials a preseed url may carry for the logs and the configuration errors:
+// the user info and the values of the query
+func RedactUrl(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(invalid url)"
+	}
+	if u.User != nil {
+		u.User = url.User("xxxxx")
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		var redacted []string
+		for _, k := range slices.Sorted(maps.Keys(query)) {
+			redacted = append(redacted, url.QueryEscape(k)+"=xxxxx")
+		}
+		u.RawQuery = strings.Join(redacted, "&")
+	}
+	return u.String()
+}
+
+// FetchPreseed downloads the preseed, retrying on errors, and verifies its checksum and signature
+func FetchPreseed(ctx context.Context, client *http.Client, k KernelCmdline) ([]byte, error) {
+	u, err := url.Parse(k.PreseedUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid preseed url: %w", err)
+	}
+	var data []byte
+	for attempt := 1; ; attempt++ {
+		data, err = fetchUrl(ctx, client, u)
+		if err == nil {
+			break
+		}
+		if attempt >= k.Retries {
+			return nil, fmt.Errorf("failed to fetch the preseed after %d attempts: %w", attempt, err)
+		}
+		slog.Warn("failed to fetch the preseed, retrying", "url", RedactUrl(k.PreseedUrl), "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(preseedRetryDelay):
+		}
+	}
+
+	if k.Sha256 != "" {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != k.Sha256 {
+			return nil, fmt.Errorf("preseed checksum mismatch")
+		}
+	}
+	if k.PublicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(k.PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid preseed public key")
+		}
+		sigUrl := *u
+		sigUrl.Path += ".sig"
+		sig, err := fetchUrl(ctx, client, &sigUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch the preseed signature: %w", err)
+		}
+		sig, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+		if err != nil || !ed25519.Verify(key, data, sig) {
+			return nil, fmt.Errorf("invalid preseed signature")
+		}
+	}
+	return data, nil
+}
+
+func fetchUrl(ctx context.Context, client *http.Client, u *url.URL) ([]byte, error) {
+	switch u.Scheme {
+	case "file":
+		return os.ReadFile(u.Path)
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			// the error repeats the url, with the token in its query
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				urlErr.URL = RedactUrl(urlErr.URL)
+			}
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	default:
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,438 @@
+package config
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ConfigFormat string
+
+const (
+	ConfigIni  ConfigFormat = "ini"
+	ConfigJson ConfigFormat = "json"
+	ConfigYaml ConfigFormat = "yaml"
+)
+
+// ConfigError is a problem found in a configuration file, Line is 0 when it applies to the whole file
+type ConfigError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+}
+
+type Entry struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// FormatOf guesses the format from the file name, installer.ini and anything unknown is INI
+func FormatOf(path string) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ConfigJson
+	case ".yaml", ".yml":
+		return ConfigYaml
+	default:
+		return ConfigIni
+	}
+}
+
+// LoadFile reads the configuration file. The returned parameters only contain the valid entries,
+// the problems with the other ones are returned as ConfigErrors.
+func LoadFile(path string) (map[string]string, []ConfigError, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	parameters, configErrors := Parse(f, path, FormatOf(path))
+	return parameters, configErrors, nil
+}
+
+func Parse(r io.Reader, name string, format ConfigFormat) (map[string]string, []ConfigError) {
+	entries, configErrors := parseEntries(r, name, format)
+	parameters, entryErrors := ValidateEntries(entries, name)
+	return parameters, append(configErrors, entryErrors...)
+}
+
+func parseEntries(r io.Reader, name string, format ConfigFormat) ([]Entry, []ConfigError) {
+	switch format {
+	case ConfigJson:
+		return parseJsonConfig(r, name)
+	case ConfigYaml:
+		return parseYamlConfig(r, name)
+	default:
+		return parseIniConfig(r, name)
+	}
+}
+
+// ValidateEntries checks the keys and the types of the values, the invalid entries are left out
+func ValidateEntries(entries []Entry, name string) (map[string]string, []ConfigError) {
+	var configErrors []ConfigError
+	parameters := make(map[string]string)
+	for _, entry := range entries {
+		if _, found := FindParameter(entry.Key); !found {
+			configErrors = append(configErrors, ConfigError{name, entry.Line, entry.Key, fmt.Sprintf("unknown key %s", entry.Key)})
+			continue
+		}
+		if err := CheckValue(entry.Key, entry.Value); err != nil {
+			configErrors = append(configErrors, ConfigError{name, entry.Line, entry.Key, fmt.Sprintf("%s %v", entry.Key, err)})
+			continue
+		}
+		parameters[entry.Key] = entry.Value
+	}
+	return parameters, configErrors
+}
+
+// parseIniConfig reads KEY=VALUE lines the same way systemd EnvironmentFile does:
+// lines starting with ; or # are comments and values can be enclosed in single or double quotes
+func parseIniConfig(r io.Reader, name string) ([]Entry, []ConfigError) {
+	var entries []Entry
+	var configErrors []ConfigError
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			configErrors = append(configErrors, ConfigError{name, lineNumber, "", fmt.Sprintf("expected KEY=VALUE, not %q", line)})
+			continue
+		}
+		value, err := unquoteIniValue(strings.TrimSpace(value))
+		if err != nil {
+			configErrors = append(configErrors, ConfigError{name, lineNumber, key, fmt.Sprintf("%s %v", key, err)})
+			continue
+		}
+		entries = append(entries, Entry{key, value, lineNumber})
+	}
+	if err := scanner.Err(); err != nil {
+		configErrors = append(configErrors, ConfigError{name, lineNumber + 1, "", err.Error()})
+	}
+	return entries, configErrors
+}
+
+func unquoteIniValue(value string) (string, error) {
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return value, nil
+	}
+	quote := value[0]
+	var unquoted strings.Builder
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == quote:
+			if i != len(value)-1 {
+				return "", fmt.Errorf("has text after the closing quote")
+			}
+			return unquoted.String(), nil
+		case c == '\\' && quote == '"' && i+1 < len(value):
+			i++
+			unquoted.WriteByte(value[i])
+		default:
+			unquoted.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("is missing the closing quote")
+}
+
+// parseJsonConfig reads a flat JSON object, the values can be strings, booleans or numbers
+func parseJsonConfig(r io.Reader, name string) ([]Entry, []ConfigError) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, []ConfigError{{name, 0, "", err.Error()}}
+	}
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if t, err := decoder.Token(); err != nil || t != json.Delim('{') {
+		return nil, []ConfigError{{name, lineAt(decoder.InputOffset()), "", "expected a JSON object"}}
+	}
+	var entries []Entry
+	var configErrors []ConfigError
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return entries, append(configErrors, ConfigError{name, lineAt(decoder.InputOffset()), "", err.Error()})
+		}
+		key := t.(string)
+		line := lineAt(decoder.InputOffset())
+		var value any
+		if err = decoder.Decode(&value); err != nil {
+			return entries, append(configErrors, ConfigError{name, line, key, err.Error()})
+		}
+		switch v := value.(type) {
+		case nil:
+			entries = append(entries, Entry{key, "", line})
+		case string:
+			entries = append(entries, Entry{key, v, line})
+		case bool:
+			entries = append(entries, Entry{key, strconv.FormatBool(v), line})
+		case json.Number:
+			entries = append(entries, Entry{key, v.String(), line})
+		default:
+			configErrors = append(configErrors, ConfigError{name, line, key, fmt.Sprintf("%s must be a string, boolean or number", key)})
+		}
+	}
+	return entries, configErrors
+}
+
+// parseYamlConfig reads a flat YAML mapping with scalar values
+func parseYamlConfig(r io.Reader, name string) ([]Entry, []ConfigError) {
+	var document yaml.Node
+	err := yaml.NewDecoder(r).Decode(&document)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, []ConfigError{{name, 0, "", err.Error()}}
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, []ConfigError{{name, mapping.Line, "", "expected a YAML mapping"}}
+	}
+	var entries []Entry
+	var configErrors []ConfigError
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			configErrors = append(configErrors, ConfigError{name, value.Line, key.Value, fmt.Sprintf("%s must be a string, boolean or number", key.Value)})
+			continue
+		}
+		v := value.Value
+		if value.Tag == "!!null" {
+			v = ""
+		}
+		entries = append(entries, Entry{key.Value, v, key.Line})
+	}
+	return entries, configErrors
+}
+
+// Environ reads the environment of the back-end, values can contain =
+func Environ() map[string]string {
+	parameters := make(map[string]string)
+	for _, s := range os.Environ() {
+		key, value, _ := strings.Cut(s, "=")
+		parameters[key] = value
+	}
+	return parameters
+}
+
+// configParameters picks the known parameters, in the order of their definitions
+func configParameters(parameters map[string]string, includeSecrets bool) []Entry {
+	var entries []Entry
+	for _, p := range Definitions {
+		value, found := parameters[p.Name]
+		if !found || (p.Secret && !includeSecrets) {
+			continue
+		}
+		entries = append(entries, Entry{Key: p.Name, Value: value})
+	}
+	return entries
+}
+
+// Render writes the parameters in the format LoadFile reads, secrets are left out unless includeSecrets
+func Render(parameters map[string]string, format ConfigFormat, includeSecrets bool) ([]byte, error) {
+	entries := configParameters(parameters, includeSecrets)
+	var out bytes.Buffer
+	switch format {
+	case ConfigJson:
+		out.WriteString("{")
+		for i, entry := range entries {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			key, _ := json.Marshal(entry.Key)
+			value, typed := typedValue(entry)
+			if !typed {
+				value, _ = json.Marshal(entry.Value)
+			}
+			_, _ = fmt.Fprintf(&out, "\n  %s: %s", key, value)
+		}
+		out.WriteString("\n}\n")
+	case ConfigYaml:
+		mapping := &yaml.Node{Kind: yaml.MappingNode}
+		for _, entry := range entries {
+			value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: entry.Value}
+			if typed, found := typedValue(entry); found {
+				value.Tag, value.Value = "", string(typed)
+			}
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: entry.Key}, value)
+		}
+		err := yaml.NewEncoder(&out).Encode(mapping)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		out.WriteString("; opinionated debian installer configuration file\n")
+		for _, entry := range entries {
+			_, _ = fmt.Fprintf(&out, "%s=%s\n", entry.Key, quoteIniValue(entry.Value))
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// typedValue formats valid booleans and numbers the way they are written without quotes in JSON and YAML,
+// a number like 016 or +5 is accepted by the installer but is not valid JSON
+func typedValue(entry Entry) ([]byte, bool) {
+	p, _ := FindParameter(entry.Key)
+	if p.Type == ParameterString || entry.Value == "" || CheckValue(entry.Key, entry.Value) != nil {
+		return nil, false
+	}
+	if p.Type == ParameterInt {
+		n, _ := strconv.Atoi(entry.Value)
+		return []byte(strconv.Itoa(n)), true
+	}
+	b, _ := strconv.ParseBool(entry.Value)
+	return []byte(strconv.FormatBool(b)), true
+}
+
+func quoteIniValue(value string) string {
+	if !strings.ContainsAny(value, " \t\"'\\;#") {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// rememberedComment introduces the keys that were not in the file before
+const rememberedComment = "; remembered by the installer"
+
+// UpdateIniFile writes the values into the INI file, keeping a backup of the previous version in .bak
+func UpdateIniFile(path string, values map[string]string) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = os.WriteFile(path+".bak", content, 0600); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+	}
+	return os.WriteFile(path, updateIni(content, values), 0600)
+}
+
+// updateIni changes the values of the keys in place, keeping the comments and the order of the lines.
+// A key that is only commented out, like ;DISK=/dev/vda, is uncommented. Keys not mentioned at all are appended.
+func updateIni(content []byte, values map[string]string) []byte {
+	newline := "\n"
+	if bytes.Contains(content, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+	lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n"), "\n")
+	if len(content) == 0 {
+		lines = nil
+	}
+
+	active := make(map[string]bool)
+	for _, line := range lines {
+		if key, ok := iniLineKey(line, false); ok {
+			active[key] = true
+		}
+	}
+	written := make(map[string]bool)
+	for i, line := range lines {
+		key, ok := iniLineKey(line, false)
+		if !ok {
+			key, ok = iniLineKey(line, true)
+			// uncomment the first commented-out example, unless the key is set elsewhere or would stay empty
+			if !ok || active[key] || values[key] == "" {
+				continue
+			}
+		}
+		value, found := values[key]
+		if !found || written[key] {
+			continue
+		}
+		lines[i] = key + "=" + quoteIniValue(value)
+		written[key] = true
+	}
+
+	var missing []string
+	for key := range values {
+		if !written[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		// keep the order of the definitions, like Render does
+		lines = append(lines, "", rememberedComment)
+		for _, entry := range configParameters(values, true) {
+			if slices.Contains(missing, entry.Key) {
+				lines = append(lines, entry.Key+"="+quoteIniValue(entry.Value))
+			}
+		}
+	}
+	return []byte(strings.Join(lines, newline) + newline)
+}
+
+// iniLineKey returns the key set on the line, or on a commented-out line when commented is true
+func iniLineKey(line string, commented bool) (string, bool) {
+	line = strings.TrimSpace(line)
+	if commented {
+		uncommented := strings.TrimLeft(line, ";#")
+		if uncommented == line {
+			return "", false
+		}
+		line = strings.TrimSpace(uncommented)
+	} else if strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	key, value, found := strings.Cut(line, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" || strings.ContainsAny(key, " \t") {
+		return "", false
+	}
+	// an example has a bare value, "; IMAGE_FORMAT=qcow2 converts the image" is documentation
+	if value = strings.TrimSpace(value); commented && strings.ContainsAny(value, " \t") && !isQuoted(value) {
+		return "", false
+	}
+	return key, true
+}
+
+func isQuoted(value string) bool {
+	return len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0]
+}
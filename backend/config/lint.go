@@ -0,0 +1,142 @@
+package config
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+type Severity string
+
+const (
+	// SeverityError is a problem that makes the installation fail or refuse to start
+	SeverityError Severity = "error"
+	// SeverityWarning is a value that will be ignored or asked for, the installation can still succeed
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a problem Lint found in a preseed, Line is 0 when the key is missing from the file
+type Finding struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Key      string   `json:"key,omitempty"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", f.File, f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", f.File, f.Line, f.Severity, f.Message)
+}
+
+// LintFile checks the preseed file without a back-end, see Lint
+func LintFile(path string) ([]Finding, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Lint(f, path, FormatOf(path)), nil
+}
+
+// Lint checks a preseed with the same definitions and rules the back-end uses for an installation.
+// On top of those, it reports the secrets the user will be prompted for (or that nobody will
+// enter during AUTO_INSTALL) and the options that contradict each other or are ignored.
+func Lint(r io.Reader, name string, format ConfigFormat) []Finding {
+	entries, configErrors := parseEntries(r, name, format)
+	parameters, entryErrors := ValidateEntries(entries, name)
+	var findings []Finding
+	for _, e := range append(configErrors, entryErrors...) {
+		findings = append(findings, Finding{File: e.File, Line: e.Line, Key: e.Key, Severity: SeverityError, Message: e.Message})
+	}
+
+	lines := make(map[string]int)
+	for _, entry := range entries {
+		lines[entry.Key] = entry.Line
+	}
+	add := func(key string, severity Severity, format string, args ...any) {
+		findings = append(findings, Finding{File: name, Line: lines[key], Key: key, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+	autoInstall := parameters["AUTO_INSTALL"] == "true"
+
+	for _, issue := range Validate(parameters) {
+		switch {
+		case parameters[issue.Key] != "":
+			add(issue.Key, SeverityError, "%s: %s", issue.Key, issue.Message)
+		case issue.Key == "DISK" && autoInstall && parameters["DISK_SERIAL"] != "":
+			// the automatic installation finds the disk by its serial number
+		case autoInstall:
+			add(issue.Key, SeverityError, "%s: %s, nobody will be asked for it during AUTO_INSTALL", issue.Key, issue.Message)
+		default:
+			add(issue.Key, SeverityWarning, "%s: %s, it will be prompted for", issue.Key, issue.Message)
+		}
+	}
+	for _, key := range []string{"ROOT_PASSWORD", "USER_PASSWORD"} {
+		if parameters[key] != "" || (key == "USER_PASSWORD" && parameters["USERNAME"] == "") {
+			continue
+		}
+		if autoInstall {
+			add(key, SeverityWarning, "%s is not set, the account will have no password during AUTO_INSTALL", key)
+		} else {
+			add(key, SeverityWarning, "%s is not set, it will be prompted for", key)
+		}
+	}
+
+	if parameters["DISABLE_LUKS"] == "true" && parameters["LUKS_PASSWORD"] != "" {
+		add("LUKS_PASSWORD", SeverityWarning, "LUKS_PASSWORD is ignored because DISABLE_LUKS is true")
+	}
+	if parameters["MOK_ENROLL_PASSWORD"] != "" && parameters["ENABLE_MOK_SIGNED_UKI"] != "true" {
+		add("MOK_ENROLL_PASSWORD", SeverityWarning, "MOK_ENROLL_PASSWORD is ignored unless ENABLE_MOK_SIGNED_UKI is true")
+	}
+	if !autoInstall {
+		for _, key := range []string{"AUTO_INSTALL_COUNTDOWN", "DISK_SERIAL"} {
+			if parameters[key] != "" {
+				add(key, SeverityWarning, "%s is ignored unless AUTO_INSTALL is true", key)
+			}
+		}
+	}
+	if parameters["TARGET_TYPE"] != TargetImage {
+		for _, key := range []string{"IMAGE_SIZE", "IMAGE_FORMAT"} {
+			if parameters[key] != "" {
+				add(key, SeverityWarning, "%s is ignored unless TARGET_TYPE is %s", key, TargetImage)
+			}
+		}
+	}
+	switch runner := parameters["INSTALLER_RUNNER"]; {
+	case runner == RunnerRemote && parameters["INSTALLER_REMOTE_URL"] == "":
+		add("INSTALLER_RUNNER", SeverityError, "INSTALLER_RUNNER %s needs INSTALLER_REMOTE_URL", runner)
+	case runner != RunnerRemote && parameters["INSTALLER_REMOTE_URL"] != "":
+		add("INSTALLER_REMOTE_URL", SeverityWarning, "INSTALLER_REMOTE_URL is ignored unless INSTALLER_RUNNER is %s", RunnerRemote)
+	}
+	return findings
+}
+
+// HasErrors is true when the installation would fail or refuse to start with the linted preseed
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+// Package config defines the installation parameters and reads and writes them as INI, JSON and YAML preseeds and from the kernel command line.
+package config
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	_ "embed"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type ParameterType string
+
+const (
+	ParameterString ParameterType = "string"
+	ParameterBool   ParameterType = "bool"
+	ParameterInt    ParameterType = "int"
+)
+
+type Parameter struct {
+	Name        string        `json:"name"`
+	Type        ParameterType `json:"type"`
+	Secret      bool          `json:"secret"`
+	Backend     bool          `json:"backend"`
+	Allowed     []string      `json:"allowed,omitempty"`
+	Description string        `json:"description"`
+}
+
+// Definitions lists everything installer.sh understands, plus the settings of the back-end itself.
+// when adding parameters to installer.sh, add them here too
+var Definitions = []Parameter{
+	{Name: "DISK", Type: ParameterString, Description: "device to install to, it will be overwritten"},
+	{Name: "TARGET_TYPE", Type: ParameterString, Allowed: []string{TargetDisk, TargetImage},
+		Description: "install to a disk, or to the disk image file in DISK"},
+	{Name: "IMAGE_SIZE", Type: ParameterInt, Description: "size of the disk image file in GB"},
+	{Name: "IMAGE_FORMAT", Type: ParameterString, Allowed: []string{ImageRaw, ImageQcow2},
+		Description: "format of the finished disk image, qcow2 needs qemu-img"},
+	{Name: "DEBIAN_VERSION", Type: ParameterString, Allowed: []string{"trixie"}, Description: "debian release to install"},
+	{Name: "USERNAME", Type: ParameterString, Description: "name of the regular user"},
+	{Name: "USER_FULL_NAME", Type: ParameterString, Description: "full name of the regular user"},
+	{Name: "USER_PASSWORD", Type: ParameterString, Secret: true, Description: "password of the regular user"},
+	{Name: "ROOT_PASSWORD", Type: ParameterString, Secret: true, Description: "password of the root user"},
+	{Name: "DISABLE_LUKS", Type: ParameterBool, Description: "do not encrypt the root partition"},
+	{Name: "LUKS_PASSWORD", Type: ParameterString, Secret: true, Description: "disk encryption passphrase"},
+	{Name: "ENABLE_MOK_SIGNED_UKI", Type: ParameterBool, Description: "generate a machine owner key and sign the unified kernel image"},
+	{Name: "MOK_ENROLL_PASSWORD", Type: ParameterString, Secret: true, Description: "one-time password to enroll the machine owner key"},
+	{Name: "ENABLE_TPM", Type: ParameterBool, Description: "unlock the disk with the TPM"},
+	{Name: "HOSTNAME", Type: ParameterString, Description: "hostname of the installed system"},
+	{Name: "TIMEZONE", Type: ParameterString, Description: "time zone of the installed system"},
+	{Name: "SWAP_SIZE", Type: ParameterInt, Description: "size of the swap file in GB, 0 to disable"},
+	{Name: "NVIDIA_PACKAGE", Type: ParameterString, Description: "nvidia driver package to install, empty to skip"},
+	{Name: "ENABLE_POPCON", Type: ParameterBool, Description: "participate in the debian package usage survey"},
+	{Name: "ENABLE_FLATHUB", Type: ParameterBool, Description: "install flatpak and enable flathub"},
+	{Name: "LOCALE", Type: ParameterString, Description: "locale of the installed system"},
+	{Name: "SSH_PUBLIC_KEY", Type: ParameterString, Description: "ssh public key for the user and root, also installs openssh-server"},
+	{Name: "AFTER_INSTALLED_CMD", Type: ParameterString, Description: "command to execute after the installation is finished"},
+	{Name: "AUTO_INSTALL", Type: ParameterBool, Backend: true, Description: "start the installation without user intervention"},
+	{Name: "AUTO_INSTALL_COUNTDOWN", Type: ParameterInt, Backend: true, Description: "seconds to wait before the automatic installation starts"},
+	{Name: "DISK_SERIAL", Type: ParameterString, Backend: true, Description: "serial number of the disk for the automatic installation"},
+	{Name: "VERIFY_INSTALL", Type: ParameterBool, Backend: true, Description: "mount the installed system again after a successful installation and check that it can boot"},
+	{Name: "NOTIFY_WEBHOOK_URL", Type: ParameterString, Backend: true, Description: "URL the installation events are posted to as JSON"},
+	{Name: "NOTIFY_FILE", Type: ParameterString, Backend: true, Description: "file the installation events are appended to, one JSON document per line"},
+	{Name: "NOTIFY_COMMAND", Type: ParameterString, Backend: true, Description: "shell command run for every installation event, with the JSON on its standard input"},
+	{Name: "NOTIFY_RETRIES", Type: ParameterInt, Backend: true, Description: "how often the delivery of a notification is retried"},
+	{Name: "BACK_END_IP_ADDRESS", Type: ParameterString, Backend: true, Description: "IP address for the back-end to listen on"},
+	{Name: "API_TOKEN", Type: ParameterString, Secret: true, Backend: true, Description: "token the REST API clients must send as Authorization: Bearer, empty for no authentication"},
+	{Name: "MIN_DISK_SIZE_GB", Type: ParameterInt, Backend: true, Description: "smallest disk accepted by the pre-flight check"},
+	{Name: "MIN_RAM_MB", Type: ParameterInt, Backend: true, Description: "least memory accepted by the pre-flight check"},
+	{Name: "INSTALLER_RUNNER", Type: ParameterString, Backend: true, Allowed: []string{RunnerScript, RunnerSimulated, RunnerRemote, RunnerNative},
+		Description: "how to run the installation"},
+	{Name: "INSTALLER_SCRIPT", Type: ParameterString, Backend: true, Description: "path to installer.sh for the script runner"},
+	{Name: "INSTALLER_REMOTE_URL", Type: ParameterString, Backend: true, Description: "base URL of the back-end the remote runner proxies to"},
+	{Name: "INSTALLER_REMOTE_TOKEN", Type: ParameterString, Secret: true, Backend: true, Description: "API_TOKEN of the back-end the remote runner proxies to"},
+}
+
+// the values of INSTALLER_RUNNER
+const (
+	RunnerScript    = "script"
+	RunnerSimulated = "simulated"
+	RunnerRemote    = "remote"
+	RunnerNative    = "native"
+)
+
+// the values of TARGET_TYPE
+const (
+	TargetDisk  = "disk"
+	TargetImage = "image"
+)
+
+// the values of IMAGE_FORMAT
+const (
+	ImageRaw   = "raw"
+	ImageQcow2 = "qcow2"
+)
+
+//go:embed timezones.txt
+var timezonesStr string
+
+// Timezones are the names accepted for TIMEZONE
+var Timezones = strings.Split(timezonesStr, "\n")
+
+var (
+	hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	usernameRegexp = regexp.MustCompile(`^[a-z][-a-z0-9_]{0,31}$`)
+)
+
+type ParameterIssue struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+func FindParameter(name string) (Parameter, bool) {
+	for _, p := range Definitions {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Parameter{}, false
+}
+
+func IsSecret(name string) bool {
+	p, found := FindParameter(name)
+	return found && p.Secret
+}
+
+// IsBackendSetting is true for the settings of the back-end itself, as opposed to the installation parameters
+func IsBackendSetting(name string) bool {
+	p, found := FindParameter(name)
+	return found && p.Backend
+}
+
+// Redact returns a copy of the parameters with the values of secrets replaced
+func Redact(parameters map[string]string) map[string]string {
+	redacted := maps.Clone(parameters)
+	for k, v := range redacted {
+		if IsSecret(k) && v != "" {
+			redacted[k] = "***"
+		}
+	}
+	return redacted
+}
+
+// CheckValue validates a single value against its definition. Unknown names are accepted,
+// the parameters also contain the whole environment of the back-end.
+func CheckValue(name string, value string) error {
+	p, found := FindParameter(name)
+	if !found || value == "" {
+		return nil
+	}
+	switch p.Type {
+	case ParameterBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("must be true or false, not %q", value)
+		}
+	case ParameterInt:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be a number, not %q", value)
+		}
+		if i < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+	}
+	if len(p.Allowed) > 0 && !slices.Contains(p.Allowed, value) {
+		return fmt.Errorf("must be one of %v, not %q", p.Allowed, value)
+	}
+	return nil
+}
+
+// Validate checks the parameters of an installation the same way installer.sh would, without running it
+func Validate(parameters map[string]string) []ParameterIssue {
+	var issues []ParameterIssue
+	add := func(key string, format string, args ...any) {
+		issues = append(issues, ParameterIssue{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, p := range Definitions {
+		if err := CheckValue(p.Name, parameters[p.Name]); err != nil {
+			add(p.Name, "%v", err)
+		}
+	}
+	if parameters["DISK"] == "" {
+		add("DISK", "no target disk selected")
+	}
+	if parameters["TARGET_TYPE"] == TargetImage {
+		if disk := parameters["DISK"]; disk != "" && (!strings.HasPrefix(disk, "/") || strings.HasPrefix(disk, "/dev/")) {
+			add("DISK", "the disk image must be an absolute path outside of /dev, not %q", disk)
+		}
+		if size, _ := strconv.Atoi(parameters["IMAGE_SIZE"]); size == 0 {
+			add("IMAGE_SIZE", "required when TARGET_TYPE is %s", TargetImage)
+		}
+		// both would be stored in the firmware of this machine, not in the image
+		if parameters["ENABLE_TPM"] == "true" && parameters["DISABLE_LUKS"] != "true" {
+			add("ENABLE_TPM", "the disk image would only unlock with the TPM of this machine")
+		}
+		if parameters["ENABLE_MOK_SIGNED_UKI"] == "true" {
+			add("ENABLE_MOK_SIGNED_UKI", "the machine owner key would be enrolled on this machine, not in the disk image")
+		}
+	}
+	if parameters["DISABLE_LUKS"] != "true" && parameters["LUKS_PASSWORD"] == "" {
+		add("LUKS_PASSWORD", "required unless DISABLE_LUKS is true")
+	}
+	if parameters["DISABLE_LUKS"] == "true" && parameters["ENABLE_TPM"] == "true" {
+		add("ENABLE_TPM", "unlocks the encrypted disk, but DISABLE_LUKS is true")
+	}
+	if parameters["ENABLE_MOK_SIGNED_UKI"] == "true" && parameters["MOK_ENROLL_PASSWORD"] == "" {
+		add("MOK_ENROLL_PASSWORD", "required when ENABLE_MOK_SIGNED_UKI is true")
+	}
+	if hostname := parameters["HOSTNAME"]; hostname != "" && !hostnameRegexp.MatchString(hostname) {
+		add("HOSTNAME", "%q is not a valid hostname", hostname)
+	}
+	if username := parameters["USERNAME"]; username != "" && !usernameRegexp.MatchString(username) {
+		add("USERNAME", "%q is not a valid user name", username)
+	}
+	if tz := parameters["TIMEZONE"]; tz != "" && !slices.Contains(Timezones, tz) {
+		add("TIMEZONE", "unknown time zone %q", tz)
+	}
+	return issues
+}
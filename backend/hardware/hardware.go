@@ -0,0 +1,483 @@
+// Package hardware probes the machine the installer runs on, or simulates it with recorded fixtures.
+package hardware
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Hardware probes the machine the installer runs on
+type Hardware interface {
+	Hostname() (string, error)
+	// BlockDevices returns the output of lsblk -OJ
+	BlockDevices() ([]byte, error)
+	HasEfi() (bool, error)
+	HasNvidia() bool
+	SecureBootState() (string, error)
+	HasTpm() bool
+	PowerSupply() (hasBattery bool, onAc bool)
+	MemTotal() (int64, error)
+	DiskSize(disk string) (int64, error)
+	// DiskMountpoints returns mountpoints and swaps backed by the disk, its partitions
+	// or device-mapper devices (e.g. luks) on top of them
+	DiskMountpoints(disk string) ([]string, error)
+	HasCommand(name string) bool
+	HasBootstrapImage() bool
+	// DirExists tells whether the directory exists, e.g. the one a disk image is to be written to
+	DirExists(path string) bool
+	// MediaLocations are the directories the users can save files to and load them from:
+	// the esp and the mounted usb sticks, the esp first
+	MediaLocations() []string
+	// ReadDeviceFiles reads the files that exist in the root of the file system on the device,
+	// mounting it read-only when it is not mounted yet
+	ReadDeviceFiles(device string, mountpoint string, names []string) (map[string][]byte, error)
+	// ResolveDevice follows links like /dev/disk/by-id/... to the device
+	ResolveDevice(path string) (string, error)
+	// InstalledSystem describes the installed system while it is still mounted on the target of the resources,
+	// their working directory is where the installer keeps its identifiers
+	InstalledSystem(resources installer.Resources) (InstalledSystem, error)
+	// WriteInstalledFile writes a file into the installed system mounted on the target of the resources
+	WriteInstalledFile(resources installer.Resources, name string, data []byte) error
+	// MountInstalledSystem opens luks and mounts the installed system read-only after the installer unmounted it
+	MountInstalledSystem(resources installer.Resources, parameters map[string]string) (root fs.FS, unmount func() error, err error)
+	// Diagnostics collects the mounts, kernel messages, back-end journal and efi boot entries for a bug report,
+	// by the file name in the bundle
+	Diagnostics() (files map[string][]byte, errs []error)
+}
+
+type InstalledSystem struct {
+	EfiPartUuid    string   `json:"efi_part_uuid"`
+	MainPartUuid   string   `json:"main_part_uuid"`
+	LuksUuid       string   `json:"luks_uuid,omitempty"`
+	BtrfsUuid      string   `json:"btrfs_uuid"`
+	KernelCmdline  string   `json:"kernel_cmdline"`
+	SwapfileOffset string   `json:"swapfile_offset,omitempty"`
+	MokKeysPending int      `json:"mok_keys_pending"`
+	Packages       []string `json:"packages"`
+}
+
+// Local probes the machine the back-end is running on
+type Local struct{}
+
+func (Local) Hostname() (string, error) {
+	return os.Hostname()
+}
+
+func (Local) BlockDevices() ([]byte, error) {
+	return runAndGiveStdout("lsblk", "-OJ")
+}
+
+func (Local) HasEfi() (bool, error) {
+	_, err := os.Stat("/sys/firmware/efi")
+	if err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (Local) HasNvidia() bool {
+	out, err := runAndGiveStdout("nvidia-detect")
+	if err != nil {
+		slog.Warn("failed to run nvidia-detect, assuming no nvidia", "error", err)
+		return false
+	}
+	outString := string(out)
+	if strings.Contains(outString, "No NVIDIA GPU detected") {
+		return false
+	}
+	if strings.Contains(outString, "nvidia-driver") {
+		return true
+	}
+	return false
+}
+
+func (Local) SecureBootState() (string, error) {
+	sbState, err := runAndGiveStdout("mokutil", "--sb-state")
+	if err != nil {
+		return "", err
+	}
+	return string(sbState), nil
+}
+
+func (Local) HasTpm() bool {
+	for _, path := range []string{"/dev/tpmrm0", "/sys/class/tpm/tpm0"} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (Local) PowerSupply() (hasBattery bool, onAc bool) {
+	supplies, err := filepath.Glob("/sys/class/power_supply/*")
+	if err != nil {
+		return false, false
+	}
+	for _, supply := range supplies {
+		supplyType, err := os.ReadFile(filepath.Join(supply, "type"))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(string(supplyType)) {
+		case "Battery":
+			hasBattery = true
+		case "Mains", "USB":
+			online, err := os.ReadFile(filepath.Join(supply, "online"))
+			if err == nil && strings.TrimSpace(string(online)) == "1" {
+				onAc = true
+			}
+		}
+	}
+	return hasBattery, onAc
+}
+
+func (Local) MemTotal() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parseMemTotal(f)
+}
+
+func (Local) DiskSize(disk string) (int64, error) {
+	name, err := blockDeviceName(disk)
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(filepath.Join("/sys/class/block", name, "size"))
+	if err != nil {
+		return 0, err
+	}
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	// the kernel always reports the size in 512-byte sectors
+	return sectors * 512, nil
+}
+
+func (Local) DiskMountpoints(disk string) ([]string, error) {
+	diskName, err := blockDeviceName(disk)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{diskName: true}
+	children, _ := filepath.Glob(filepath.Join("/sys/class/block", diskName, diskName+"*", "partition"))
+	for _, child := range children {
+		names[filepath.Base(filepath.Dir(child))] = true
+	}
+	for name := range maps.Clone(names) {
+		holders, _ := filepath.Glob(filepath.Join("/sys/class/block", name, "holders", "*"))
+		for _, holder := range holders {
+			names[filepath.Base(holder)] = true
+		}
+	}
+
+	var used []string
+	for _, table := range []string{"/proc/mounts", "/proc/swaps"} {
+		data, err := os.ReadFile(table)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || !strings.HasPrefix(fields[0], "/dev/") {
+				continue
+			}
+			name, err := blockDeviceName(fields[0])
+			if err != nil || !names[name] {
+				continue
+			}
+			if table == "/proc/swaps" {
+				used = append(used, "swap "+fields[0])
+			} else {
+				used = append(used, fields[1])
+			}
+		}
+	}
+	return used, nil
+}
+
+func (Local) HasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func (Local) HasBootstrapImage() bool {
+	_, err := os.Stat(installer.BootstrapImagePath)
+	return err == nil
+}
+
+func (Local) DirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func (l Local) MediaLocations() []string {
+	lsblk, err := l.BlockDevices()
+	if err != nil {
+		slog.Warn("failed to list the usb sticks", "error", err)
+		return []string{"/boot/efi"}
+	}
+	return parseMediaLocations(lsblk)
+}
+
+type mediaDevice struct {
+	Fstype      string        `json:"fstype"`
+	Removable   bool          `json:"rm"`
+	Hotplug     bool          `json:"hotplug"`
+	Mountpoints []*string     `json:"mountpoints"`
+	Children    []mediaDevice `json:"children"`
+}
+
+// parseMediaLocations finds the mounted file systems of the usb sticks in the output of lsblk -OJ, after the esp.
+// The target of an installation is left out even when it is removable, files saved there would be lost.
+func parseMediaLocations(lsblk []byte) []string {
+	locations := []string{"/boot/efi"}
+	var devices struct {
+		Blockdevices []mediaDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(lsblk, &devices); err != nil {
+		slog.Warn("failed to parse the block devices", "error", err)
+		return locations
+	}
+	for _, disk := range devices.Blockdevices {
+		if !disk.Removable && !disk.Hotplug {
+			continue
+		}
+		for _, d := range append([]mediaDevice{disk}, disk.Children...) {
+			if d.Fstype == "" || d.Fstype == "swap" || d.Fstype == "crypto_LUKS" {
+				continue
+			}
+			for _, m := range d.Mountpoints {
+				if m == nil || *m == "/" || slices.Contains(locations, *m) || installer.IsInstallerMount(*m) {
+					continue
+				}
+				locations = append(locations, *m)
+			}
+		}
+	}
+	return locations
+}
+
+func (Local) ReadDeviceFiles(device string, mountpoint string, names []string) (map[string][]byte, error) {
+	if mountpoint == "" {
+		var err error
+		mountpoint, err = os.MkdirTemp("", "odi-preset-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(mountpoint)
+		_, err = runAndGiveStdout("mount", "-o", "ro,nosuid,nodev,noexec", device, mountpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mount %s: %w", device, err)
+		}
+		defer func() {
+			if _, err := runAndGiveStdout("umount", mountpoint); err != nil {
+				slog.Warn("failed to unmount", "device", device, "error", err)
+			}
+		}()
+	}
+	return readFiles(os.DirFS(mountpoint), names), nil
+}
+
+func (Local) ResolveDevice(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+
+func (Local) InstalledSystem(resources installer.Resources) (InstalledSystem, error) {
+	workDir := resources.WorkDir
+	if _, err := os.Stat(filepath.Join(resources.Target, "etc", "debian_version")); err != nil {
+		return InstalledSystem{}, fmt.Errorf("no installed system on %s: %w", resources.Target, err)
+	}
+	s := InstalledSystem{
+		EfiPartUuid:    installer.ReadIdentifier(filepath.Join(workDir, "efi-part.uuid"), ""),
+		MainPartUuid:   installer.ReadIdentifier(filepath.Join(workDir, "main-part.uuid"), ""),
+		LuksUuid:       installer.ReadIdentifier(filepath.Join(workDir, "luks.uuid"), ""),
+		BtrfsUuid:      installer.ReadIdentifier(filepath.Join(workDir, "btrfs.uuid"), ""),
+		SwapfileOffset: installer.ReadIdentifier(filepath.Join(workDir, "swapfile.offset"), ""),
+		KernelCmdline:  installer.ReadIdentifier(filepath.Join(resources.Target, "etc", "kernel", "cmdline"), ""),
+	}
+	// mokutil lists the keys waiting for the enrollment on the next boot, it fails when there are none
+	if out, err := runAndGiveStdout("mokutil", "--list-new"); err == nil {
+		s.MokKeysPending = strings.Count(string(out), "[key ")
+	}
+	out, err := runAndGiveStdout("dpkg-query", "--admindir="+filepath.Join(resources.Target, "var", "lib", "dpkg"),
+		"-W", "-f", "${db:Status-Status} ${Package} ${Version}\n")
+	if err != nil {
+		return s, fmt.Errorf("failed to list the installed packages: %w", err)
+	}
+	s.Packages = parseInstalledPackages(string(out))
+	return s, nil
+}
+
+func (Local) WriteInstalledFile(resources installer.Resources, name string, data []byte) error {
+	path := filepath.Join(resources.Target, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (Local) MountInstalledSystem(resources installer.Resources, parameters map[string]string) (fs.FS, func() error, error) {
+	workDir := resources.WorkDir
+	mainPartition := "/dev/disk/by-partuuid/" + installer.ReadIdentifier(filepath.Join(workDir, "main-part.uuid"), "")
+	efiPartition := "/dev/disk/by-partuuid/" + installer.ReadIdentifier(filepath.Join(workDir, "efi-part.uuid"), "")
+	root := mainPartition
+	luks := parameters["DISABLE_LUKS"] != "true"
+	if luks {
+		root = "/dev/mapper/" + resources.LuksDevice
+		if _, err := os.Stat(root); err != nil {
+			cmd := exec.Command("cryptsetup", "open", "--key-file=-", mainPartition, resources.LuksDevice)
+			cmd.Stdin = strings.NewReader(parameters["LUKS_PASSWORD"])
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return nil, nil, fmt.Errorf("failed to open luks: %w: %s", err, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	unmount := func() error {
+		_, err := runAndGiveStdout("umount", "-R", resources.Target)
+		if luks {
+			if _, closeErr := runAndGiveStdout("cryptsetup", "close", resources.LuksDevice); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}
+	mounts := [][]string{
+		{"mount", "-o", "ro,subvol=@", root, resources.Target},
+		{"mount", "-o", "ro,subvol=@home", root, filepath.Join(resources.Target, "home")},
+		{"mount", "-o", "ro", efiPartition, filepath.Join(resources.Target, "boot", "efi")},
+	}
+	if err := os.MkdirAll(resources.Target, 0755); err != nil {
+		return nil, nil, err
+	}
+	for _, m := range mounts {
+		if _, err := runAndGiveStdout(m...); err != nil {
+			_ = unmount()
+			return nil, nil, fmt.Errorf("failed to mount %s on %s: %w", m[len(m)-2], m[len(m)-1], err)
+		}
+	}
+	return os.DirFS(resources.Target), unmount, nil
+}
+
+// backendUnit is the systemd unit the back-end runs as in the installer image
+const backendUnit = "installer_backend.service"
+
+func (Local) Diagnostics() (map[string][]byte, []error) {
+	files := make(map[string][]byte)
+	var errs []error
+	if mounts, err := os.ReadFile("/proc/mounts"); err != nil {
+		errs = append(errs, err)
+	} else {
+		files["mounts.txt"] = mounts
+	}
+	commands := map[string][]string{
+		"dmesg.txt":      {"dmesg"},
+		"journal.txt":    {"journalctl", "--no-pager", "-u", backendUnit, "-n", "2000"},
+		"efibootmgr.txt": {"efibootmgr", "-v"},
+	}
+	for name, command := range commands {
+		out, err := runAndGiveStdout(command...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", command[0], err))
+			continue
+		}
+		files[name] = out
+	}
+	return files, errs
+}
+
+// parseInstalledPackages keeps the "<package> <version>" of the installed packages from dpkg-query
+func parseInstalledPackages(out string) []string {
+	var packages []string
+	for _, line := range strings.Split(out, "\n") {
+		status, pkg, found := strings.Cut(line, " ")
+		if found && status == "installed" {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
+
+func readFiles(dir fs.FS, names []string) map[string][]byte {
+	files := make(map[string][]byte)
+	for _, name := range names {
+		data, err := fs.ReadFile(dir, name)
+		if err == nil {
+			files[name] = data
+		}
+	}
+	return files
+}
+
+func blockDeviceName(device string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(resolved), nil
+}
+
+func parseMemTotal(data io.Reader) (int64, error) {
+	scanner := bufio.NewScanner(data)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("MemTotal not found")
+}
+
+func runAndGiveStdout(command ...string) ([]byte, error) {
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command(path, command[1:]...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,54 @@
+package hardware
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseMediaLocations(t *testing.T) {
+	const LSBLK = `{"blockdevices": [
+		{"path": "/dev/sda", "rm": false, "hotplug": false, "mountpoints": [null], "children": [
+			{"path": "/dev/sda1", "fstype": "vfat", "mountpoints": ["/boot/efi"]}]},
+		{"path": "/dev/sdb", "rm": true, "hotplug": true, "mountpoints": [null], "children": [
+			{"path": "/dev/sdb1", "fstype": "vfat", "mountpoints": ["/media/usb stick"]},
+			{"path": "/dev/sdb2", "fstype": "swap", "mountpoints": ["[SWAP]"]}]},
+		{"path": "/dev/sdc", "rm": false, "hotplug": true, "mountpoints": [null], "children": [
+			{"path": "/dev/sdc1", "fstype": "vfat", "mountpoints": ["/target-1/boot/efi"]},
+			{"path": "/dev/sdc2", "fstype": "btrfs", "mountpoints": ["/mnt/top_level_mount-1", "/target-1"]}]},
+		{"path": "/dev/sdd", "rm": true, "hotplug": true, "fstype": "exfat", "mountpoints": ["/mnt/stick"]}
+	]}`
+	locations := parseMediaLocations([]byte(LSBLK))
+	if want := []string{"/boot/efi", "/media/usb stick", "/mnt/stick"}; !slices.Equal(locations, want) {
+		t.Errorf("Locations = %v; want %v, the esp and the usb sticks without the installation targets", locations, want)
+	}
+}
+
+func TestParseMemTotal(t *testing.T) {
+	const MEMINFO = "MemTotal:        2014256 kB\nMemFree:          150336 kB\n"
+	m, err := parseMemTotal(strings.NewReader(MEMINFO))
+	if err != nil {
+		t.Fatalf("Failed to parse meminfo: %v", err)
+	}
+	if 2014256*1024 != m {
+		t.Errorf("MemTotal = %d; want %d", m, 2014256*1024)
+	}
+}
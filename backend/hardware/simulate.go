@@ -0,0 +1,288 @@
+package hardware
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"testing/fstest"
+)
+
+//go:embed test_data/simulate
+var simulateFixtures embed.FS
+
+// Simulated serves recorded hardware fixtures, it does not need root nor any real disks
+type Simulated struct {
+	lsblk []byte
+	login struct {
+		Hostname  string `json:"hostname"`
+		HasEfi    bool   `json:"has_efi"`
+		HasNvidia bool   `json:"has_nvidia"`
+		SBState   string `json:"sb_state"`
+	}
+	devices  []simulatedDevice
+	fixtures fs.FS
+}
+
+type simulatedDevice struct {
+	Path        string            `json:"path"`
+	Serial      string            `json:"serial"`
+	Size        string            `json:"size"`
+	Mountpoints []*string         `json:"mountpoints"`
+	Children    []simulatedDevice `json:"children"`
+}
+
+func NewSimulated(fixtures string) (*Simulated, error) {
+	var fixturesFs fs.FS
+	if fixtures == "" {
+		var err error
+		fixturesFs, err = fs.Sub(simulateFixtures, "test_data/simulate")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		fixturesFs = os.DirFS(fixtures)
+	}
+	h := Simulated{fixtures: fixturesFs}
+	var err error
+	h.lsblk, err = fs.ReadFile(fixturesFs, "lsblk.json")
+	if err != nil {
+		return nil, err
+	}
+	var devices struct {
+		Blockdevices []simulatedDevice `json:"blockdevices"`
+	}
+	if err = json.Unmarshal(h.lsblk, &devices); err != nil {
+		return nil, fmt.Errorf("lsblk.json: %w", err)
+	}
+	h.devices = devices.Blockdevices
+	login, err := fs.ReadFile(fixturesFs, "login.json")
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(login, &h.login); err != nil {
+		return nil, fmt.Errorf("login.json: %w", err)
+	}
+	return &h, nil
+}
+
+func (h *Simulated) Hostname() (string, error) {
+	return h.login.Hostname, nil
+}
+
+func (h *Simulated) BlockDevices() ([]byte, error) {
+	return h.lsblk, nil
+}
+
+func (h *Simulated) HasEfi() (bool, error) {
+	return h.login.HasEfi, nil
+}
+
+func (h *Simulated) HasNvidia() bool {
+	return h.login.HasNvidia
+}
+
+func (h *Simulated) SecureBootState() (string, error) {
+	if h.login.SBState == "" {
+		return "SecureBoot disabled\n", nil
+	}
+	return h.login.SBState, nil
+}
+
+func (h *Simulated) HasTpm() bool {
+	return true
+}
+
+func (h *Simulated) PowerSupply() (bool, bool) {
+	return false, false
+}
+
+func (h *Simulated) MemTotal() (int64, error) {
+	return 8 << 30, nil
+}
+
+func (h *Simulated) DiskSize(disk string) (int64, error) {
+	device, err := h.findDevice(disk)
+	if err != nil {
+		return 0, err
+	}
+	return parseLsblkSize(device.Size)
+}
+
+func (h *Simulated) DiskMountpoints(disk string) ([]string, error) {
+	device, err := h.findDevice(disk)
+	if err != nil {
+		return nil, err
+	}
+	var used []string
+	for _, d := range append([]simulatedDevice{device}, device.Children...) {
+		for _, m := range d.Mountpoints {
+			if m != nil {
+				used = append(used, *m)
+			}
+		}
+	}
+	return used, nil
+}
+
+func (h *Simulated) HasCommand(_ string) bool {
+	return true
+}
+
+func (h *Simulated) HasBootstrapImage() bool {
+	return true
+}
+
+// DirExists looks at the real file system, a disk image is a file on this machine in the simulation too
+func (h *Simulated) DirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// MediaLocations is the temporary directory, the simulation has no esp or usb sticks
+func (h *Simulated) MediaLocations() []string {
+	return []string{os.TempDir()}
+}
+
+// ReadDeviceFiles serves the files from presets/<device name> in the fixtures
+func (h *Simulated) ReadDeviceFiles(device string, _ string, names []string) (map[string][]byte, error) {
+	dir, err := fs.Sub(h.fixtures, path.Join("presets", path.Base(device)))
+	if err != nil {
+		return nil, err
+	}
+	return readFiles(dir, names), nil
+}
+
+// ResolveDevice resolves /dev/disk/by-id links ending with the serial number of a disk
+func (h *Simulated) ResolveDevice(devicePath string) (string, error) {
+	if id, found := strings.CutPrefix(devicePath, "/dev/disk/by-id/"); found {
+		for _, d := range h.devices {
+			if d.Serial != "" && (strings.HasSuffix(id, "_"+d.Serial) || strings.HasSuffix(id, "-"+d.Serial)) {
+				return d.Path, nil
+			}
+		}
+		return "", fmt.Errorf("%s: no such device", devicePath)
+	}
+	// the installed system only exists in installed.json
+	if dir, id := path.Split(devicePath); dir == "/dev/disk/by-uuid/" || dir == "/dev/disk/by-partuuid/" {
+		s, err := h.InstalledSystem(installer.Resources{})
+		if err == nil && slices.Contains([]string{s.EfiPartUuid, s.MainPartUuid, s.LuksUuid, s.BtrfsUuid}, id) {
+			return devicePath, nil
+		}
+		return "", fmt.Errorf("%s: no such device", devicePath)
+	}
+	d, err := h.findDevice(devicePath)
+	return d.Path, err
+}
+
+// InstalledSystem serves installed.json from the fixtures
+func (h *Simulated) InstalledSystem(_ installer.Resources) (InstalledSystem, error) {
+	var s InstalledSystem
+	data, err := fs.ReadFile(h.fixtures, "installed.json")
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+func (h *Simulated) WriteInstalledFile(_ installer.Resources, name string, data []byte) error {
+	slog.Info("simulating writing into the installed system", "name", name, "size", len(data))
+	return nil
+}
+
+// MountInstalledSystem renders the files of a successful installation with the parameters
+func (h *Simulated) MountInstalledSystem(_ installer.Resources, parameters map[string]string) (fs.FS, func() error, error) {
+	s, err := h.InstalledSystem(installer.Resources{})
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := installer.Identifiers{EfiPartUuid: s.EfiPartUuid, MainPartUuid: s.MainPartUuid, BtrfsUuid: s.BtrfsUuid, SwapfileOffset: s.SwapfileOffset}
+	cmdline := installer.RenderKernelCommandLine(parameters, ids)
+	valueOr := func(name string, def string) string {
+		if parameters[name] != "" {
+			return parameters[name]
+		}
+		return def
+	}
+	passwd := "root:x:0:0:root:/root:/bin/bash\n"
+	if parameters["USERNAME"] != "" {
+		passwd += parameters["USERNAME"] + ":x:1000:1000::/home/" + parameters["USERNAME"] + ":/bin/bash\n"
+	}
+	root := fstest.MapFS{
+		"etc/fstab":          {Data: []byte(installer.RenderFstab(parameters, ids).Content)},
+		"etc/kernel/cmdline": {Data: []byte(cmdline + "\n")},
+		"etc/hostname":       {Data: []byte(valueOr("HOSTNAME", "debian") + "\n")},
+		"etc/timezone":       {Data: []byte(valueOr("TIMEZONE", "UTC") + "\n")},
+		"etc/passwd":         {Data: []byte(passwd)},
+	}
+	if parameters["ENABLE_MOK_SIGNED_UKI"] == "true" {
+		root["boot/efi/EFI/Linux/debian.efi"] = &fstest.MapFile{Data: installer.SimulatedUki(cmdline, true)}
+	} else {
+		root["boot/efi/loader/entries/debian.conf"] = &fstest.MapFile{Data: []byte("title Debian GNU/Linux\noptions " + cmdline + "\n")}
+	}
+	return root, func() error { return nil }, nil
+}
+
+func (h *Simulated) Diagnostics() (map[string][]byte, []error) {
+	return map[string][]byte{
+		"mounts.txt":     []byte("/dev/sda2 / ext4 rw,relatime 0 0\n/dev/sda1 /boot/efi vfat rw,relatime 0 0\n"),
+		"dmesg.txt":      []byte("[    0.000000] Linux version 6.12.38+deb13-amd64 (simulated)\n"),
+		"journal.txt":    []byte("simulated " + backendUnit + " journal\n"),
+		"efibootmgr.txt": []byte("BootCurrent: 0001\nBoot0001* Linux Boot Manager\tHD(1,GPT,6c5f2b1e-3a4d-4f7e-9b2a-1d8e0c7f5a31)/File(\\EFI\\systemd\\systemd-bootx64.efi)\n"),
+	}, nil
+}
+
+func (h *Simulated) findDevice(path string) (simulatedDevice, error) {
+	for _, d := range h.devices {
+		if d.Path == path {
+			return d, nil
+		}
+	}
+	return simulatedDevice{}, fmt.Errorf("%s: no such device", path)
+}
+
+// parseLsblkSize parses the human-readable sizes like 465.8G
+func parseLsblkSize(size string) (int64, error) {
+	units := "BKMGTP"
+	if size == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	exponent := strings.IndexByte(units, size[len(size)-1])
+	number := size
+	if exponent >= 0 {
+		number = size[:len(size)-1]
+	} else {
+		exponent = 0
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(f * float64(int64(1)<<(10*exponent))), nil
+}
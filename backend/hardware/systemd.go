@@ -0,0 +1,46 @@
+package hardware
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"net"
+	"os"
+)
+
+// SystemdNotifyReady tells systemd that the service started, when it runs as a Type=notify unit
+func SystemdNotifyReady() error {
+	socketName := os.Getenv("NOTIFY_SOCKET")
+	if socketName == "" {
+		return nil
+	}
+	systemdSocket := &net.UnixAddr{
+		Name: socketName,
+		Net:  "unixgram",
+	}
+	message := "READY=1"
+	conn, err := net.DialUnix(systemdSocket.Net, nil, systemdSocket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(message)); err != nil {
+		return err
+	}
+	return nil
+}
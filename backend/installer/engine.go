@@ -0,0 +1,339 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type StepStatus string
+
+const (
+	StepPending StepStatus = "PENDING"
+	StepRunning StepStatus = "RUNNING"
+	StepDone    StepStatus = "DONE"
+	StepSkipped StepStatus = "SKIPPED"
+	StepFailed  StepStatus = "FAILED"
+)
+
+type StepResult struct {
+	Name     string     `json:"name"`
+	Requires []string   `json:"requires"`
+	Status   StepStatus `json:"status"`
+	Attempts int        `json:"attempts"`
+	Started  time.Time  `json:"started"`
+	Finished time.Time  `json:"finished"`
+	Error    string     `json:"error,omitempty"`
+	Output   string     `json:"output"`
+}
+
+// StepController is implemented by installers that allow controlling the individual steps
+type StepController interface {
+	Steps() []StepResult
+	Retry(name string) error
+	Skip(name string) error
+}
+
+type engineStep struct {
+	name     string
+	requires []string
+	// done is the idempotency check, it is true when the step does not need to run (again)
+	done func(e *stepEnv) bool
+	run  func(e *stepEnv) error
+}
+
+type stepDecision struct {
+	name string
+	skip bool
+}
+
+// StepEngine installs the system step by step, without installer.sh.
+// When a step fails, the engine waits for the decision to retry or skip it,
+// unless the installation is unattended.
+type StepEngine struct {
+	Resources Resources
+	steps     []engineStep
+	order     []int
+	lock      sync.Mutex
+	results   []StepResult
+	waiting   string
+	decisions chan stepDecision
+	current   int
+	exitCode  int
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+var ErrStepNotFound = errors.New("no such step")
+
+func NewStepEngine(resources Resources) (*StepEngine, error) {
+	e := StepEngine{
+		Resources: resources,
+		steps:     installerEngineSteps(),
+		decisions: make(chan stepDecision, 1),
+		current:   -1,
+		exitCode:  -1,
+	}
+	var err error
+	e.order, err = sortSteps(e.steps)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range e.steps {
+		e.results = append(e.results, StepResult{Name: step.name, Requires: step.requires, Status: StepPending})
+	}
+	return &e, nil
+}
+
+// sortSteps orders the steps so that every step runs after the steps it requires,
+// keeping the declared order where the dependencies allow it
+func sortSteps(steps []engineStep) ([]int, error) {
+	var order []int
+	placed := make(map[string]bool)
+	for len(order) < len(steps) {
+		progress := false
+		for i, step := range steps {
+			if placed[step.name] {
+				continue
+			}
+			ready := true
+			for _, r := range step.requires {
+				if !placed[r] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				order = append(order, i)
+				placed[step.name] = true
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("steps have unknown or circular dependencies")
+		}
+	}
+	return order, nil
+}
+
+func (e *StepEngine) Start(ctx context.Context, parameters map[string]string, output Output) error {
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+	env := &stepEnv{
+		ctx:        ctx,
+		parameters: parameters,
+		resources:  e.Resources,
+	}
+	go func() {
+		code := e.run(ctx, env, output, parameters["AUTO_INSTALL"] == "true")
+		e.lock.Lock()
+		e.exitCode = code
+		e.lock.Unlock()
+		close(e.done)
+	}()
+	return nil
+}
+
+func (e *StepEngine) run(ctx context.Context, env *stepEnv, output Output, unattended bool) int {
+	for _, i := range e.order {
+		step := e.steps[i]
+		e.lock.Lock()
+		e.current = slices.IndexFunc(installSteps, func(s InstallStep) bool { return s.Name == step.name })
+		skipped := e.results[i].Status == StepSkipped
+		e.lock.Unlock()
+		if skipped {
+			_, _ = fmt.Fprintf(output, "skipping %s\n", step.name)
+			continue
+		}
+		output.StepStarted(step.name)
+		for {
+			stepOutput := &bytes.Buffer{}
+			env.output = io.MultiWriter(output, stepOutput)
+			e.update(i, func(r *StepResult) {
+				r.Status = StepRunning
+				r.Attempts++
+				r.Started = time.Now()
+				r.Error = ""
+			})
+			var err error
+			if step.done != nil && step.done(env) {
+				env.notify("%s already done", step.name)
+			} else {
+				err = step.run(env)
+			}
+			e.update(i, func(r *StepResult) {
+				r.Finished = time.Now()
+				r.Output = stepOutput.String()
+				if err != nil {
+					r.Status = StepFailed
+					r.Error = err.Error()
+				} else {
+					r.Status = StepDone
+				}
+			})
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return -1
+			}
+			_, _ = fmt.Fprintf(output, "step %s failed: %v\n", step.name, err)
+			if unattended {
+				return 1
+			}
+			_, _ = fmt.Fprintf(output, "waiting for the step %s to be retried or skipped\n", step.name)
+			decision, ok := e.waitForDecision(ctx, step.name)
+			if !ok {
+				return 1
+			}
+			if decision.skip {
+				e.update(i, func(r *StepResult) { r.Status = StepSkipped })
+				_, _ = fmt.Fprintf(output, "skipping %s\n", step.name)
+				break
+			}
+			_, _ = fmt.Fprintf(output, "retrying %s\n", step.name)
+		}
+	}
+	return 0
+}
+
+func (e *StepEngine) update(i int, f func(r *StepResult)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	f(&e.results[i])
+}
+
+func (e *StepEngine) waitForDecision(ctx context.Context, name string) (stepDecision, bool) {
+	e.lock.Lock()
+	e.waiting = name
+	e.lock.Unlock()
+	defer func() {
+		e.lock.Lock()
+		e.waiting = ""
+		e.lock.Unlock()
+	}()
+	select {
+	case d := <-e.decisions:
+		return d, true
+	case <-ctx.Done():
+		return stepDecision{}, false
+	}
+}
+
+func (e *StepEngine) Steps() []StepResult {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	var results []StepResult
+	for _, i := range e.order {
+		results = append(results, e.results[i])
+	}
+	return results
+}
+
+func (e *StepEngine) findStep(name string) int {
+	return slices.IndexFunc(e.steps, func(s engineStep) bool { return s.name == name })
+}
+
+// Retry runs the failed step again
+func (e *StepEngine) Retry(name string) error {
+	if e.findStep(name) < 0 {
+		return ErrStepNotFound
+	}
+	e.lock.Lock()
+	waiting := e.waiting
+	e.lock.Unlock()
+	if waiting != name {
+		return fmt.Errorf("step %s has not failed", name)
+	}
+	return e.decide(stepDecision{name: name})
+}
+
+// Skip continues after the failed step, or marks a step that did not run yet to be skipped
+func (e *StepEngine) Skip(name string) error {
+	i := e.findStep(name)
+	if i < 0 {
+		return ErrStepNotFound
+	}
+	e.lock.Lock()
+	waiting := e.waiting
+	if waiting != name {
+		defer e.lock.Unlock()
+		if e.results[i].Status != StepPending {
+			return fmt.Errorf("step %s is %s", name, e.results[i].Status)
+		}
+		e.results[i].Status = StepSkipped
+		return nil
+	}
+	e.lock.Unlock()
+	return e.decide(stepDecision{name: name, skip: true})
+}
+
+func (e *StepEngine) decide(d stepDecision) error {
+	select {
+	case e.decisions <- d:
+		return nil
+	default:
+		return fmt.Errorf("step %s already has a decision pending", d.name)
+	}
+}
+
+func (e *StepEngine) Cancel() error {
+	e.cancel()
+	return nil
+}
+
+func (e *StepEngine) Wait() error {
+	<-e.done
+	if e.exitCode != 0 {
+		return fmt.Errorf("exit status %d", e.exitCode)
+	}
+	return nil
+}
+
+func (e *StepEngine) Progress() Progress {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return stepProgress(e.current)
+}
+
+func (e *StepEngine) Result() (bool, int) {
+	select {
+	case <-e.done:
+		return true, e.exitCode
+	default:
+		return false, -1
+	}
+}
+
+func (e *StepEngine) Command() string {
+	var names []string
+	for _, i := range e.order {
+		names = append(names, e.steps[i].name)
+	}
+	return "native installer: " + strings.Join(names, ", ")
+}
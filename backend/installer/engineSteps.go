@@ -0,0 +1,640 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/r0b0/debian-installer/backend/config"
+)
+
+// the steps follow installer.sh, when changing installer.sh, you need to change them too
+
+const (
+	TargetMount   = "/target"
+	topLevelMount = "/mnt/top_level_mount"
+	keyFile       = "luks.key"
+)
+
+type stepEnv struct {
+	ctx        context.Context
+	parameters map[string]string
+	resources  Resources
+	output     io.Writer
+}
+
+func (e *stepEnv) notify(format string, args ...any) {
+	_, _ = fmt.Fprintf(e.output, format+"\n", args...)
+}
+
+func (e *stepEnv) path(name string) string {
+	return filepath.Join(e.resources.WorkDir, name)
+}
+
+func (e *stepEnv) exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (e *stepEnv) touch(name string) error {
+	return os.WriteFile(e.path(name), nil, 0600)
+}
+
+func (e *stepEnv) command(stdin string, command ...string) *exec.Cmd {
+	cmd := exec.CommandContext(e.ctx, command[0], command[1:]...)
+	cmd.Dir = e.resources.WorkDir
+	cmd.Stdout = e.output
+	cmd.Stderr = e.output
+	cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	return cmd
+}
+
+func (e *stepEnv) run(command ...string) error {
+	err := e.command("", command...).Run()
+	if err != nil {
+		return fmt.Errorf("%s: %w", command[0], err)
+	}
+	return nil
+}
+
+func (e *stepEnv) runWithInput(stdin string, command ...string) error {
+	err := e.command(stdin, command...).Run()
+	if err != nil {
+		return fmt.Errorf("%s: %w", command[0], err)
+	}
+	return nil
+}
+
+func (e *stepEnv) runOutput(command ...string) (string, error) {
+	cmd := e.command("", command...)
+	cmd.Stdout = nil
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", command[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// chroot runs a shell script in the target system
+func (e *stepEnv) chroot(script string) error {
+	scriptPath := filepath.Join(e.resources.Target, "tmp", "odi-step.sh")
+	err := os.WriteFile(scriptPath, []byte("#!/bin/bash\nset -euo pipefail\nexport DEBIAN_FRONTEND=noninteractive\n"+script), 0700)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(scriptPath)
+	return e.run("chroot", e.resources.Target, "bash", "/tmp/odi-step.sh")
+}
+
+func (e *stepEnv) writeFile(path string, content string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+func (e *stepEnv) isMounted(path string) bool {
+	return exec.CommandContext(e.ctx, "mountpoint", "-q", path).Run() == nil
+}
+
+func (e *stepEnv) luks() bool {
+	return e.parameters["DISABLE_LUKS"] != "true"
+}
+
+// ids reads the identifiers generated by the previous steps, so that every step can be retried on its own
+func (e *stepEnv) ids() Identifiers {
+	return Identifiers{
+		EfiPartUuid:    ReadIdentifier(e.path("efi-part.uuid"), ""),
+		MainPartUuid:   ReadIdentifier(e.path("main-part.uuid"), ""),
+		BtrfsUuid:      ReadIdentifier(e.path("btrfs.uuid"), ""),
+		SwapfileOffset: ReadIdentifier(e.path("swapfile.offset"), ""),
+	}
+}
+
+// rootDevice is the root partition while it is being installed, opened under the device mapper name of this installation
+func (e *stepEnv) rootDevice() string {
+	if e.luks() {
+		return "/dev/mapper/" + e.resources.LuksDevice
+	}
+	return rootDevice(e.parameters, e.ids())
+}
+
+func (e *stepEnv) mainPartition() string {
+	return "/dev/disk/by-partuuid/" + e.ids().MainPartUuid
+}
+
+func (e *stepEnv) efiPartition() string {
+	return "/dev/disk/by-partuuid/" + e.ids().EfiPartUuid
+}
+
+func installerEngineSteps() []engineStep {
+	return []engineStep{
+		{name: "partition", run: stepPartition},
+		{name: "luks", requires: []string{"partition"}, done: luksDone, run: stepLuks},
+		{name: "mount", requires: []string{"luks"}, run: stepMount},
+		{name: "bootstrap", requires: []string{"mount"}, run: stepBootstrap},
+		{name: "fstab", requires: []string{"bootstrap"}, run: stepFstab},
+		{name: "sources", requires: []string{"bootstrap"}, run: stepSources},
+		{name: "users", requires: []string{"bootstrap"}, done: usersDone, run: stepUsers},
+		{name: "bootloader", requires: []string{"fstab", "sources"}, done: markerDone("bootloader_installed.txt"), run: stepBootloader},
+		{name: "packages", requires: []string{"bootloader", "users"}, done: markerDone("packages_installed.txt"), run: stepPackages},
+		{name: "cleanup", requires: []string{"packages"}, run: stepCleanup},
+	}
+}
+
+func markerDone(marker string) func(e *stepEnv) bool {
+	return func(e *stepEnv) bool {
+		return e.exists(e.path(marker))
+	}
+}
+
+func stepPartition(e *stepEnv) error {
+	disk := e.parameters["DISK"]
+	if disk == "" {
+		return fmt.Errorf("DISK variable is missing")
+	}
+	if !e.exists(e.path(keyFile)) {
+		key := make([]byte, 512)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		if err := os.WriteFile(e.path(keyFile), key, 0600); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{"efi-part.uuid", "main-part.uuid"} {
+		if !e.exists(e.path(name)) {
+			if err := os.WriteFile(e.path(name), []byte(uuid.New().String()+"\n"), 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	e.notify("setting up partitions on %s", disk)
+	_ = os.RemoveAll(e.path("repart.d"))
+	for _, f := range renderRepartDefinitions(e.parameters, e.ids()) {
+		if err := e.writeFile(e.path(f.Path), f.Content); err != nil {
+			return err
+		}
+	}
+	if !e.exists(e.path("disk_wiped.txt")) {
+		if err := e.run("wipefs", "--all", disk); err != nil {
+			return err
+		}
+		if err := e.touch("disk_wiped.txt"); err != nil {
+			return err
+		}
+	}
+	// sector-size: see https://github.com/systemd/systemd/issues/37801
+	err := e.run("systemd-repart", "--sector-size=512", "--empty=allow", "--no-pager", "--definitions=repart.d",
+		"--dry-run=no", disk, "--key-file="+keyFile, "--tpm2-device=auto", "--tpm2-pcrs=", "--tpm2-pcrlock=")
+	if err != nil {
+		return err
+	}
+	for !e.exists(e.mainPartition()) {
+		e.notify("waiting for %s to be created", e.mainPartition())
+		select {
+		case <-e.ctx.Done():
+			return e.ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
+	}
+	return nil
+}
+
+func luksDone(e *stepEnv) bool {
+	return !e.luks() || (e.exists(e.path("luks.uuid")) && e.exists(e.rootDevice()))
+}
+
+func stepLuks(e *stepEnv) error {
+	if e.parameters["LUKS_PASSWORD"] == "" {
+		return fmt.Errorf("LUKS_PASSWORD variable is missing")
+	}
+	e.notify("setup luks password on %s", e.mainPartition())
+	err := e.runWithInput(e.parameters["LUKS_PASSWORD"],
+		"cryptsetup", "--key-file="+keyFile, "luksAddKey", e.mainPartition(), "-")
+	if err != nil {
+		return err
+	}
+	luksUuid, err := e.runOutput("cryptsetup", "luksUUID", e.mainPartition())
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(e.path("luks.uuid"), []byte(luksUuid+"\n"), 0644); err != nil {
+		return err
+	}
+	if !e.exists(e.rootDevice()) {
+		e.notify("open luks on root")
+		return e.run("cryptsetup", "luksOpen", e.mainPartition(), e.resources.LuksDevice, "--key-file", keyFile)
+	}
+	return nil
+}
+
+func stepMount(e *stepEnv) error {
+	root := e.rootDevice()
+	btrfsUuid, err := e.runOutput("lsblk", "-no", "UUID", root)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(e.path("btrfs.uuid"), []byte(btrfsUuid+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if !e.isMounted(e.resources.TopLevelMount) {
+		e.notify("mount top-level subvolume on %s", e.resources.TopLevelMount)
+		_ = os.MkdirAll(e.resources.TopLevelMount, 0755)
+		if err = e.run("mount", root, e.resources.TopLevelMount, "-o", "rw,"+fsFlags+",subvolid=5,skip_balance"); err != nil {
+			return err
+		}
+	}
+
+	if e.exists(BootstrapImagePath) {
+		if !e.exists(e.path("base_image_copied.txt")) {
+			e.notify("send installer bootrstrap data - see nr of bytes transferred")
+			err = e.run("sh", "-c", fmt.Sprintf(
+				"btrfs send --compressed-data %s | pv -nb | btrfs receive %s", BootstrapImagePath, e.resources.TopLevelMount))
+			if err != nil {
+				return err
+			}
+			err = e.run("sh", "-c", fmt.Sprintf(
+				"cd %s && btrfs subvolume snapshot opinionated_installer_bootstrap @ && btrfs subvolume delete opinionated_installer_bootstrap",
+				e.resources.TopLevelMount))
+			if err != nil {
+				return err
+			}
+			if err = e.touch("base_image_copied.txt"); err != nil {
+				return err
+			}
+		}
+	} else if !e.exists(filepath.Join(e.resources.TopLevelMount, "@")) {
+		e.notify("create @ subvolume on %s", e.resources.TopLevelMount)
+		if err = e.run("btrfs", "subvolume", "create", filepath.Join(e.resources.TopLevelMount, "@")); err != nil {
+			return err
+		}
+	}
+
+	swap := swapSize(e.parameters)
+	if swap > 0 && !e.exists(filepath.Join(e.resources.TopLevelMount, "@swap")) {
+		e.notify("create @swap subvolume for swap file on %s", e.resources.TopLevelMount)
+		if err = e.run("btrfs", "subvolume", "create", filepath.Join(e.resources.TopLevelMount, "@swap")); err != nil {
+			return err
+		}
+		_ = os.Chmod(filepath.Join(e.resources.TopLevelMount, "@swap"), 0700)
+	}
+
+	if !e.isMounted(e.resources.Target) {
+		e.notify("mount root and home subvolume on %s", e.resources.Target)
+		_ = os.MkdirAll(e.resources.Target, 0755)
+		if err = e.run("mount", root, e.resources.Target, "-o", fsFlags+",subvol=@"); err != nil {
+			return err
+		}
+		_ = os.MkdirAll(filepath.Join(e.resources.Target, "home"), 0755)
+		if err = e.run("mount", root, filepath.Join(e.resources.Target, "home"), "-o", fsFlags+",subvol=@home"); err != nil {
+			return err
+		}
+		if swap > 0 {
+			e.notify("mount swap subvolume on %s", e.resources.Target)
+			_ = os.MkdirAll(filepath.Join(e.resources.Target, "swap"), 0755)
+			if err = e.run("mount", root, filepath.Join(e.resources.Target, "swap"), "-o", "noatime,subvol=@swap"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if swap > 0 {
+		swapfile := filepath.Join(e.resources.Target, "swap", "swapfile")
+		if !e.exists(swapfile) {
+			e.notify("make swap file at %s", swapfile)
+			if err = e.run("btrfs", "filesystem", "mkswapfile", "--size", fmt.Sprintf("%dG", swap), swapfile); err != nil {
+				return err
+			}
+		}
+		swaps, _ := os.ReadFile("/proc/swaps")
+		if !strings.Contains(string(swaps), swapfile) {
+			e.notify("enable swap file %s", swapfile)
+			if err = e.run("swapon", swapfile); err != nil {
+				return err
+			}
+		}
+		offset, err := e.runOutput("btrfs", "inspect-internal", "map-swapfile", "-r", swapfile)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(e.path("swapfile.offset"), []byte(offset+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stepBootstrap(e *stepEnv) error {
+	var err error
+	if !e.exists(filepath.Join(e.resources.Target, "etc", "debian_version")) {
+		e.notify("install debian on %s", e.resources.Target)
+		err = e.run("debootstrap", DebianVersion(e.parameters), e.resources.Target, DebianMirror)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !e.isMounted(filepath.Join(e.resources.Target, "proc")) {
+		e.notify("bind mount dev, proc, sys, run on %s", e.resources.Target)
+		mounts := [][]string{
+			{"mount", "-t", "proc", "none", filepath.Join(e.resources.Target, "proc")},
+			{"mount", "--make-rslave", "--rbind", "/sys", filepath.Join(e.resources.Target, "sys")},
+			{"mount", "--make-rslave", "--rbind", "/dev", filepath.Join(e.resources.Target, "dev")},
+			{"mount", "--make-rslave", "--rbind", "/run", filepath.Join(e.resources.Target, "run")},
+			{"mount", "--bind", "/etc/resolv.conf", filepath.Join(e.resources.Target, "etc", "resolv.conf")},
+		}
+		for _, m := range mounts {
+			if err = e.run(m...); err != nil {
+				return err
+			}
+		}
+	}
+	efivars := filepath.Join(e.resources.Target, "sys", "firmware", "efi", "efivars")
+	if e.parameters["TARGET_TYPE"] == config.TargetImage && e.isMounted(efivars) {
+		// the boot entries of a disk image belong to the machine it boots on, not to the firmware of this one
+		if err = e.run("umount", efivars); err != nil {
+			return err
+		}
+	}
+
+	esp := filepath.Join(e.resources.Target, "boot", "efi")
+	if !e.isMounted(esp) {
+		e.notify("mount esp partition %s on %s", e.efiPartition(), esp)
+		_ = os.MkdirAll(esp, 0755)
+		if err = e.run("mount", e.efiPartition(), esp, "-o", "umask=077"); err != nil {
+			return err
+		}
+	}
+
+	e.notify("setup locale, timezone, hostname, root password, kernel command line")
+	locale := e.parameters["LOCALE"]
+	if locale == "" {
+		locale = "C.UTF-8"
+	}
+	err = e.run("systemd-firstboot", "--root="+e.resources.Target, "--locale="+locale, "--keymap=us",
+		"--timezone="+e.parameters["TIMEZONE"], "--hostname="+e.parameters["HOSTNAME"],
+		"--root-password="+e.parameters["ROOT_PASSWORD"],
+		"--kernel-command-line="+RenderKernelCommandLine(e.parameters, e.ids()), "--force")
+	if err != nil {
+		return err
+	}
+	hosts, err := os.OpenFile(filepath.Join(e.resources.Target, "etc", "hosts"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(hosts, "127.0.1.1 %s\n", e.parameters["HOSTNAME"])
+	hosts.Close()
+	if err != nil {
+		return err
+	}
+	return e.runWithInput("locales locales/locales_to_be_generated multiselect     en_US.UTF-8 UTF-8\n",
+		"chroot", e.resources.Target, "debconf-set-selections")
+}
+
+func stepFstab(e *stepEnv) error {
+	e.notify("setup fstab")
+	_ = os.MkdirAll(filepath.Join(e.resources.Target, "root", "btrfs1"), 0700)
+	fstab := RenderFstab(e.parameters, e.ids())
+	return e.writeFile(filepath.Join(e.resources.Target, fstab.Path), fstab.Content)
+}
+
+func stepSources(e *stepEnv) error {
+	e.notify("setup sources list")
+	_ = os.Remove(filepath.Join(e.resources.Target, "etc", "apt", "sources.list"))
+	for _, f := range renderSources(e.parameters) {
+		if err := e.writeFile(filepath.Join(e.resources.Target, f.Path), f.Content); err != nil {
+			return err
+		}
+	}
+	e.notify("enable 32bit")
+	return e.run("chroot", e.resources.Target, "dpkg", "--add-architecture", "i386")
+}
+
+func usersDone(e *stepEnv) bool {
+	username := e.parameters["USERNAME"]
+	if username == "" {
+		return true
+	}
+	shadow, err := os.ReadFile(filepath.Join(e.resources.Target, "etc", "shadow"))
+	return err == nil && strings.Contains("\n"+string(shadow), "\n"+username+":")
+}
+
+func stepUsers(e *stepEnv) error {
+	username := e.parameters["USERNAME"]
+	e.notify("set up %s user", username)
+	err := e.run("chroot", e.resources.Target, "adduser", username, "--disabled-password", "--gecos", e.parameters["USER_FULL_NAME"])
+	if err != nil {
+		return err
+	}
+	if err = e.run("chroot", e.resources.Target, "adduser", username, "sudo"); err != nil {
+		return err
+	}
+	if password := e.parameters["USER_PASSWORD"]; password != "" {
+		return e.runWithInput(fmt.Sprintf("%s:%s\n", username, password), "chroot", e.resources.Target, "chpasswd")
+	}
+	return nil
+}
+
+func stepBootloader(e *stepEnv) error {
+	backports := DebianVersion(e.parameters) + "-backports"
+	e.notify("configuring dracut and kernel command line")
+	files := []PlanFile{
+		{Path: "/etc/dracut.conf.d/89-btrfs.conf", Content: "add_dracutmodules+=\" systemd btrfs \"\n"},
+	}
+	if e.luks() {
+		files = append(files, PlanFile{Path: "/etc/dracut.conf.d/90-luks.conf", Content: "add_dracutmodules+=\" crypt tpm2-tss \"\n"})
+	}
+	mok := e.parameters["ENABLE_MOK_SIGNED_UKI"] == "true"
+	if mok {
+		files = append(files,
+			PlanFile{Path: "/etc/kernel/install.conf", Content: "layout=uki\nuki_generator=ukify\ninitrd_generator=dracut\n"},
+			PlanFile{Path: "/etc/kernel/uki.conf", Content: "[UKI]\nCmdline=@/etc/kernel/cmdline\n" +
+				"SecureBootCertificate=/etc/kernel/mok.cert.pem\nSecureBootPrivateKey=/etc/kernel/mok.priv.pem\n"})
+	}
+	for _, f := range files {
+		if err := e.writeFile(filepath.Join(e.resources.Target, f.Path), f.Content); err != nil {
+			return err
+		}
+	}
+
+	e.notify("install required packages on %s", e.resources.Target)
+	err := e.chroot(fmt.Sprintf(`apt install -y locales tasksel network-manager sudo
+apt install -y -t %s systemd shim-signed systemd-boot systemd-boot-efi-amd64-signed systemd-ukify sbsigntool dracut btrfs-progs cryptsetup tpm2-tools tpm-udev
+# see https://bugs.debian.org/cgi-bin/bugreport.cgi?bug=1095646
+ln -sf /dev/null /etc/kernel/install.d/50-dracut.install
+`, backports))
+	if err != nil {
+		return err
+	}
+
+	if mok {
+		hash, err := e.runOutput("mokutil", "--generate-hash="+e.parameters["MOK_ENROLL_PASSWORD"])
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(e.resources.Target, "tmp", "mok.key"), []byte(hash+"\n"), 0600); err != nil {
+			return err
+		}
+		defer os.Remove(filepath.Join(e.resources.Target, "tmp", "mok.key"))
+		err = e.chroot(`# generate cert and key in pem format in /etc/kernel/mok.*.pem
+ukify genkey --config /etc/kernel/uki.conf
+openssl x509 -in /etc/kernel/mok.cert.pem -out /etc/kernel/mok.cert.der -outform der
+openssl rsa -in /etc/kernel/mok.priv.pem -out /etc/kernel/mok.priv.der -outform der
+mkdir -p /var/lib/dkms
+ln -sf /etc/kernel/mok.priv.pem /var/lib/dkms/mok.key
+ln -sf /etc/kernel/mok.cert.der /var/lib/dkms/mok.pub
+mkdir -p /var/lib/shim-signed/mok
+ln -sf /etc/kernel/mok.cert.der /var/lib/shim-signed/mok/MOK-Kernel.der
+ln -sf /etc/kernel/mok.cert.pem /var/lib/shim-signed/mok/MOK-Kernel.pem
+ln -sf /etc/kernel/mok.priv.der /var/lib/shim-signed/mok/MOK-Kernel.priv
+mokutil --import /etc/kernel/mok.cert.der --hash-file /tmp/mok.key
+`)
+		if err != nil {
+			return err
+		}
+	}
+	return e.touch("bootloader_installed.txt")
+}
+
+func stepPackages(e *stepEnv) error {
+	backports := DebianVersion(e.parameters) + "-backports"
+	lists := renderPackageLists(e.parameters)
+	e.notify("install kernel and firmware on %s", e.resources.Target)
+	err := e.chroot(fmt.Sprintf(`apt install -y %s
+apt install -t %[2]s -y dracut initramfs-tools- initramfs-tools-core- initramfs-tools-bin- busybox- klibc-utils- libklibc-
+apt install -t %[2]s -y %[3]s
+systemctl disable systemd-networkd.service  # seems to fight with NetworkManager
+systemctl disable systemd-networkd.socket
+systemctl disable systemd-networkd-wait-online.service
+`, strings.Join(lists.Base, " "), backports, strings.Join(lists.Backports, " ")))
+	if err != nil {
+		return err
+	}
+
+	if e.parameters["ENABLE_POPCON"] == "true" {
+		e.notify("enabling popularity-contest")
+		err = e.chroot(`echo "popularity-contest      popularity-contest/participate  boolean true" | debconf-set-selections
+apt install -y popularity-contest
+`)
+		if err != nil {
+			return err
+		}
+	}
+
+	if key := e.parameters["SSH_PUBLIC_KEY"]; key != "" {
+		e.notify("adding ssh public key to user and root authorized_keys file")
+		homes := []string{"/root"}
+		if username := e.parameters["USERNAME"]; username != "" {
+			homes = append(homes, "/home/"+username)
+		}
+		for _, home := range homes {
+			sshDir := filepath.Join(e.resources.Target, home, ".ssh")
+			if err = os.MkdirAll(sshDir, 0700); err != nil {
+				return err
+			}
+			if err = os.WriteFile(filepath.Join(sshDir, "authorized_keys"), []byte(key+"\n"), 0600); err != nil {
+				return err
+			}
+		}
+		if username := e.parameters["USERNAME"]; username != "" {
+			if err = e.run("chroot", e.resources.Target, "chown", "-R", username, "/home/"+username+"/.ssh"); err != nil {
+				return err
+			}
+		}
+		e.notify("installing openssh-server")
+		if err = e.run("chroot", e.resources.Target, "apt", "install", "-y", "openssh-server"); err != nil {
+			return err
+		}
+	}
+
+	if e.parameters["ENABLE_FLATHUB"] == "true" {
+		e.notify("enabling flatpak and flathub")
+		err = e.chroot(`apt install -y flatpak
+flatpak remote-add --if-not-exists flathub https://flathub.org/repo/flathub.flatpakrepo
+if (dpkg --get-selections | grep -w install |grep -qs "task-kde-desktop"); then
+  apt install -y plasma-discover-backend-flatpak
+fi
+if (dpkg --get-selections | grep -w install |grep -qs "task-gnome-desktop"); then
+  apt install -y gnome-software-plugin-flatpak
+fi
+`)
+		if err != nil {
+			return err
+		}
+	}
+
+	if nvidia := e.parameters["NVIDIA_PACKAGE"]; nvidia != "" {
+		e.notify("installing %s", nvidia)
+		err = e.writeFile(filepath.Join(e.resources.Target, "etc", "dracut.conf.d", "10-nvidia.conf"),
+			"install_items+=\" /etc/modprobe.d/nvidia-blacklists-nouveau.conf /etc/modprobe.d/nvidia.conf /etc/modprobe.d/nvidia-options.conf \"\n")
+		if err != nil {
+			return err
+		}
+		err = e.run("chroot", e.resources.Target, "apt", "install", "-t", backports, "-y", nvidia, "nvidia-driver-libs:i386", "linux-headers-amd64")
+		if err != nil {
+			return err
+		}
+	}
+	return e.touch("packages_installed.txt")
+}
+
+func stepCleanup(e *stepEnv) error {
+	e.notify("cleaning up")
+	if err := e.run("chroot", e.resources.Target, "apt", "autoremove", "-y"); err != nil {
+		return err
+	}
+
+	e.notify("umounting all filesystems")
+	if swapSize(e.parameters) > 0 {
+		_ = e.run("swapoff", filepath.Join(e.resources.Target, "swap", "swapfile"))
+	}
+	if err := e.run("umount", "-R", e.resources.Target); err != nil {
+		return err
+	}
+	if err := e.run("umount", "-R", e.resources.TopLevelMount); err != nil {
+		return err
+	}
+	if e.luks() {
+		e.notify("closing luks")
+		_ = e.run("cryptsetup", "luksClose", e.resources.LuksDevice)
+	}
+
+	e.notify("INSTALLATION FINISHED")
+	if cmd := e.parameters["AFTER_INSTALLED_CMD"]; cmd != "" {
+		e.notify("running %s", cmd)
+		return e.run("sh", "-c", cmd)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// explainLines is how many of the last output lines are searched for a known error
+	explainLines = 40
+	excerptLines = 10
+)
+
+// InstallError explains why an installation failed
+type InstallError struct {
+	ExitCode int      `json:"exit_code"`
+	Step     string   `json:"step"`
+	Cause    string   `json:"cause"`
+	Remedy   string   `json:"remedy"`
+	Excerpt  []string `json:"excerpt"`
+}
+
+type errorExplanation struct {
+	// patterns are matched case-insensitively against the output lines
+	patterns []string
+	cause    string
+	remedy   string
+}
+
+// exitCodeExplanations are the checks installer.sh does before it starts the first step
+var exitCodeExplanations = map[int]errorExplanation{
+	1: {[]string{"must be run by root"}, "the installer was not run as root",
+		"run the back-end as root, as the installer image does"},
+	2: {[]string{"DISK variable is missing"}, "no disk was selected",
+		"select the disk to install to"},
+	3: {[]string{"LUKS_PASSWORD variable is missing"}, "the disk encryption password is missing",
+		"enter the LUKS password or disable the disk encryption"},
+}
+
+var errorExplanations = []errorExplanation{
+	{[]string{"no space left on device"}, "the disk ran out of space",
+		"use a bigger disk or a smaller swap file (SWAP_SIZE)"},
+	{[]string{"tpm2 device not found", "no tpm2 device", "no tpm device"}, "the TPM could not be used to unlock the disk",
+		"enable the TPM in the firmware setup, or install without TPM unlocking (ENABLE_TPM=false)"},
+	{[]string{"temporary failure resolving", "could not resolve"}, "the name of the Debian mirror could not be resolved",
+		"check the network connection and DNS, e.g. with ping deb.debian.org"},
+	{[]string{"couldn't download", "failed to fetch", "unable to fetch"}, "packages could not be downloaded from the Debian mirror",
+		"check the network connection and that the mirror is reachable, then try again"},
+	{[]string{"device or resource busy", "device busy", "target is busy"}, "the disk is in use",
+		"unmount its file systems, turn off its swap and close its LUKS devices, or reboot the installer"},
+	{[]string{"no key available with this passphrase"}, "the LUKS password was not accepted",
+		"start the installation again with the same LUKS password, or clear the working directory to start over"},
+	{[]string{"cannot allocate memory", "out of memory"}, "the installer ran out of memory",
+		"use a machine with more memory, or close the web browser on the installer"},
+	{[]string{"sub-process /usr/bin/dpkg returned an error code", "dpkg: error processing"}, "a package failed to install",
+		"look for the package in the log excerpt, then try again; finished steps are not repeated"},
+}
+
+func (e errorExplanation) matches(line string) bool {
+	line = strings.ToLower(line)
+	for _, p := range e.patterns {
+		if strings.Contains(line, strings.ToLower(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExplainFailure combines the exit code, the last step that started and the end of the output
+// into an explanation of the failure
+func ExplainFailure(exitCode int, progress Progress, output []byte) InstallError {
+	lines := nonEmptyLines(output)
+	e := InstallError{
+		ExitCode: exitCode,
+		Step:     progress.Step,
+		Excerpt:  lastOf(lines, excerptLines),
+	}
+	if exitCode < 0 {
+		e.Cause = "the installation was stopped"
+		e.Remedy = "start the installation again, finished steps are not repeated"
+		return e
+	}
+	// the most recent known error is the most likely cause
+	recent := lastOf(lines, explainLines)
+	if explanation, found := exitCodeExplanations[exitCode]; found && progress.Current == 0 {
+		for _, line := range recent {
+			if explanation.matches(line) {
+				e.Cause, e.Remedy = explanation.cause, explanation.remedy
+				return e
+			}
+		}
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		for _, explanation := range errorExplanations {
+			if explanation.matches(recent[i]) {
+				e.Cause, e.Remedy = explanation.cause, explanation.remedy
+				return e
+			}
+		}
+	}
+	if e.Step != "" {
+		e.Cause = fmt.Sprintf("the %s step failed with exit code %d", e.Step, exitCode)
+	} else {
+		e.Cause = fmt.Sprintf("the installer failed with exit code %d", exitCode)
+	}
+	e.Remedy = "look for the error in the log excerpt, fix it and start the installation again"
+	return e
+}
+
+func nonEmptyLines(output []byte) []string {
+	// not nil, the excerpt of an empty output is an empty list in JSON
+	lines := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func lastOf(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
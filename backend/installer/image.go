@@ -0,0 +1,233 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ImageOutput is implemented by the installers that leave a disk image file
+type ImageOutput interface {
+	// Image returns the path of the finished disk image, once the installation succeeded
+	Image() (string, bool)
+}
+
+// imageExitCode is the exit code of an installation that succeeded, but whose image could not be finished
+const imageExitCode = 1
+
+// commandRunner runs a command to completion, it returns the standard output and writes the standard error to output
+type commandRunner func(ctx context.Context, output io.Writer, command ...string) (string, error)
+
+func runCommand(ctx context.Context, output io.Writer, command ...string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = output
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w", strings.Join(command, " "), err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// ImageInstaller installs to a disk image file instead of a disk: it creates the file, attaches it
+// as a loop device, runs the Inner installer on the loop device and detaches it again.
+// The finished image is converted to qcow2 when asked to and qemu-img is available.
+type ImageInstaller struct {
+	Inner Installer
+	// Path is the raw image file, DISK of the installation
+	Path string
+	// Size is the size of the image in bytes
+	Size   int64
+	Format string
+	run    commandRunner
+	// hasCommand looks for qemu-img
+	hasCommand func(name string) bool
+	loopDevice string
+	image      string
+	lock       sync.Mutex
+	done       chan struct{}
+	exitCode   int
+	err        error
+}
+
+// NewImageInstaller wraps inner to install to the image in the DISK, IMAGE_SIZE and IMAGE_FORMAT parameters
+func NewImageInstaller(inner Installer, parameters map[string]string) (*ImageInstaller, error) {
+	path, err := filepath.Abs(parameters["DISK"])
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(path, "/dev/") {
+		return nil, fmt.Errorf("DISK must be a disk image file outside of /dev, not %s", path)
+	}
+	size, err := strconv.Atoi(parameters["IMAGE_SIZE"])
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("IMAGE_SIZE must be the size of the image in GB, not %q", parameters["IMAGE_SIZE"])
+	}
+	return &ImageInstaller{
+		Inner:  inner,
+		Path:   path,
+		Size:   int64(size) << 30,
+		Format: parameters["IMAGE_FORMAT"],
+		run:    runCommand,
+		hasCommand: func(name string) bool {
+			_, err := exec.LookPath(name)
+			return err == nil
+		},
+		exitCode: -1,
+	}, nil
+}
+
+func (i *ImageInstaller) Start(ctx context.Context, parameters map[string]string, output Output) error {
+	f, err := os.OpenFile(i.Path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create the disk image: %w", err)
+	}
+	// truncating to 0 first leaves a sparse file without the data of a previous image
+	err = f.Truncate(0)
+	if err == nil {
+		err = f.Truncate(i.Size)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to size the disk image: %w", err)
+	}
+	i.loopDevice, err = i.run(ctx, output, "losetup", "--find", "--show", "--partscan", i.Path)
+	if err != nil {
+		return fmt.Errorf("failed to attach the disk image: %w", err)
+	}
+	_, _ = fmt.Fprintf(output, "attached disk image %s as %s\n", i.Path, i.loopDevice)
+
+	parameters = maps.Clone(parameters)
+	parameters["DISK"] = i.loopDevice
+	err = i.Inner.Start(ctx, parameters, output)
+	if err != nil {
+		i.detach(output)
+		return err
+	}
+	i.done = make(chan struct{})
+	go func() {
+		err := i.Inner.Wait()
+		_, exitCode := i.Inner.Result()
+		// the loop device must not stay attached, even if the installation was cancelled
+		ctx := context.WithoutCancel(ctx)
+		detachErr := i.detach(output)
+		if err == nil && detachErr != nil {
+			err, exitCode = detachErr, imageExitCode
+		}
+		var image string
+		if err == nil {
+			image, err = i.finish(ctx, output)
+			if err != nil {
+				exitCode = imageExitCode
+			}
+		}
+		i.lock.Lock()
+		i.image, i.exitCode, i.err = image, exitCode, err
+		i.lock.Unlock()
+		close(i.done)
+	}()
+	return nil
+}
+
+func (i *ImageInstaller) detach(output io.Writer) error {
+	_, err := i.run(context.Background(), output, "losetup", "--detach", i.loopDevice)
+	if err != nil {
+		slog.Error("failed to detach the disk image", "device", i.loopDevice, "error", err)
+		_, _ = fmt.Fprintf(output, "failed to detach %s: %v\n", i.loopDevice, err)
+	}
+	return err
+}
+
+// finish converts the detached image to the requested format and returns the path of the finished image
+func (i *ImageInstaller) finish(ctx context.Context, output io.Writer) (string, error) {
+	if i.Format != config.ImageQcow2 {
+		_, _ = fmt.Fprintf(output, "disk image ready at %s\n", i.Path)
+		return i.Path, nil
+	}
+	if !i.hasCommand("qemu-img") {
+		_, _ = fmt.Fprintf(output, "qemu-img not found, keeping the raw disk image at %s\n", i.Path)
+		return i.Path, nil
+	}
+	qcow2 := strings.TrimSuffix(i.Path, filepath.Ext(i.Path)) + ".qcow2"
+	if qcow2 == i.Path {
+		qcow2 = i.Path + ".qcow2"
+	}
+	_, _ = fmt.Fprintf(output, "converting the disk image to %s\n", qcow2)
+	_, err := i.run(ctx, output, "qemu-img", "convert", "-f", "raw", "-O", "qcow2", i.Path, qcow2)
+	if err != nil {
+		return i.Path, fmt.Errorf("failed to convert the disk image: %w", err)
+	}
+	if err = os.Remove(i.Path); err != nil {
+		slog.Warn("failed to remove the raw disk image", "path", i.Path, "error", err)
+	}
+	_, _ = fmt.Fprintf(output, "disk image ready at %s\n", qcow2)
+	return qcow2, nil
+}
+
+func (i *ImageInstaller) Image() (string, bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.image, i.image != "" && i.err == nil
+}
+
+func (i *ImageInstaller) Cancel() error {
+	return i.Inner.Cancel()
+}
+
+func (i *ImageInstaller) Wait() error {
+	<-i.done
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.err
+}
+
+func (i *ImageInstaller) Progress() Progress {
+	return i.Inner.Progress()
+}
+
+// Result is finished once the image is detached and converted, not when the inner installer is
+func (i *ImageInstaller) Result() (bool, int) {
+	select {
+	case <-i.done:
+		i.lock.Lock()
+		defer i.lock.Unlock()
+		return true, i.exitCode
+	default:
+		return false, -1
+	}
+}
+
+func (i *ImageInstaller) Command() string {
+	return fmt.Sprintf("%s on %s attached from %s", i.Inner.Command(), i.loopDevice, i.Path)
+}
@@ -0,0 +1,256 @@
+// Package installer runs the installation, with installer.sh, the native step engine, a remote back-end or the simulated installer, and renders and verifies what it installs.
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Output receives everything an Installer produces while it runs
+type Output interface {
+	io.Writer
+	StepStarted(name string)
+}
+
+// Installer is a strategy to install the system, e.g. by running installer.sh
+type Installer interface {
+	// Start runs the installation in the background
+	Start(ctx context.Context, parameters map[string]string, output Output) error
+	// Cancel stops a running installation
+	Cancel() error
+	// Wait blocks until the installation finishes and returns nil if it was successful
+	Wait() error
+	Progress() Progress
+	// Result returns the exit code once the installation is finished
+	Result() (finished bool, exitCode int)
+	// Command describes what is being run, for the logs
+	Command() string
+}
+
+type Progress struct {
+	Step    string `json:"step"`
+	Current int    `json:"current"`
+	Total   int    `json:"total"`
+}
+
+type InstallStep struct {
+	Name string
+	// Markers are the beginnings of the lines installer.sh prints when it starts the step
+	Markers []string
+}
+
+// installSteps are the phases of every installation, in order
+var installSteps = []InstallStep{
+	{"partition", []string{"setting up partitions on"}},
+	{"luks", []string{"setup luks password on", "open luks on root"}},
+	{"mount", []string{"mount top-level subvolume on"}},
+	{"bootstrap", []string{"send installer bootrstrap data", "create @ subvolume", "install debian on"}},
+	{"fstab", []string{"setup fstab"}},
+	{"sources", []string{"setup sources list"}},
+	{"users", []string{"set up "}},
+	{"bootloader", []string{"configuring dracut and kernel command line"}},
+	{"packages", []string{"install kernel and firmware on"}},
+	{"cleanup", []string{"cleaning up"}},
+}
+
+// New creates the Installer selected by INSTALLER_RUNNER, it installs with the given resources.
+// With TARGET_TYPE image, it installs to the disk image file in DISK. The remote runner leaves the image
+// to the remote back-end and the simulated one does not touch anything.
+func New(parameters map[string]string, resources Resources) (Installer, error) {
+	inner, err := newRunner(parameters, resources)
+	if err != nil || parameters["TARGET_TYPE"] != config.TargetImage {
+		return inner, err
+	}
+	if runner := parameters["INSTALLER_RUNNER"]; runner == config.RunnerRemote || runner == config.RunnerSimulated {
+		return inner, nil
+	}
+	return NewImageInstaller(inner, parameters)
+}
+
+// newRunner picks the runner from the settings of the back-end, the server refuses them in requests
+func newRunner(parameters map[string]string, resources Resources) (Installer, error) {
+	runner := parameters["INSTALLER_RUNNER"]
+	switch runner {
+	case config.RunnerScript, "":
+		path := parameters["INSTALLER_SCRIPT"]
+		if path == "" {
+			return nil, fmt.Errorf("INSTALLER_SCRIPT is not set")
+		}
+		if strings.Contains(path, "/") {
+			// the script runs in the working directory of the installation
+			var err error
+			if path, err = filepath.Abs(path); err != nil {
+				return nil, err
+			}
+		}
+		return &ScriptInstaller{Path: path, Resources: resources}, nil
+	case config.RunnerSimulated:
+		return &FakeInstaller{}, nil
+	case config.RunnerRemote:
+		if parameters["INSTALLER_REMOTE_URL"] == "" {
+			return nil, fmt.Errorf("INSTALLER_REMOTE_URL is not set")
+		}
+		return NewRemoteInstaller(parameters["INSTALLER_REMOTE_URL"], parameters["INSTALLER_REMOTE_TOKEN"])
+	case config.RunnerNative:
+		return NewStepEngine(resources)
+	default:
+		return nil, fmt.Errorf("unknown INSTALLER_RUNNER %q", runner)
+	}
+}
+
+// stepTracker follows the output of an installer line by line to find out which step is running
+type stepTracker struct {
+	output  Output
+	lock    sync.Mutex
+	partial []byte
+	current int
+}
+
+func newStepTracker(output Output) *stepTracker {
+	return &stepTracker{output: output, current: -1}
+}
+
+func (t *stepTracker) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	t.partial = append(t.partial, p...)
+	var started []string
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(t.partial[:i])
+		t.partial = t.partial[i+1:]
+		if step := t.match(line); step > t.current {
+			t.current = step
+			started = append(started, installSteps[step].Name)
+		}
+	}
+	t.lock.Unlock()
+
+	n, err := t.output.Write(p)
+	for _, name := range started {
+		t.output.StepStarted(name)
+	}
+	return n, err
+}
+
+func (t *stepTracker) match(line string) int {
+	for i, step := range installSteps {
+		for _, marker := range step.Markers {
+			if strings.HasPrefix(line, marker) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Progress is before the first step until the installer started, the tracker is created by Start
+func (t *stepTracker) Progress() Progress {
+	if t == nil {
+		return stepProgress(-1)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return stepProgress(t.current)
+}
+
+func stepProgress(current int) Progress {
+	p := Progress{Current: current + 1, Total: len(installSteps)}
+	if current >= 0 && current < len(installSteps) {
+		p.Step = installSteps[current].Name
+	}
+	return p
+}
+
+// ScriptInstaller runs installer.sh in the working directory of the Resources,
+// passing the parameters and the other resources as environment variables
+type ScriptInstaller struct {
+	Path      string
+	Resources Resources
+	cmd       *exec.Cmd
+	cancel    context.CancelFunc
+	tracker   *stepTracker
+	done      chan struct{}
+	err       error
+}
+
+func (s *ScriptInstaller) Start(ctx context.Context, parameters map[string]string, output Output) error {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.tracker = newStepTracker(output)
+	s.cmd = exec.CommandContext(ctx, s.Path)
+	s.cmd.Stderr = s.tracker
+	s.cmd.Stdout = s.tracker
+	s.cmd.Dir = s.Resources.WorkDir
+	for k, v := range parameters {
+		s.cmd.Env = append(s.cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	s.cmd.Env = append(s.cmd.Env, s.Resources.environ()...)
+	err := s.cmd.Start()
+	if err != nil {
+		s.cancel()
+		return err
+	}
+	s.done = make(chan struct{})
+	go func() {
+		s.err = s.cmd.Wait()
+		close(s.done)
+	}()
+	return nil
+}
+
+func (s *ScriptInstaller) Cancel() error {
+	s.cancel()
+	return nil
+}
+
+func (s *ScriptInstaller) Wait() error {
+	<-s.done
+	return s.err
+}
+
+func (s *ScriptInstaller) Progress() Progress {
+	return s.tracker.Progress()
+}
+
+func (s *ScriptInstaller) Result() (bool, int) {
+	select {
+	case <-s.done:
+		return true, s.cmd.ProcessState.ExitCode()
+	default:
+		return false, -1
+	}
+}
+
+func (s *ScriptInstaller) Command() string {
+	if s.cmd == nil {
+		return s.Path
+	}
+	return strings.Join(s.cmd.Args, " ")
+}
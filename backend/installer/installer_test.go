@@ -0,0 +1,291 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type testOutput struct {
+	strings.Builder
+	steps []string
+}
+
+func (o *testOutput) StepStarted(name string) {
+	o.steps = append(o.steps, name)
+}
+
+func TestMakeInstallPlan(t *testing.T) {
+	plan := MakePlan(map[string]string{
+		"DISABLE_LUKS": "true",
+		"SWAP_SIZE":    "0",
+		"HOSTNAME":     "debian13",
+	})
+	if plan.Valid {
+		t.Errorf("Plan without DISK is valid")
+	}
+	if len(plan.Issues) != 1 || plan.Issues[0].Key != "DISK" {
+		t.Errorf("Issues = %v; want a single DISK issue", plan.Issues)
+	}
+	if !strings.Contains(plan.RepartDefinitions[1].Content, "Encrypt=off") {
+		t.Errorf("Root partition definition = %s; want Encrypt=off", plan.RepartDefinitions[1].Content)
+	}
+	if strings.Contains(plan.KernelCommandLine, "resume=") || strings.Contains(plan.KernelCommandLine, "rd.luks") {
+		t.Errorf("Kernel command line = %s; want no resume and no luks", plan.KernelCommandLine)
+	}
+
+	plan = MakePlan(map[string]string{"DISK": "/dev/vda", "DISABLE_LUKS": "true", "ENABLE_TPM": "true"})
+	if plan.Valid || len(plan.Issues) != 1 || plan.Issues[0].Key != "ENABLE_TPM" {
+		t.Errorf("Issues with ENABLE_TPM and DISABLE_LUKS = %v; want a single ENABLE_TPM issue", plan.Issues)
+	}
+}
+
+func TestScriptInstaller(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "installer.sh")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho setting up partitions on $DISK\necho setup fstab\nexit 3\n"), 0700)
+	if err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	installer := ScriptInstaller{Path: script}
+	output := testOutput{}
+	err = installer.Start(context.Background(), map[string]string{"DISK": "/dev/vdb"}, &output)
+	if err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	if err = installer.Wait(); err == nil {
+		t.Errorf("Wait() = nil; want an error")
+	}
+	if finished, code := installer.Result(); !finished || code != 3 {
+		t.Errorf("Result() = %v, %d; want true, 3", finished, code)
+	}
+	if !strings.Contains(output.String(), "/dev/vdb") {
+		t.Errorf("Output = %s; want the DISK parameter", output.String())
+	}
+	if strings.Join(output.steps, ",") != "partition,fstab" {
+		t.Errorf("Steps = %v; want partition and fstab", output.steps)
+	}
+	if p := installer.Progress(); p.Step != "fstab" {
+		t.Errorf("Progress = %v; want fstab", p)
+	}
+}
+
+func TestScriptInstallerResources(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "installer.sh")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho $(pwd) $TARGET $TOP_LEVEL_MOUNT $LUKS_DEVICE_NAME\n"), 0700)
+	if err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	resources := SlotResources("/dev/vdb", 2)
+	resources.WorkDir = filepath.Join(dir, resources.WorkDir)
+	if err = os.MkdirAll(resources.WorkDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	installer := ScriptInstaller{Path: script, Resources: resources}
+	output := testOutput{}
+	if err = installer.Start(context.Background(), map[string]string{}, &output); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	if err = installer.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	want := resources.WorkDir + " /target-2 /mnt/top_level_mount-2 root-2"
+	if got := strings.TrimSpace(output.String()); got != want {
+		t.Errorf("Output = %q; want %q", got, want)
+	}
+}
+
+func TestResourcesConflicts(t *testing.T) {
+	first := SlotResources("/dev/vda", 0)
+	if conflicts := first.Conflicts(SlotResources("/dev/vdb", 1)); len(conflicts) > 0 {
+		t.Errorf("Conflicts of different slots = %v; want none", conflicts)
+	}
+	if conflicts := first.Conflicts(SlotResources("/dev/vda", 1)); !slices.Equal(conflicts, []string{"disk /dev/vda"}) {
+		t.Errorf("Conflicts on the same disk = %v; want the disk", conflicts)
+	}
+	nested := SlotResources("/dev/vdb", 1)
+	nested.Target = "/target/mnt"
+	if conflicts := first.Conflicts(nested); !slices.Equal(conflicts, []string{"mount point /target"}) {
+		t.Errorf("Conflicts with a nested target = %v; want the mount point", conflicts)
+	}
+	if conflicts := first.Conflicts(first); len(conflicts) != 5 {
+		t.Errorf("Conflicts with itself = %v; want all 5 resources", conflicts)
+	}
+}
+
+func TestImageInstaller(t *testing.T) {
+	for _, qemuImg := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "template.img")
+		installer, err := NewImageInstaller(&FakeInstaller{}, map[string]string{"DISK": path, "IMAGE_SIZE": "2", "IMAGE_FORMAT": "qcow2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var commands []string
+		installer.run = func(_ context.Context, _ io.Writer, command ...string) (string, error) {
+			commands = append(commands, strings.Join(command, " "))
+			if command[0] == "qemu-img" {
+				return "", os.WriteFile(command[len(command)-1], []byte("QFI"), 0600)
+			}
+			return "/dev/loop7", nil
+		}
+		installer.hasCommand = func(string) bool { return qemuImg }
+		output := testOutput{}
+		if err = installer.Start(context.Background(), map[string]string{"DISK": path}, &output); err != nil {
+			t.Fatalf("Failed to start: %v", err)
+		}
+		if err = installer.Wait(); err != nil {
+			t.Fatalf("Wait() = %v", err)
+		}
+		if !strings.Contains(output.String(), "setting up partitions on /dev/loop7") {
+			t.Errorf("Output = %s; want the installation on the loop device", output.String())
+		}
+		want := []string{"losetup --find --show --partscan " + path, "losetup --detach /dev/loop7"}
+		wantImage := path
+		if qemuImg {
+			wantImage = strings.TrimSuffix(path, ".img") + ".qcow2"
+			want = append(want, fmt.Sprintf("qemu-img convert -f raw -O qcow2 %s %s", path, wantImage))
+		}
+		if !slices.Equal(commands, want) {
+			t.Errorf("Commands with qemu-img %v = %v; want %v", qemuImg, commands, want)
+		}
+		if image, ok := installer.Image(); !ok || image != wantImage {
+			t.Errorf("Image() = %s, %v; want %s", image, ok, wantImage)
+		}
+		if info, err := os.Stat(path); qemuImg != (err != nil) || (err == nil && info.Size() != 2<<30) {
+			t.Errorf("Raw image with qemu-img %v: %v, %v", qemuImg, info, err)
+		}
+	}
+}
+
+func TestStepEngineRetry(t *testing.T) {
+	attempts := 0
+	engine := StepEngine{
+		steps: []engineStep{
+			{name: "fstab", requires: []string{"partition"}, run: func(e *stepEnv) error {
+				attempts++
+				if attempts == 1 {
+					return fmt.Errorf("failed")
+				}
+				return nil
+			}},
+			{name: "partition", run: func(e *stepEnv) error { return nil }},
+		},
+		decisions: make(chan stepDecision, 1),
+		current:   -1,
+		exitCode:  -1,
+	}
+	var err error
+	engine.order, err = sortSteps(engine.steps)
+	if err != nil {
+		t.Fatalf("Failed to sort steps: %v", err)
+	}
+	for _, step := range engine.steps {
+		engine.results = append(engine.results, StepResult{Name: step.name, Status: StepPending})
+	}
+	output := testOutput{}
+	err = engine.Start(context.Background(), map[string]string{}, &output)
+	if err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	for engine.Retry("fstab") != nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = engine.Wait(); err != nil {
+		t.Errorf("Wait() = %v; want nil", err)
+	}
+	steps := engine.Steps()
+	if steps[0].Name != "partition" || steps[1].Status != StepDone || steps[1].Attempts != 2 {
+		t.Errorf("Steps = %v; want partition first and fstab done after 2 attempts", steps)
+	}
+}
+
+func TestVerifyInstalledSystem(t *testing.T) {
+	parameters := map[string]string{"HOSTNAME": "pc1", "USERNAME": "bob", "TIMEZONE": "Europe/Vienna", "ENABLE_MOK_SIGNED_UKI": "true"}
+	ids := Identifiers{EfiPartUuid: "efi", MainPartUuid: "main", BtrfsUuid: "btrfs"}
+	cmdline := RenderKernelCommandLine(parameters, ids)
+	root := fstest.MapFS{
+		"etc/fstab":                     {Data: []byte(RenderFstab(parameters, ids).Content)},
+		"etc/kernel/cmdline":            {Data: []byte(cmdline + "\n")},
+		"etc/hostname":                  {Data: []byte("pc2\n")},
+		"etc/timezone":                  {Data: []byte("Europe/Vienna\n")},
+		"etc/passwd":                    {Data: []byte("root:x:0:0:root:/root:/bin/bash\nbob:x:1000:1000::/home/bob:/bin/bash\n")},
+		"boot/efi/EFI/Linux/debian.efi": {Data: SimulatedUki(strings.Replace(cmdline, "splash", "", 1), false)},
+	}
+	resolve := func(path string) (string, error) {
+		if strings.HasSuffix(path, "/efi") || strings.HasSuffix(path, "/btrfs") {
+			return path, nil
+		}
+		return "", fmt.Errorf("%s: no such device", path)
+	}
+
+	failures := map[string]string{}
+	for _, check := range VerifyInstalledSystem(root, parameters, ids, resolve) {
+		if !check.Passed {
+			failures[check.Name] = check.Message
+		}
+	}
+	want := []string{"hostname", "kernel cmdline", "uki signature"}
+	if got := slices.Sorted(maps.Keys(failures)); !slices.Equal(got, want) {
+		t.Errorf("Failed checks = %v; want %v", failures, want)
+	}
+	if !strings.Contains(failures["kernel cmdline"], "splash") {
+		t.Errorf("kernel cmdline failure = %q; want it to name the missing splash", failures["kernel cmdline"])
+	}
+}
+
+func TestExplainFailure(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		progress Progress
+		output   string
+		cause    string
+	}{
+		{2, Progress{}, "DISK variable is missing\n", "no disk was selected"},
+		{1, Progress{Step: "luks", Current: 2}, "setup luks password on /dev/vda\nFailed to enroll TPM2 key: TPM2 device not found\n", "the TPM could not be used to unlock the disk"},
+		{100, Progress{Step: "bootstrap", Current: 4}, "E: Could not resolve 'deb.debian.org'\nE: No space left on device\n", "the disk ran out of space"},
+		{1, Progress{Step: "partition", Current: 1}, "Failed to open file or determine backing device of /dev/sda: Device or resource busy\n", "the disk is in use"},
+		{1, Progress{Step: "packages", Current: 9}, "Temporary failure resolving 'deb.debian.org'\n", "the name of the Debian mirror could not be resolved"},
+		{5, Progress{Step: "fstab", Current: 5}, "something unexpected\n", "the fstab step failed with exit code 5"},
+	}
+	for _, test := range tests {
+		e := ExplainFailure(test.exitCode, test.progress, []byte(test.output))
+		if e.Cause != test.cause || e.Remedy == "" || e.Step != test.progress.Step {
+			t.Errorf("explainFailure(%d, %q) = %+v; want cause %q", test.exitCode, test.output, e, test.cause)
+		}
+		lines := strings.Split(strings.TrimSpace(test.output), "\n")
+		if !slices.Equal(e.Excerpt, lines) {
+			t.Errorf("Excerpt = %q; want %q", e.Excerpt, lines)
+		}
+	}
+	e := ExplainFailure(-1, Progress{}, nil)
+	if b, err := json.Marshal(e); err != nil || !strings.Contains(string(b), `"excerpt":[]`) {
+		t.Errorf("Marshal(ExplainFailure of no output) = %s, %v; want an empty excerpt", b, err)
+	}
+}
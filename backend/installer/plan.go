@@ -0,0 +1,272 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the functions in this file render the same files installer.sh writes inline,
+// when changing installer.sh, you need to change them too
+
+const (
+	fsFlags              = "compress=zstd:1"
+	LuksDeviceName       = "root"
+	BootstrapImagePath   = "/root/btrfs1/opinionated_installer_bootstrap"
+	DebianMirror         = "http://deb.debian.org/debian"
+	defaultDebianVersion = "trixie"
+)
+
+type PlanFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+type PackageLists struct {
+	Base      []string `json:"base"`
+	Backports []string `json:"backports"`
+	Optional  []string `json:"optional"`
+}
+
+type InstallPlan struct {
+	Valid             bool                    `json:"valid"`
+	Issues            []config.ParameterIssue `json:"issues"`
+	Parameters        map[string]string       `json:"parameters"`
+	RepartDefinitions []PlanFile              `json:"repart_definitions"`
+	Fstab             PlanFile                `json:"fstab"`
+	KernelCommandLine string                  `json:"kernel_command_line"`
+	Sources           []PlanFile              `json:"sources"`
+	Packages          PackageLists            `json:"packages"`
+}
+
+// Identifiers are only known while installing, the plan uses the existing values or placeholders
+type Identifiers struct {
+	EfiPartUuid    string
+	MainPartUuid   string
+	BtrfsUuid      string
+	SwapfileOffset string
+}
+
+var basePackages = []string{
+	"btrfsmaintenance",
+	"locales",
+	"adduser",
+	"passwd",
+	"sudo",
+	"tasksel",
+	"network-manager",
+	"binutils",
+	"console-setup",
+	"exim4-daemon-light",
+	"kpartx",
+	"pigz",
+	"pkg-config",
+}
+
+var backportsPackages = []string{
+	"linux-image-amd64",
+	"systemd",
+	"systemd-cryptsetup",
+	"systemd-timesyncd",
+	"btrfs-progs",
+	"dosfstools",
+	"firmware-linux",
+	"atmel-firmware",
+	"bluez-firmware",
+	"dahdi-firmware-nonfree",
+	"firmware-amd-graphics",
+	"firmware-ath9k-htc",
+	"firmware-atheros",
+	"firmware-bnx2",
+	"firmware-bnx2x",
+	"firmware-brcm80211",
+	"firmware-carl9170",
+	"firmware-cavium",
+	"firmware-intel-misc",
+	"firmware-intel-sound",
+	"firmware-iwlwifi",
+	"firmware-libertas",
+	"firmware-misc-nonfree",
+	"firmware-myricom",
+	"firmware-netronome",
+	"firmware-netxen",
+	"firmware-qcom-soc",
+	"firmware-qlogic",
+	"firmware-realtek",
+	"firmware-ti-connectivity",
+	"firmware-zd1211",
+	"cryptsetup",
+	"dracut",
+	"lvm2",
+	"mdadm",
+	"plymouth-themes",
+	"polkitd",
+	"tpm2-tools",
+	"tpm-udev",
+}
+
+func MakePlan(parameters map[string]string) InstallPlan {
+	issues := config.Validate(parameters)
+	ids := Identifiers{
+		EfiPartUuid:    ReadIdentifier("efi-part.uuid", "<efi-part-uuid>"),
+		MainPartUuid:   ReadIdentifier("main-part.uuid", "<main-part-uuid>"),
+		BtrfsUuid:      "<btrfs-uuid>",
+		SwapfileOffset: "<swapfile-offset>",
+	}
+	return InstallPlan{
+		Valid:             len(issues) == 0,
+		Issues:            issues,
+		Parameters:        config.Redact(parameters),
+		RepartDefinitions: renderRepartDefinitions(parameters, ids),
+		Fstab:             RenderFstab(parameters, ids),
+		KernelCommandLine: RenderKernelCommandLine(parameters, ids),
+		Sources:           renderSources(parameters),
+		Packages:          renderPackageLists(parameters),
+	}
+}
+
+func ReadIdentifier(fileName string, placeholder string) string {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return placeholder
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func DebianVersion(parameters map[string]string) string {
+	if v := parameters["DEBIAN_VERSION"]; v != "" {
+		return v
+	}
+	return defaultDebianVersion
+}
+
+func swapSize(parameters map[string]string) int {
+	size, err := strconv.Atoi(parameters["SWAP_SIZE"])
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+func rootDevice(parameters map[string]string, ids Identifiers) string {
+	if parameters["DISABLE_LUKS"] == "true" {
+		return "/dev/disk/by-partuuid/" + ids.MainPartUuid
+	}
+	return "/dev/mapper/" + LuksDeviceName
+}
+
+func renderRepartDefinitions(parameters map[string]string, ids Identifiers) []PlanFile {
+	efi := fmt.Sprintf(`[Partition]
+Type=esp
+UUID=%s
+SizeMinBytes=1024M
+SizeMaxBytes=1024M
+Format=vfat
+`, ids.EfiPartUuid)
+	root := fmt.Sprintf(`[Partition]
+Type=root
+Label=Debian
+UUID=%s
+Format=btrfs
+MakeDirectories=/@home
+Subvolumes=/@home
+`, ids.MainPartUuid)
+	switch {
+	case parameters["DISABLE_LUKS"] == "true":
+		root += "Encrypt=off\n"
+	case parameters["ENABLE_TPM"] == "true":
+		root += "Encrypt=key-file+tpm2\n"
+	default:
+		root += "Encrypt=key-file\n"
+	}
+	return []PlanFile{
+		{Path: "repart.d/01_efi.conf", Content: efi},
+		{Path: "repart.d/02_root.conf", Content: root},
+	}
+}
+
+func RenderKernelCommandLine(parameters map[string]string, ids Identifiers) string {
+	params := fmt.Sprintf("rw quiet rootfstype=btrfs rootflags=%s,subvol=@ rd.auto=1 splash", fsFlags)
+	if parameters["DISABLE_LUKS"] != "true" {
+		params = "rd.luks.options=tpm2-device=auto " + params
+	}
+	if swapSize(parameters) > 0 {
+		params += fmt.Sprintf(" resume=%s resume_offset=%s", rootDevice(parameters, ids), ids.SwapfileOffset)
+	}
+	return params
+}
+
+func RenderFstab(parameters map[string]string, ids Identifiers) PlanFile {
+	fstab := fmt.Sprintf(`UUID=%[1]s / btrfs defaults,subvol=@,%[2]s 0 1
+UUID=%[1]s /home btrfs defaults,subvol=@home,%[2]s 0 1
+UUID=%[1]s /root/btrfs1 btrfs defaults,subvolid=5,%[2]s 0 1
+PARTUUID=%[3]s /boot/efi vfat defaults,umask=077 0 2
+`, ids.BtrfsUuid, fsFlags, ids.EfiPartUuid)
+	if swapSize(parameters) > 0 {
+		fstab += fmt.Sprintf(`UUID=%s /swap btrfs defaults,subvol=@swap,noatime,%s 0 0
+/swap/swapfile none swap defaults 0 0
+`, ids.BtrfsUuid, fsFlags)
+	}
+	return PlanFile{Path: "/etc/fstab", Content: fstab}
+}
+
+func renderSources(parameters map[string]string) []PlanFile {
+	version := DebianVersion(parameters)
+	stanza := func(uri string, suite string) string {
+		return fmt.Sprintf(`Types: deb
+URIs: %s
+Suites: %s
+Components: main contrib non-free non-free-firmware
+Signed-By: /usr/share/keyrings/debian-archive-keyring.gpg
+`, uri, suite)
+	}
+	return []PlanFile{
+		{Path: "/etc/apt/sources.list.d/debian.sources", Content: strings.Join([]string{
+			stanza("http://deb.debian.org/debian/", version),
+			stanza("http://deb.debian.org/debian/", version+"-updates"),
+			stanza("http://security.debian.org/debian-security/", version+"-security"),
+		}, "\n")},
+		{Path: "/etc/apt/sources.list.d/debian-backports.sources",
+			Content: stanza("http://deb.debian.org/debian/", version+"-backports")},
+	}
+}
+
+func renderPackageLists(parameters map[string]string) PackageLists {
+	lists := PackageLists{
+		Base:      basePackages,
+		Backports: backportsPackages,
+	}
+	if parameters["ENABLE_POPCON"] == "true" {
+		lists.Optional = append(lists.Optional, "popularity-contest")
+	}
+	if parameters["SSH_PUBLIC_KEY"] != "" {
+		lists.Optional = append(lists.Optional, "openssh-server")
+	}
+	if parameters["ENABLE_FLATHUB"] == "true" {
+		lists.Optional = append(lists.Optional, "flatpak")
+	}
+	if nvidia := parameters["NVIDIA_PACKAGE"]; nvidia != "" {
+		lists.Optional = append(lists.Optional, nvidia, "nvidia-driver-libs:i386", "linux-headers-amd64")
+	}
+	return lists
+}
@@ -0,0 +1,121 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/config"
+)
+
+// RemoteInstaller proxies the installation to another back-end
+type RemoteInstaller struct {
+	api      *client.Client
+	tracker  *stepTracker
+	lock     sync.Mutex
+	done     chan struct{}
+	exitCode int
+	err      error
+}
+
+func NewRemoteInstaller(baseUrl string, token string) (*RemoteInstaller, error) {
+	api, err := client.New(baseUrl, client.WithToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("invalid INSTALLER_REMOTE_URL: %w", err)
+	}
+	return &RemoteInstaller{api: api, exitCode: -1}, nil
+}
+
+func (r *RemoteInstaller) Start(ctx context.Context, parameters map[string]string, output Output) error {
+	r.tracker = newStepTracker(output)
+
+	// only send the installation parameters, not the whole environment of this back-end
+	post := make(map[string]string)
+	for _, p := range config.Definitions {
+		if v, found := parameters[p.Name]; found && !config.IsBackendSetting(p.Name) {
+			post[p.Name] = v
+		}
+	}
+	started, err := r.api.Install(ctx, post)
+	if err != nil {
+		return fmt.Errorf("remote back-end refused the installation: %w", err)
+	}
+	// the remote back-end may run other jobs, only follow and stop this one
+	r.api = r.api.Job(started.RunId)
+
+	r.done = make(chan struct{})
+	go func() {
+		// the log stream ends when the remote installation finishes
+		err := r.api.StreamLog(ctx, r.tracker)
+		if err != nil {
+			slog.Warn("reading remote output failed", "error", err)
+		}
+		code, err := r.remoteExitCode(context.WithoutCancel(ctx))
+		r.lock.Lock()
+		r.exitCode, r.err = code, err
+		r.lock.Unlock()
+		close(r.done)
+	}()
+	return nil
+}
+
+func (r *RemoteInstaller) remoteExitCode(ctx context.Context) (int, error) {
+	status, err := r.api.ProcessStatus(ctx)
+	if err != nil {
+		return -1, err
+	}
+	if status.Status != client.StatusFinished {
+		return -1, fmt.Errorf("remote installation did not finish: %s", status.Status)
+	}
+	if status.ReturnCode != 0 {
+		return status.ReturnCode, fmt.Errorf("exit status %d", status.ReturnCode)
+	}
+	return 0, nil
+}
+
+func (r *RemoteInstaller) Cancel() error {
+	// a remote installation that finished meanwhile keeps its log and result
+	_, err := r.api.Cancel(context.Background())
+	return err
+}
+func (r *RemoteInstaller) Wait() error {
+	<-r.done
+	return r.err
+}
+
+func (r *RemoteInstaller) Progress() Progress {
+	return r.tracker.Progress()
+}
+
+func (r *RemoteInstaller) Result() (bool, int) {
+	select {
+	case <-r.done:
+		return true, r.exitCode
+	default:
+		return false, -1
+	}
+}
+
+func (r *RemoteInstaller) Command() string {
+	return fmt.Sprintf("remote installation on %s", r.api.BaseUrl())
+}
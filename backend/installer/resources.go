@@ -0,0 +1,112 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Resources are what an installation uses exclusively on the machine that runs it,
+// two installations running at the same time must not share any of them
+type Resources struct {
+	Disk string `json:"disk"`
+	// WorkDir keeps the key file, the partition definitions and the identifiers of the installed system
+	WorkDir string `json:"work_dir"`
+	// Target is where the installed system is mounted
+	Target        string `json:"target"`
+	TopLevelMount string `json:"top_level_mount"`
+	// LuksDevice is the device mapper name of the opened root partition, the installed system always uses root
+	LuksDevice string `json:"luks_device"`
+}
+
+// the environment variables installer.sh reads the Resources from
+const (
+	targetVariable        = "TARGET"
+	topLevelMountVariable = "TOP_LEVEL_MOUNT"
+	luksDeviceVariable    = "LUKS_DEVICE_NAME"
+)
+
+// SlotResources are the resources of the installation in slot, slot 0 has the paths installer.sh
+// always used and the working directory of the back-end, the other slots get their own
+func SlotResources(disk string, slot int) Resources {
+	if slot == 0 {
+		return Resources{Disk: disk, WorkDir: ".", Target: TargetMount, TopLevelMount: topLevelMount, LuksDevice: LuksDeviceName}
+	}
+	suffix := fmt.Sprintf("-%d", slot)
+	return Resources{
+		Disk:          disk,
+		WorkDir:       filepath.Join("jobs", fmt.Sprint(slot)),
+		Target:        TargetMount + suffix,
+		TopLevelMount: topLevelMount + suffix,
+		LuksDevice:    LuksDeviceName + suffix,
+	}
+}
+
+// Conflicts lists the resources r shares with other, disks are compared as given,
+// resolve the links before to find the same disk under different names
+func (r Resources) Conflicts(other Resources) []string {
+	var conflicts []string
+	if r.Disk == other.Disk {
+		conflicts = append(conflicts, fmt.Sprintf("disk %s", r.Disk))
+	}
+	if filepath.Clean(r.WorkDir) == filepath.Clean(other.WorkDir) {
+		conflicts = append(conflicts, fmt.Sprintf("working directory %s", r.WorkDir))
+	}
+	for _, mount := range []string{r.Target, r.TopLevelMount} {
+		for _, otherMount := range []string{other.Target, other.TopLevelMount} {
+			if nested(mount, otherMount) || nested(otherMount, mount) {
+				conflicts = append(conflicts, fmt.Sprintf("mount point %s", mount))
+			}
+		}
+	}
+	if r.LuksDevice == other.LuksDevice {
+		conflicts = append(conflicts, fmt.Sprintf("luks device %s", r.LuksDevice))
+	}
+	return conflicts
+}
+
+// installerMountRegexp matches the mount points of every slot, e.g. /mnt/top_level_mount-2
+var installerMountRegexp = regexp.MustCompile(`^(` + regexp.QuoteMeta(TargetMount) + `|` + regexp.QuoteMeta(topLevelMount) + `)(-[0-9]+)?(/|$)`)
+
+// IsInstallerMount is true for the mount points of the installed systems of all slots and the file systems below them,
+// they stay mounted after a failed installation
+func IsInstallerMount(path string) bool {
+	return installerMountRegexp.MatchString(filepath.Clean(path))
+}
+
+// nested is true when path is dir or below it, e.g. /target/boot/efi and /target
+func nested(path string, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// environ is the part of the environment of installer.sh that tells it the resources,
+// it leaves out the ones not set so that installer.sh uses its defaults
+func (r Resources) environ() []string {
+	var env []string
+	for _, v := range [][2]string{{targetVariable, r.Target}, {topLevelMountVariable, r.TopLevelMount}, {luksDeviceVariable, r.LuksDevice}} {
+		if v[1] != "" {
+			env = append(env, v[0]+"="+v[1])
+		}
+	}
+	return env
+}
@@ -0,0 +1,238 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SimulatedUki builds the smallest PE image with a .cmdline section, optionally with a (fake) signature
+func SimulatedUki(cmdline string, signed bool) []byte {
+	var b bytes.Buffer
+	const peOffset = 0x40
+	dos := make([]byte, peOffset)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], peOffset)
+	b.Write(dos)
+	b.WriteString("PE\x00\x00")
+	optional := pe.OptionalHeader64{Magic: 0x20b, NumberOfRvaAndSizes: 16}
+	header := pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     1,
+		SizeOfOptionalHeader: uint16(binary.Size(optional)),
+	}
+	section := pe.SectionHeader32{VirtualAddress: 0x1000, VirtualSize: uint32(len(cmdline)), SizeOfRawData: uint32(len(cmdline))}
+	copy(section.Name[:], ".cmdline")
+	section.PointerToRawData = uint32(b.Len() + binary.Size(header) + binary.Size(optional) + binary.Size(section))
+	if signed {
+		optional.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY] = pe.DataDirectory{
+			VirtualAddress: section.PointerToRawData + section.SizeOfRawData, Size: 8}
+	}
+	for _, v := range []any{header, optional, section} {
+		_ = binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString(cmdline)
+	if signed {
+		b.Write(make([]byte, 8))
+	}
+	return b.Bytes()
+}
+
+type simulatedStep struct {
+	name  string
+	lines []string
+}
+
+// simulatedSteps follow the output of installer.sh
+var simulatedSteps = []simulatedStep{
+	{"partition", []string{
+		"setting up partitions on ${DISK}",
+		"${DISK}: 8 bytes were erased at offset 0x00000200 (gpt): 45 46 49 20 50 41 52 54",
+		"Applying changes to ${DISK}.",
+		"Adding new partition 0 to partition table.",
+		"Adding new partition 1 to partition table.",
+		"Writing new partition table.",
+		"All done.",
+	}},
+	{"luks", []string{
+		"setup luks password on /dev/disk/by-partuuid/5b6a6c0e-9c4b-4a0b-8f2e-0e5d3c7b9a11",
+		"open luks on root",
+	}},
+	{"mount", []string{
+		"mount top-level subvolume on /mnt/top_level_mount",
+		"send installer bootrstrap data - see nr of bytes transferred",
+		"1073741824",
+		"2147483648",
+		"At subvol opinionated_installer_bootstrap",
+		"Create a snapshot of 'opinionated_installer_bootstrap' in './@'",
+		"mount root and home subvolume on /target",
+		"create @swap subvolume for swap file on /mnt/top_level_mount",
+		"make swap file at /target/swap/swapfile",
+		"enable swap file /target/swap/swapfile",
+	}},
+	{"bootstrap", []string{
+		"bind mount dev, proc, sys, run on /target",
+		"mount esp partition on /target/boot/efi",
+		"setup locale, timezone, hostname, root password, kernel command line",
+	}},
+	{"fstab", []string{
+		"setup fstab",
+	}},
+	{"sources", []string{
+		"setup sources list",
+		"enable 32bit",
+	}},
+	{"users", []string{
+		"set up ${USERNAME} user",
+		"Adding user `${USERNAME}' ...",
+	}},
+	{"bootloader", []string{
+		"configuring dracut and kernel command line",
+		"install required packages on /target",
+		"Setting up systemd-boot (257.7-1~bpo13+1) ...",
+		"Installing UKI to /boot/efi/EFI/Linux/",
+	}},
+	{"packages", []string{
+		"install kernel and firmware on /target",
+		"Reading package lists...",
+		"Building dependency tree...",
+		"Setting up linux-image-6.12.38+deb13-amd64 (6.12.38-1) ...",
+		"Setting up firmware-linux (20250410-2) ...",
+		"W: Possible missing firmware /lib/firmware/amdgpu/ip_discovery.bin for module amdgpu",
+	}},
+	{"cleanup", []string{
+		"cleaning up",
+		"umounting all filesystems",
+		"closing luks",
+		"INSTALLATION FINISHED",
+	}},
+}
+
+var simulatedFailures = map[string]string{
+	"partition":  "Failed to open file or determine backing device of ${DISK}: Device or resource busy",
+	"luks":       "Failed to enroll TPM2 key: TPM2 device not found",
+	"mount":      "ERROR: failed to dump stream: No space left on device",
+	"bootstrap":  "E: Couldn't download http://deb.debian.org/debian/dists/trixie/InRelease",
+	"packages":   "Temporary failure resolving 'deb.debian.org'",
+	"bootloader": "E: Sub-process /usr/bin/dpkg returned an error code (1)",
+}
+
+// FakeInstaller writes the output of a realistic installation without touching anything
+type FakeInstaller struct {
+	StepDelay time.Duration
+	// FailAt is the name of the step that fails, empty for a successful installation
+	FailAt   string
+	lock     sync.Mutex
+	current  int
+	exitCode int
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func (f *FakeInstaller) Start(ctx context.Context, parameters map[string]string, output Output) error {
+	ctx, f.cancel = context.WithCancel(ctx)
+	f.current = -1
+	f.exitCode = -1
+	f.done = make(chan struct{})
+	go func() {
+		code := f.run(ctx, parameters, output)
+		f.lock.Lock()
+		f.exitCode = code
+		f.lock.Unlock()
+		close(f.done)
+	}()
+	return nil
+}
+
+func (f *FakeInstaller) run(ctx context.Context, parameters map[string]string, output Output) int {
+	defaults := map[string]string{"DISK": "/dev/vda", "USERNAME": "user"}
+	expand := func(line string) string {
+		return os.Expand(line, func(name string) string {
+			if v := parameters[name]; v != "" {
+				return v
+			}
+			return defaults[name]
+		})
+	}
+	for i, step := range simulatedSteps {
+		f.lock.Lock()
+		f.current = i
+		f.lock.Unlock()
+		output.StepStarted(step.name)
+		for _, line := range step.lines {
+			select {
+			case <-ctx.Done():
+				return -1
+			case <-time.After(f.StepDelay):
+			}
+			_, _ = fmt.Fprintln(output, expand(line))
+			if step.name == f.FailAt {
+				failure, found := simulatedFailures[step.name]
+				if !found {
+					failure = "simulated failure"
+				}
+				_, _ = fmt.Fprintln(output, expand(failure))
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+func (f *FakeInstaller) Cancel() error {
+	f.cancel()
+	return nil
+}
+
+func (f *FakeInstaller) Wait() error {
+	<-f.done
+	if f.exitCode != 0 {
+		return fmt.Errorf("exit status %d", f.exitCode)
+	}
+	return nil
+}
+
+func (f *FakeInstaller) Progress() Progress {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return stepProgress(f.current)
+}
+
+func (f *FakeInstaller) Result() (bool, int) {
+	select {
+	case <-f.done:
+		return true, f.exitCode
+	default:
+		return false, -1
+	}
+}
+
+func (f *FakeInstaller) Command() string {
+	if f.FailAt == "" {
+		return "simulated installer"
+	}
+	return fmt.Sprintf("simulated installer failing at %s", f.FailAt)
+}
@@ -0,0 +1,265 @@
+package installer
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"debug/pe"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// VerificationCheck is one pass/fail item of the verification of the installed system
+type VerificationCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+func Passed(name string, format string, args ...any) VerificationCheck {
+	return VerificationCheck{name, true, fmt.Sprintf(format, args...)}
+}
+
+func Failed(name string, format string, args ...any) VerificationCheck {
+	return VerificationCheck{name, false, fmt.Sprintf(format, args...)}
+}
+
+// VerifyInstalledSystem checks that the installed system in root, with the esp in boot/efi, can boot.
+// resolve finds the device behind a /dev/disk/by-* link.
+func VerifyInstalledSystem(root fs.FS, parameters map[string]string, ids Identifiers, resolve func(string) (string, error)) []VerificationCheck {
+	bootEntries, ukis := findBootEntries(root)
+	expectedCmdline := strings.Fields(RenderKernelCommandLine(parameters, ids))
+	return []VerificationCheck{
+		verifyFstab(root, resolve),
+		verifyBootEntry(bootEntries, ukis),
+		verifyUkiSigned(root, parameters, ukis),
+		verifyKernelCmdline(root, expectedCmdline, bootEntries, ukis),
+		verifyUsers(root, parameters),
+		verifyHostname(root, parameters),
+		verifyTimezone(root, parameters),
+	}
+}
+
+func verifyFstab(root fs.FS, resolve func(string) (string, error)) VerificationCheck {
+	fstab, err := fs.ReadFile(root, "etc/fstab")
+	if err != nil {
+		return Failed("fstab", "%v", err)
+	}
+	links := map[string]string{"UUID": "/dev/disk/by-uuid/", "PARTUUID": "/dev/disk/by-partuuid/"}
+	checked := 0
+	scanner := bufio.NewScanner(bytes.NewReader(fstab))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		kind, id, found := strings.Cut(fields[0], "=")
+		if !found || links[kind] == "" {
+			continue
+		}
+		if _, err = resolve(links[kind] + id); err != nil {
+			return Failed("fstab", "%s for %s does not resolve to a device", fields[0], fields[1])
+		}
+		checked++
+	}
+	if checked == 0 {
+		return Failed("fstab", "no UUID or PARTUUID entries")
+	}
+	return Passed("fstab", "%d entries resolve to devices", checked)
+}
+
+// findBootEntries lists the systemd-boot entries and the unified kernel images on the esp
+func findBootEntries(root fs.FS) (entries []string, ukis []string) {
+	entries, _ = fs.Glob(root, "boot/efi/loader/entries/*.conf")
+	ukis, _ = fs.Glob(root, "boot/efi/EFI/Linux/*.efi")
+	return entries, ukis
+}
+
+func verifyBootEntry(entries []string, ukis []string) VerificationCheck {
+	if len(entries) == 0 && len(ukis) == 0 {
+		return Failed("boot entry", "no unified kernel image in /boot/efi/EFI/Linux and no systemd-boot entry in /boot/efi/loader/entries")
+	}
+	return Passed("boot entry", "%d unified kernel images, %d systemd-boot entries", len(ukis), len(entries))
+}
+
+func verifyUkiSigned(root fs.FS, parameters map[string]string, ukis []string) VerificationCheck {
+	if parameters["ENABLE_MOK_SIGNED_UKI"] != "true" {
+		return Passed("uki signature", "not requested")
+	}
+	if len(ukis) == 0 {
+		return Failed("uki signature", "signed unified kernel images were requested, but there are none")
+	}
+	for _, uki := range ukis {
+		signed, err := peSigned(root, uki)
+		if err != nil {
+			return Failed("uki signature", "%s: %v", uki, err)
+		}
+		if !signed {
+			return Failed("uki signature", "%s is not signed", uki)
+		}
+	}
+	return Passed("uki signature", "%d unified kernel images are signed", len(ukis))
+}
+
+func openPe(root fs.FS, name string) (*pe.File, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return pe.NewFile(bytes.NewReader(data))
+}
+
+// peSigned tells whether the PE image carries an Authenticode signature
+func peSigned(root fs.FS, name string) (bool, error) {
+	f, err := openPe(root, name)
+	if err != nil {
+		return false, err
+	}
+	switch h := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader64:
+		return h.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY].Size > 0, nil
+	case *pe.OptionalHeader32:
+		return h.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY].Size > 0, nil
+	default:
+		return false, fmt.Errorf("no optional header")
+	}
+}
+
+// ukiCmdline reads the kernel command line embedded in the .cmdline section of a unified kernel image
+func ukiCmdline(root fs.FS, name string) (string, error) {
+	f, err := openPe(root, name)
+	if err != nil {
+		return "", err
+	}
+	section := f.Section(".cmdline")
+	if section == nil {
+		return "", fmt.Errorf("no .cmdline section")
+	}
+	data, err := section.Data()
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(data, "\x00\n")), nil
+}
+
+// entryOptions reads the kernel command line from the options lines of a systemd-boot entry
+func entryOptions(root fs.FS, name string) (string, error) {
+	data, err := fs.ReadFile(root, name)
+	if err != nil {
+		return "", err
+	}
+	var options []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, found := strings.CutPrefix(strings.TrimSpace(line), "options"); found {
+			options = append(options, strings.TrimSpace(value))
+		}
+	}
+	return strings.Join(options, " "), nil
+}
+
+func missingWords(cmdline string, expected []string) []string {
+	words := strings.Fields(cmdline)
+	var missing []string
+	for _, w := range expected {
+		if !slices.Contains(words, w) {
+			missing = append(missing, w)
+		}
+	}
+	return missing
+}
+
+func verifyKernelCmdline(root fs.FS, expected []string, entries []string, ukis []string) VerificationCheck {
+	cmdline, err := fs.ReadFile(root, "etc/kernel/cmdline")
+	if err != nil {
+		return Failed("kernel cmdline", "%v", err)
+	}
+	if missing := missingWords(string(cmdline), expected); len(missing) > 0 {
+		return Failed("kernel cmdline", "/etc/kernel/cmdline lacks %s", strings.Join(missing, " "))
+	}
+	for _, uki := range ukis {
+		embedded, err := ukiCmdline(root, uki)
+		if err != nil {
+			return Failed("kernel cmdline", "%s: %v", uki, err)
+		}
+		if missing := missingWords(embedded, expected); len(missing) > 0 {
+			return Failed("kernel cmdline", "%s lacks %s", uki, strings.Join(missing, " "))
+		}
+	}
+	for _, entry := range entries {
+		options, err := entryOptions(root, entry)
+		if err != nil {
+			return Failed("kernel cmdline", "%s: %v", entry, err)
+		}
+		if missing := missingWords(options, expected); len(missing) > 0 {
+			return Failed("kernel cmdline", "%s lacks %s", entry, strings.Join(missing, " "))
+		}
+	}
+	return Passed("kernel cmdline", "%s", strings.TrimSpace(string(cmdline)))
+}
+
+func verifyUsers(root fs.FS, parameters map[string]string) VerificationCheck {
+	passwd, err := fs.ReadFile(root, "etc/passwd")
+	if err != nil {
+		return Failed("users", "%v", err)
+	}
+	users := []string{"root"}
+	if parameters["USERNAME"] != "" {
+		users = append(users, parameters["USERNAME"])
+	}
+	for _, user := range users {
+		if !bytes.HasPrefix(passwd, []byte(user+":")) && !bytes.Contains(passwd, []byte("\n"+user+":")) {
+			return Failed("users", "%s is missing in /etc/passwd", user)
+		}
+	}
+	return Passed("users", "%s exist", strings.Join(users, ", "))
+}
+
+func verifyHostname(root fs.FS, parameters map[string]string) VerificationCheck {
+	data, err := fs.ReadFile(root, "etc/hostname")
+	if err != nil {
+		return Failed("hostname", "%v", err)
+	}
+	hostname := strings.TrimSpace(string(data))
+	if hostname == "" || (parameters["HOSTNAME"] != "" && hostname != parameters["HOSTNAME"]) {
+		return Failed("hostname", "/etc/hostname is %q, want %q", hostname, parameters["HOSTNAME"])
+	}
+	return Passed("hostname", "%s", hostname)
+}
+
+func verifyTimezone(root fs.FS, parameters map[string]string) VerificationCheck {
+	timezone := ""
+	if link, err := fs.ReadLink(root, "etc/localtime"); err == nil {
+		_, timezone, _ = strings.Cut(link, "zoneinfo/")
+	} else if data, err := fs.ReadFile(root, "etc/timezone"); err == nil {
+		timezone = strings.TrimSpace(string(data))
+	}
+	if timezone == "" || (parameters["TIMEZONE"] != "" && timezone != parameters["TIMEZONE"]) {
+		return Failed("timezone", "timezone is %q, want %q", timezone, parameters["TIMEZONE"])
+	}
+	return Passed("timezone", "%s", timezone)
+}
@@ -22,20 +22,30 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"net"
+	"github.com/r0b0/debian-installer/backend/cli"
+	"github.com/r0b0/debian-installer/backend/server"
+	"github.com/r0b0/debian-installer/backend/tui"
 	"os"
+	"time"
 )
 
+// main dispatches the subcommands to the server, tui and cli packages
 func main() {
 	tuiCmd := flag.NewFlagSet("tui", flag.ExitOnError)
 	tuiBaseUrlString := tuiCmd.String("baseUrl", "http://localhost:5000", "base URL of the web service")
+	tuiToken := tuiCmd.String("token", os.Getenv("API_TOKEN"), "API_TOKEN of the back-end, if it has one")
 
 	backendCmd := flag.NewFlagSet("backend", flag.ExitOnError)
 	backendPort := backendCmd.Int("listenPort", 5000, "listen tcp port for the web server")
 	backendStatic := backendCmd.String("staticHtmlFolder", "/var/www/html/opinionated-debian-installer/", "folder with static html content")
+	backendConfig := backendCmd.String("config", "", "configuration file (INI, JSON or YAML) overriding the environment")
+	backendSimulate := backendCmd.Bool("simulate", false, "simulate the hardware and the installer, for demos and development")
+	backendSimulateFixtures := backendCmd.String("simulateFixtures", "", "folder with lsblk.json and login.json fixtures for -simulate, empty for the built-in ones")
+	backendSimulateStepDelay := backendCmd.Duration("simulateStepDelay", 500*time.Millisecond, "delay between lines of the simulated installer output")
+	backendSimulateFailAt := backendCmd.String("simulateFailAt", "", "step of the simulated installer to fail at (partition, luks, mount, bootstrap, fstab, sources, users, bootloader, packages, cleanup)")
 
 	if len(os.Args) < 2 {
-		fmt.Println("expected 'tui' or 'backend' subcommands")
+		fmt.Println("expected 'tui', 'backend', 'install', 'status', 'logs', 'image', 'cancel', 'validate' or 'orchestrate' subcommands")
 		os.Exit(1)
 	}
 
@@ -46,7 +56,7 @@ func main() {
 			flag.Usage()
 			os.Exit(0)
 		}
-		Tui(tuiBaseUrlString)
+		tui.Tui(tuiBaseUrlString, tuiToken)
 		return
 
 	case "backend":
@@ -55,32 +65,22 @@ func main() {
 			flag.Usage()
 			os.Exit(0)
 		}
-		Backend(backendPort, backendStatic)
+		var simulation *server.SimulationOptions
+		if *backendSimulate {
+			simulation = &server.SimulationOptions{
+				Fixtures:  *backendSimulateFixtures,
+				StepDelay: *backendSimulateStepDelay,
+				FailAt:    *backendSimulateFailAt,
+			}
+		}
+		server.Backend(backendPort, backendStatic, backendConfig, simulation)
 		return
 
+	case "install", "status", "logs", "image", "cancel", "validate", "orchestrate":
+		os.Exit(cli.Run(os.Args[1], os.Args[2:], os.Stdout, os.Stderr))
+
 	default:
 		flag.Usage()
 		os.Exit(3)
 	}
 }
-
-func SystemdNotifyReady() error {
-	socketName := os.Getenv("NOTIFY_SOCKET")
-	if socketName == "" {
-		return nil
-	}
-	systemdSocket := &net.UnixAddr{
-		Name: socketName,
-		Net:  "unixgram",
-	}
-	message := "READY=1"
-	conn, err := net.DialUnix(systemdSocket.Net, nil, systemdSocket)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	if _, err = conn.Write([]byte(message)); err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,415 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"golang.org/x/net/websocket"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix  = "/api/v1"
+	apiVersion = "1.0.0"
+)
+
+// apiRoute is an endpoint of the REST API, the OpenAPI document is generated from these
+type apiRoute struct {
+	method  string
+	path    string // below apiPrefix
+	legacy  string // the deprecated unversioned pattern, if the endpoint had one
+	summary string
+	query   []apiParameter
+	// request is a value of the type of the JSON request body, requestTypes are the other accepted content types
+	request      any
+	requestTypes []string
+	// responses are values of the types of the successful JSON response bodies,
+	// responseType is the content type if the body is not JSON
+	responses    []any
+	responseType string
+	errors       []int
+	handler      http.Handler
+}
+
+type apiParameter struct {
+	name        string
+	description string
+}
+
+func (c *BackendContext) apiRoutes() []apiRoute {
+	form := []string{"application/x-www-form-urlencoded", "multipart/form-data"}
+	job := apiParameter{"job", "the id of the job, the latest one by default"}
+	mediaFile := []apiParameter{{"location", "one of the directories listed by /media"}, {"name", "the name of the file in it"}}
+	return []apiRoute{
+		{method: "GET", path: "/login", legacy: "/login",
+			summary:   "Describe the machine and the pending configuration",
+			responses: []any{LoginResponse{}}, errors: []int{500},
+			handler: http.HandlerFunc(c.Login)},
+		{method: "GET", path: "/block_devices", legacy: "/block_devices",
+			summary:   "List the disks, as reported by lsblk",
+			responses: []any{client.BlockDevices{}}, errors: []int{500},
+			handler: http.HandlerFunc(c.GetBlockDevices)},
+		{method: "GET", path: "/preflight",
+			summary:   "Run the pre-flight checks",
+			query:     []apiParameter{{"DISK", "any installation parameter overrides the pending value for the checks"}},
+			responses: []any{PreflightResponse{}}, errors: []int{500},
+			handler: http.HandlerFunc(c.Preflight)},
+		{method: "POST", path: "/install", legacy: "/install",
+			summary: "Start a job installing on the disk, or return its plan with dry_run=1",
+			query:   []apiParameter{{"dry_run", "1 or true to only validate the parameters and return the plan"}},
+			request: client.Model{}, requestTypes: form,
+			responses: []any{ActionResult{}, installer.InstallPlan{}}, errors: []int{400, 409, 500},
+			handler: http.HandlerFunc(c.Install)},
+		{method: "POST", path: "/clear", legacy: "/clear",
+			summary:   "Stop the job, forget a finished one or abort the automatic installation",
+			query:     []apiParameter{job, {"keep_finished", "1 to only stop a running job, a finished one is refused instead of forgotten"}},
+			responses: []any{ActionResult{}}, errors: []int{404, 409, 500},
+			handler: http.HandlerFunc(c.Clear)},
+		{method: "GET", path: "/process_status", legacy: "/process_status",
+			summary:   "Get the state of the job",
+			query:     []apiParameter{job},
+			responses: []any{ProcessStatusResponse{}}, errors: []int{404},
+			handler: http.HandlerFunc(c.ProcessStatus)},
+		{method: "GET", path: "/jobs",
+			summary:   "List the jobs, each installing on its own disk",
+			responses: []any{[]JobStatus{}}, errors: []int{500},
+			handler: http.HandlerFunc(c.GetJobs)},
+		{method: "GET", path: "/download_log", legacy: "/download_log",
+			summary:      "Download the output of the installer",
+			query:        []apiParameter{job},
+			responseType: "text/plain", errors: []int{404},
+			handler: http.HandlerFunc(c.DownloadLog)},
+		{method: "GET", path: "/image",
+			summary:      "Download the disk image of an installation with TARGET_TYPE image",
+			query:        []apiParameter{job},
+			responseType: "application/octet-stream", errors: []int{404},
+			handler: http.HandlerFunc(c.DownloadImage)},
+		{method: "GET", path: "/process_output", legacy: "/process_output",
+			summary:      "Stream the output of the installer over a WebSocket",
+			query:        []apiParameter{job},
+			responseType: "text/plain",
+			handler:      websocket.Handler(c.GetProcessOutput)},
+		{method: "GET", path: "/runs/{id}/report",
+			summary:   "Get the report of an installation",
+			responses: []any{InstallReport{}}, errors: []int{404},
+			handler: http.HandlerFunc(c.GetRunReport)},
+		{method: "GET", path: "/diagnostics",
+			summary:      "Download the diagnostics bundle for a bug report",
+			query:        []apiParameter{job},
+			responseType: "application/gzip", errors: []int{404},
+			handler: http.HandlerFunc(c.GetDiagnostics)},
+		{method: "GET", path: "/config",
+			summary: "Download the pending configuration as a preseed",
+			query: []apiParameter{{"format", "ini (default), json or yaml"},
+				{"secrets", "1 or true to include the passwords"}},
+			responseType: "text/plain", errors: []int{500},
+			handler: http.HandlerFunc(c.GetConfig)},
+		{method: "PUT", path: "/config",
+			summary:      "Replace the pending configuration values present in the preseed",
+			query:        []apiParameter{{"format", "ini (default), json or yaml, or the content type"}},
+			requestTypes: []string{"text/plain", "application/json", "application/yaml"},
+			responses:    []any{ActionResult{}}, errors: []int{400},
+			handler: http.HandlerFunc(c.PutConfig)},
+		{method: "POST", path: "/config/remember",
+			summary:      "Write the non-secret installation parameters into installer.ini",
+			requestTypes: form,
+			responses:    []any{ActionResult{}}, errors: []int{400, 409, 500},
+			handler: http.HandlerFunc(c.RememberConfig)},
+		{method: "GET", path: "/media",
+			summary:   "List where files can be saved: the ESP and the mounted USB sticks",
+			responses: []any{[]string{}}, errors: []int{500},
+			handler: http.HandlerFunc(c.GetMedia)},
+		{method: "GET", path: "/media/file",
+			summary:      "Download a file from the ESP or a USB stick",
+			query:        mediaFile,
+			responseType: "application/octet-stream", errors: []int{400, 404, 500},
+			handler: http.HandlerFunc(c.GetMediaFile)},
+		{method: "PUT", path: "/media/file",
+			summary:      "Save the request body to the ESP or a USB stick",
+			query:        mediaFile,
+			requestTypes: []string{"application/octet-stream"},
+			responses:    []any{ActionResult{}}, errors: []int{400, 500},
+			handler: http.HandlerFunc(c.PutMediaFile)},
+		{method: "GET", path: "/auto_install",
+			summary:   "Get the state of the automatic installation",
+			responses: []any{AutoInstallState{}}, errors: []int{500},
+			handler: http.HandlerFunc(c.GetAutoInstall)},
+		{method: "POST", path: "/auto_install/abort",
+			summary:   "Abort the countdown of the automatic installation",
+			responses: []any{ActionResult{}}, errors: []int{404},
+			handler: http.HandlerFunc(c.AbortAutoInstall)},
+		{method: "GET", path: "/presets",
+			summary:   "List the presets found on removable media",
+			responses: []any{[]Preset{}}, errors: []int{500},
+			handler: http.HandlerFunc(c.GetPresets)},
+		{method: "POST", path: "/presets/{id}/apply",
+			summary:   "Set the parameters of a preset",
+			responses: []any{ActionResult{}}, errors: []int{404},
+			handler: http.HandlerFunc(c.ApplyPreset)},
+		{method: "GET", path: "/steps",
+			summary:   "List the steps of the job, with INSTALLER_RUNNER=native",
+			query:     []apiParameter{job},
+			responses: []any{[]installer.StepResult{}}, errors: []int{404, 409, 500},
+			handler: http.HandlerFunc(c.GetSteps)},
+		{method: "POST", path: "/steps/{name}/retry",
+			summary:   "Run a failed step again",
+			query:     []apiParameter{job},
+			responses: []any{ActionResult{}}, errors: []int{404, 409},
+			handler: http.HandlerFunc(c.RetryStep)},
+		{method: "POST", path: "/steps/{name}/skip",
+			summary:   "Skip a failed step",
+			query:     []apiParameter{job},
+			responses: []any{ActionResult{}}, errors: []int{404, 409},
+			handler: http.HandlerFunc(c.SkipStep)},
+	}
+}
+
+// registerRoutes serves the REST API below apiPrefix, the unversioned aliases and the static files
+func (c *BackendContext) registerRoutes(mux *http.ServeMux, staticPath string) {
+	token := c.parameter("API_TOKEN")
+	routes := c.apiRoutes()
+	for _, route := range routes {
+		handler := route.handler
+		if token != "" {
+			handler = requireToken(token, handler)
+		}
+		mux.Handle(route.method+" "+apiPrefix+route.path, handler)
+		if route.legacy != "" {
+			mux.Handle(route.legacy, deprecated(handler))
+		}
+	}
+	document := openApiDocument(routes)
+	mux.HandleFunc("GET "+apiPrefix+"/openapi.json", func(w http.ResponseWriter, _ *http.Request) {
+		err := writeJson(w, document)
+		if err != nil {
+			slog.Error("failed to write data", "error", err)
+		}
+	})
+	mux.Handle("/", http.FileServer(http.Dir(staticPath)))
+}
+
+// deprecated marks the responses of the unversioned aliases, which are the versioned paths without apiPrefix
+func deprecated(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+apiPrefix+r.URL.Path+`>; rel="successor-version"`)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// requireToken refuses the requests without the token, either as Authorization: Bearer
+// or in the token query parameter for the web browsers, which can not set headers on WebSockets
+func requireToken(token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.URL.Query().Get("token")
+		if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			got = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized, "missing or wrong API token", nil)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+var pathParameter = regexp.MustCompile(`\{(\w+)}`)
+
+// openApiDocument describes the routes as OpenAPI 3, the schemas are generated from the Go types
+func openApiDocument(routes []apiRoute) map[string]any {
+	schemas := make(map[string]any)
+	errorSchema := schemaOf(reflect.TypeFor[ErrorEnvelope](), schemas)
+	paths := make(map[string]any)
+	for _, route := range routes {
+		var parameters []any
+		for _, m := range pathParameter.FindAllStringSubmatch(route.path, -1) {
+			parameters = append(parameters, map[string]any{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, p := range route.query {
+			parameters = append(parameters, map[string]any{
+				"name": p.name, "in": "query", "description": p.description, "schema": map[string]any{"type": "string"}})
+		}
+
+		responses := map[string]any{}
+		content := map[string]any{}
+		if route.responseType != "" {
+			content[route.responseType] = map[string]any{}
+		}
+		if len(route.responses) == 1 {
+			content["application/json"] = map[string]any{"schema": schemaOf(reflect.TypeOf(route.responses[0]), schemas)}
+		} else if len(route.responses) > 1 {
+			var oneOf []any
+			for _, r := range route.responses {
+				oneOf = append(oneOf, schemaOf(reflect.TypeOf(r), schemas))
+			}
+			content["application/json"] = map[string]any{"schema": map[string]any{"oneOf": oneOf}}
+		}
+		responses["200"] = map[string]any{"description": "OK", "content": content}
+		for _, status := range append([]int{http.StatusUnauthorized}, route.errors...) {
+			responses[strconv.Itoa(status)] = map[string]any{
+				"description": http.StatusText(status),
+				"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+			}
+		}
+
+		operation := map[string]any{
+			"operationId": operationId(route),
+			"summary":     route.summary,
+			"responses":   responses,
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if route.request != nil || len(route.requestTypes) > 0 {
+			requestContent := map[string]any{}
+			if route.request != nil {
+				requestContent["application/json"] = map[string]any{"schema": schemaOf(reflect.TypeOf(route.request), schemas)}
+			}
+			for _, t := range route.requestTypes {
+				if _, found := requestContent[t]; !found {
+					requestContent[t] = map[string]any{}
+				}
+			}
+			operation["requestBody"] = map[string]any{"required": true, "content": requestContent}
+		}
+
+		path := apiPrefix + route.path
+		item, found := paths[path].(map[string]any)
+		if !found {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.method)] = operation
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Opinionated Debian Installer",
+			"version": apiVersion,
+			"license": map[string]any{"name": "GPL-3.0-or-later"},
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"token": map[string]any{"type": "http", "scheme": "bearer", "description": "API_TOKEN, when it is set"},
+			},
+		},
+		// the token is optional, it is only required when API_TOKEN is set
+		"security": []any{map[string]any{"token": []any{}}, map[string]any{}},
+	}
+}
+
+// operationId names the operation after the method and the path, e.g. postStepsNameRetry
+func operationId(route apiRoute) string {
+	id := strings.ToLower(route.method)
+	for _, part := range strings.FieldsFunc(route.path, func(r rune) bool { return strings.ContainsRune("/_{}", r) }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+// schemaOf returns the JSON schema of the type, named structs are added to schemas and referenced
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := schemaOf(t.Elem(), schemas)
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, found := schemas[t.Name()]; !found {
+			// the placeholder stops the recursion of self-referencing types
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaOf(field.Type, schemas)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
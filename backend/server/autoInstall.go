@@ -0,0 +1,201 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/r0b0/debian-installer/backend/hardware"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAutoInstallCountdown = 30
+
+// stableDiskPrefixes are the udev links that do not depend on the order the disks were detected in
+var stableDiskPrefixes = []string{"/dev/disk/by-id/", "/dev/disk/by-path/"}
+
+type AutoInstallState struct {
+	Pending   bool   `json:"pending"`
+	Remaining int    `json:"remaining"`
+	Disk      string `json:"disk"`
+	Aborted   bool   `json:"aborted"`
+	Error     string `json:"error,omitempty"`
+}
+
+// autoInstall counts down before an unattended installation, so that anybody can still stop it
+type autoInstall struct {
+	lock  sync.Mutex
+	state AutoInstallState
+	abort chan struct{}
+}
+
+func (a *autoInstall) State() AutoInstallState {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.state
+}
+
+// Abort stops the countdown, it returns false when there is none
+func (a *autoInstall) Abort() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.state.Pending {
+		return false
+	}
+	a.state.Pending = false
+	a.state.Aborted = true
+	close(a.abort)
+	return true
+}
+
+// resolveStableDisk finds the disk for an unattended installation, which must be given by its serial number
+// or a stable link. It returns the kernel name of the disk.
+func resolveStableDisk(hw hardware.Hardware, disk string, serial string) (string, error) {
+	lsblk, err := hw.BlockDevices()
+	if err != nil {
+		return "", err
+	}
+	var devices struct {
+		Blockdevices []struct {
+			Path   string `json:"path"`
+			Serial string `json:"serial"`
+			Type   string `json:"type"`
+		} `json:"blockdevices"`
+	}
+	if err = json.Unmarshal(lsblk, &devices); err != nil {
+		return "", err
+	}
+
+	resolved := ""
+	if disk != "" {
+		stable := false
+		for _, prefix := range stableDiskPrefixes {
+			stable = stable || strings.HasPrefix(disk, prefix)
+		}
+		if !stable && serial == "" {
+			return "", fmt.Errorf("DISK %s may point to another drive after a reboot, use a link in /dev/disk/by-id or DISK_SERIAL", disk)
+		}
+		resolved, err = hw.ResolveDevice(disk)
+		if err != nil {
+			return "", fmt.Errorf("DISK %s is not present: %w", disk, err)
+		}
+	}
+	for _, d := range devices.Blockdevices {
+		if d.Type != "disk" {
+			continue
+		}
+		if serial != "" && d.Serial == serial {
+			if resolved != "" && resolved != d.Path {
+				return "", fmt.Errorf("DISK %s is %s, but the disk with serial %s is %s", disk, resolved, serial, d.Path)
+			}
+			return d.Path, nil
+		}
+		if serial == "" && d.Path == resolved {
+			return d.Path, nil
+		}
+	}
+	if serial != "" {
+		return "", fmt.Errorf("no disk with serial %s is present", serial)
+	}
+	return "", fmt.Errorf("DISK %s is %s, which is not a disk", disk, resolved)
+}
+
+// startAutoInstall checks the disk and counts down before starting the unattended installation
+func (c *BackendContext) startAutoInstall() {
+	parameters := c.parameters()
+	disk := parameters["DISK"]
+	// a disk image file does not move to another drive after a reboot
+	if parameters["TARGET_TYPE"] != config.TargetImage {
+		var err error
+		disk, err = resolveStableDisk(c.hardware, disk, parameters["DISK_SERIAL"])
+		if err != nil {
+			slog.Error("refusing the automatic installation", "error", err)
+			_, _ = fmt.Fprintf(c, "automatic installation refused: %v\n", err)
+			c.autoInstall.lock.Lock()
+			c.autoInstall.state.Error = err.Error()
+			c.autoInstall.lock.Unlock()
+			return
+		}
+		// the resolved disk keeps the source of the configured one
+		c.parametersLock.Lock()
+		c.runningParameters["DISK"] = disk
+		c.parametersLock.Unlock()
+		parameters["DISK"] = disk
+	}
+
+	countdown := intParameter(parameters, "AUTO_INSTALL_COUNTDOWN", defaultAutoInstallCountdown)
+	c.autoInstall.lock.Lock()
+	c.autoInstall.state = AutoInstallState{Pending: true, Remaining: countdown, Disk: disk}
+	c.autoInstall.abort = make(chan struct{})
+	abort := c.autoInstall.abort
+	c.autoInstall.lock.Unlock()
+
+	for remaining := countdown; remaining > 0; remaining-- {
+		c.autoInstall.lock.Lock()
+		c.autoInstall.state.Remaining = remaining
+		c.autoInstall.lock.Unlock()
+		if remaining == countdown || remaining%10 == 0 || remaining <= 5 {
+			slog.Warn("automatic installation is about to start", "disk", disk, "seconds", remaining)
+			_, _ = fmt.Fprintf(c, "automatic installation OVERWRITING %s starts in %d seconds, press any key to abort\n", disk, remaining)
+		}
+		select {
+		case <-abort:
+			slog.Info("automatic installation aborted")
+			_, _ = fmt.Fprintf(c, "automatic installation aborted\n")
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	c.autoInstall.lock.Lock()
+	aborted := c.autoInstall.state.Aborted
+	c.autoInstall.state.Pending = false
+	c.autoInstall.state.Remaining = 0
+	c.autoInstall.lock.Unlock()
+	if aborted {
+		return
+	}
+	slog.Info("automatically starting the installation", "disk", disk)
+	// the values that were checked and shown during the countdown, not the ones changed since
+	if _, err := c.startJob(parameters); err != nil {
+		slog.Error("the automatic installation did not start", "error", err)
+	}
+}
+
+func (c *BackendContext) GetAutoInstall(w http.ResponseWriter, _ *http.Request) {
+	err := writeJson(w, c.autoInstall.State())
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		return
+	}
+}
+
+func (c *BackendContext) AbortAutoInstall(w http.ResponseWriter, _ *http.Request) {
+	if !c.autoInstall.Abort() {
+		writeError(w, http.StatusNotFound, ErrNotFound, "no automatic installation pending", nil)
+		return
+	}
+	writeAction(w, "ABORTED")
+}
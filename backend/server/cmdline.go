@@ -0,0 +1,63 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"github.com/r0b0/debian-installer/backend/config"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// preseedRequestTimeout limits one attempt to download the preseed or its signature
+	preseedRequestTimeout = 10 * time.Second
+	// preseedFetchTimeout limits the download with all its retries, the back-end is not ready until it ends
+	preseedFetchTimeout = 45 * time.Second
+)
+
+// loadKernelCmdline applies the preseed from the url and then the overrides of the kernel command line.
+// A preseed that can not be fetched in preseedFetchTimeout is reported in the configuration errors.
+func (c *BackendContext) loadKernelCmdline(ctx context.Context, client *http.Client, cmdline string) {
+	k := config.ParseKernelCmdline(cmdline)
+	c.configErrors = append(c.configErrors, k.Errors...)
+	if k.PreseedUrl != "" {
+		// the url may carry a token, it is never logged or shown as it is
+		name := config.RedactUrl(k.PreseedUrl)
+		slog.Info("fetching the preseed", "url", name)
+		fetchCtx, cancel := context.WithTimeout(ctx, preseedFetchTimeout)
+		data, err := config.FetchPreseed(fetchCtx, client, k)
+		cancel()
+		if err != nil {
+			slog.Error("failed to load the preseed", "error", err)
+			c.configErrors = append(c.configErrors, config.ConfigError{File: name, Message: err.Error()})
+		} else {
+			u, _ := url.Parse(k.PreseedUrl)
+			parameters, configErrors := config.Parse(bytes.NewReader(data), name, config.FormatOf(u.Path))
+			c.configErrors = append(c.configErrors, configErrors...)
+			c.setParameters(parameters, config.SourceUrl)
+		}
+	}
+	overrides, configErrors := config.ValidateEntries(k.Overrides, config.KernelCmdlinePath)
+	c.configErrors = append(c.configErrors, configErrors...)
+	c.setParameters(overrides, config.SourceCmdline)
+}
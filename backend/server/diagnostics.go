@@ -0,0 +1,176 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const diagnosticsPrefix = "odi-diagnostics"
+
+// redactOutput replaces the values of the secret parameters in the installer output
+func redactOutput(output []byte, parameters map[string]string) []byte {
+	for k, v := range parameters {
+		if config.IsSecret(k) && v != "" {
+			output = bytes.ReplaceAll(output, []byte(v), []byte("***"))
+		}
+	}
+	return output
+}
+
+// diagnosticsFiles collects everything for a bug report about the job, by the file name in the bundle.
+// Without a job, it collects the messages of the back-end. What could not be collected is listed in errors.txt.
+func (c *BackendContext) diagnosticsFiles(j *job) map[string][]byte {
+	files, errs := c.hardware.Diagnostics()
+	addJson := func(name string, v any) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		files[name] = data
+	}
+
+	output, parameters, workDir := c.messages, c.parameters(), "."
+	if j != nil {
+		output, parameters, workDir = j.output, j.parameters, j.resources.WorkDir
+	}
+	files["run.log"] = redactOutput(output.Bytes(), parameters)
+	addJson("parameters.json", config.Redact(parameters))
+	if lsblk, err := c.hardware.BlockDevices(); err != nil {
+		errs = append(errs, fmt.Errorf("lsblk: %w", err))
+	} else {
+		files["lsblk.json"] = lsblk
+	}
+
+	var login struct {
+		Hostname  string `json:"hostname"`
+		HasEfi    bool   `json:"has_efi"`
+		HasNvidia bool   `json:"has_nvidia"`
+		SBState   string `json:"sb_state"`
+		HasTpm    bool   `json:"has_tpm"`
+		MemTotal  int64  `json:"mem_total"`
+	}
+	var err error
+	if login.Hostname, err = c.hardware.Hostname(); err != nil {
+		errs = append(errs, fmt.Errorf("hostname: %w", err))
+	}
+	if login.HasEfi, err = c.hardware.HasEfi(); err != nil {
+		errs = append(errs, fmt.Errorf("efi: %w", err))
+	}
+	if login.SBState, err = c.hardware.SecureBootState(); err != nil {
+		errs = append(errs, fmt.Errorf("secure boot state: %w", err))
+	}
+	if login.MemTotal, err = c.hardware.MemTotal(); err != nil {
+		errs = append(errs, fmt.Errorf("memory: %w", err))
+	}
+	login.HasNvidia = c.hardware.HasNvidia()
+	login.HasTpm = c.hardware.HasTpm()
+	addJson("login.json", login)
+
+	if j != nil {
+		addJson("report.json", j.report.snapshot(output.Bytes()))
+	}
+
+	// the installer writes the partition definitions into its working directory
+	repart := os.DirFS(filepath.Join(workDir, "repart.d"))
+	names, err := fs.Glob(repart, "*.conf")
+	if err != nil || len(names) == 0 {
+		errs = append(errs, fmt.Errorf("repart.d: no partition definitions in the working directory"))
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(repart, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("repart.d: %w", err))
+			continue
+		}
+		files[path.Join("repart.d", name)] = data
+	}
+
+	if len(errs) > 0 {
+		var b strings.Builder
+		for _, err := range errs {
+			b.WriteString(err.Error() + "\n")
+		}
+		files["errors.txt"] = []byte(b.String())
+	}
+	return files
+}
+
+// writeDiagnostics writes the files as a tar.gz with all of them in the directory dir
+func writeDiagnostics(w io.Writer, dir string, files map[string][]byte, modTime time.Time) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    path.Join(dir, name),
+			Mode:    0600,
+			Size:    int64(len(files[name])),
+			ModTime: modTime,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (c *BackendContext) GetDiagnostics(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("job")
+	j := c.findJob(id)
+	if j == nil && id != "" {
+		writeError(w, http.StatusNotFound, ErrNotFound, "no such job", nil)
+		return
+	}
+	now := time.Now()
+	dir := diagnosticsPrefix + "-" + now.UTC().Format("20060102-150405")
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", dir+".tar.gz"))
+	err := writeDiagnostics(w, dir, c.diagnosticsFiles(j), now)
+	if err != nil {
+		slog.Error("failed to write the diagnostics", "error", err)
+		return
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode tells the clients of the REST API what went wrong, the message is meant for people
+type ErrorCode string
+
+const (
+	ErrInternal       ErrorCode = "internal"
+	ErrBadRequest     ErrorCode = "bad_request"
+	ErrInvalidConfig  ErrorCode = "invalid_config"
+	ErrAlreadyRunning ErrorCode = "already_running"
+	ErrNotRunning     ErrorCode = "not_running"
+	ErrNotFound       ErrorCode = "not_found"
+	ErrNotSupported   ErrorCode = "not_supported"
+	ErrConflict       ErrorCode = "conflict"
+	ErrUnauthorized   ErrorCode = "unauthorized"
+)
+
+// ApiError describes why a REST API request failed
+type ApiError struct {
+	// Status is the HTTP status of the response, it is not part of the body
+	Status  int             `json:"-"`
+	Code    ErrorCode       `json:"code"`
+	Message string          `json:"message"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// ErrorEnvelope is the body of the failed responses
+type ErrorEnvelope struct {
+	Error ApiError `json:"error"`
+}
+
+func (e *ApiError) Error() string {
+	return e.Message
+}
+
+// writeError sends the error envelope, details is any JSON value explaining the error further
+func writeError(w http.ResponseWriter, status int, code ErrorCode, message string, details any) {
+	e := ApiError{Code: code, Message: message}
+	if raw, ok := details.(json.RawMessage); ok {
+		e.Details = raw
+	} else if details != nil {
+		var err error
+		e.Details, err = json.Marshal(details)
+		if err != nil {
+			slog.Error("failed to encode the error details", "error", err)
+			e.Details = nil
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(ErrorEnvelope{e})
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+	}
+}
+
+// requestParameters reads the installation parameters from a form or a JSON object of strings, numbers and booleans
+func requestParameters(r *http.Request) (map[string]string, *ApiError) {
+	parameters := make(map[string]string)
+	contentType := r.Header.Get("Content-Type")
+	var err error
+	switch {
+	case strings.HasPrefix(contentType, "application/json"):
+		var values map[string]any
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err = decoder.Decode(&values); err != nil {
+			return nil, &ApiError{Code: ErrBadRequest, Message: fmt.Sprintf("failed to parse JSON: %v", err)}
+		}
+		var invalid []string
+		for k, v := range values {
+			switch v := v.(type) {
+			case string:
+				parameters[k] = v
+			case json.Number:
+				parameters[k] = v.String()
+			case bool:
+				parameters[k] = fmt.Sprint(v)
+			case nil:
+				parameters[k] = ""
+			default:
+				invalid = append(invalid, k)
+			}
+		}
+		if len(invalid) > 0 {
+			details, _ := json.Marshal(invalid)
+			return nil, &ApiError{Code: ErrBadRequest, Message: "values must be strings, numbers or booleans", Details: details}
+		}
+		return parameters, nil
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		err = r.ParseForm()
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		err = r.ParseMultipartForm(1024 * 1024)
+	default:
+		return nil, &ApiError{Code: ErrBadRequest, Message: fmt.Sprintf("unsupported content type %q", contentType)}
+	}
+	if err != nil {
+		return nil, &ApiError{Code: ErrBadRequest, Message: fmt.Sprintf("failed to parse form: %v", err)}
+	}
+	for k, v := range r.Form {
+		parameters[k] = v[0]
+	}
+	return parameters, nil
+}
@@ -0,0 +1,479 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LoginResponse describes the machine and the pending configuration to the front-ends
+type LoginResponse struct {
+	Hostname  string            `json:"hostname"`
+	HasEfi    bool              `json:"has_efi"`
+	HasNvidia bool              `json:"has_nvidia"`
+	SBState   string            `json:"sb_state"`
+	Running   bool              `json:"running"`
+	Environ   map[string]string `json:"environ"`
+	// ConfigErrors are the problems found in the configuration file, the invalid entries were ignored
+	ConfigErrors []config.ConfigError `json:"config_errors"`
+	// ParameterSources tells where the values of the known parameters came from
+	ParameterSources map[string]config.ParameterSource `json:"parameter_sources"`
+	AutoInstall      AutoInstallState                  `json:"auto_install"`
+}
+
+func (c *BackendContext) Login(w http.ResponseWriter, _ *http.Request) {
+	data := LoginResponse{}
+	var err error
+	data.Hostname, err = c.hardware.Hostname()
+	if err != nil {
+		slog.Error("failed to detect hostname", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to detect hostname", nil)
+		return
+	}
+	data.HasEfi, err = c.hardware.HasEfi()
+	if err != nil {
+		slog.Error("failed to detect efi", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to detect efi", nil)
+		return
+	}
+	data.HasNvidia = c.hardware.HasNvidia()
+	data.SBState, err = c.hardware.SecureBootState()
+	if err != nil {
+		slog.Error("failed to detect secure boot state", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to detect secure boot state", nil)
+		return
+	}
+	data.Running = c.hasJobs()
+	data.Environ = c.parameters()
+	data.ConfigErrors = c.configErrors
+	data.AutoInstall = c.autoInstall.State()
+	data.ParameterSources = make(map[string]config.ParameterSource)
+	for k, source := range c.sources() {
+		if _, found := config.FindParameter(k); found {
+			data.ParameterSources[k] = source
+		}
+	}
+	err = writeJson(w, data)
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		return
+	}
+}
+
+func (c *BackendContext) GetBlockDevices(w http.ResponseWriter, _ *http.Request) {
+	out, err := c.hardware.BlockDevices()
+	if err != nil {
+		slog.Error("failed to execute lsblk", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to execute lsblk", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(out)
+	if err != nil {
+		slog.Error("failed to send output", "error", err)
+		return
+	}
+}
+
+// Install starts a job with the parameters of a form or a JSON object matching the TUI Model.
+// It refuses the disk of another job, which must be cleared first. With dry_run=1 it only returns the plan.
+func (c *BackendContext) Install(w http.ResponseWriter, r *http.Request) {
+	dryRun := isDryRun(r)
+	values, apiErr := requestParameters(r)
+	if apiErr != nil {
+		slog.Error("failed to parse the request", "error", apiErr.Message)
+		writeError(w, http.StatusBadRequest, apiErr.Code, apiErr.Message, apiErr.Details)
+		return
+	}
+	if configErrors := backendSettingErrors(values); len(configErrors) > 0 {
+		slog.Error("back-end settings in the request", "errors", configErrors)
+		writeError(w, http.StatusBadRequest, ErrInvalidConfig, "the settings of the back-end can not be changed by a request", configErrors)
+		return
+	}
+	if dryRun {
+		parameters := c.parameters()
+		maps.Copy(parameters, values)
+		slog.Debug("Dry run requested")
+		err := writeJson(w, installer.MakePlan(parameters))
+		if err != nil {
+			slog.Error("failed to write data", "error", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		}
+		return
+	}
+	slog.Debug("Install button pressed")
+	for k, v := range values {
+		slog.Debug(" form value", "key", k, "value", v)
+	}
+	parameters := c.parameters()
+	maps.Copy(parameters, values)
+	// refused here, installer.sh would only fail halfway through
+	if issues := config.Validate(parameters); len(issues) > 0 {
+		configErrors := make([]config.ConfigError, 0, len(issues))
+		for _, issue := range issues {
+			configErrors = append(configErrors, config.ConfigError{File: "install", Key: issue.Key, Message: issue.Message})
+		}
+		slog.Error("invalid installation parameters", "errors", configErrors)
+		writeError(w, http.StatusBadRequest, ErrInvalidConfig, "invalid installation parameters", configErrors)
+		return
+	}
+	j, err := c.startJob(parameters)
+	if err != nil {
+		writeStartError(w, err)
+		return
+	}
+	c.setParameters(values, config.SourceUser)
+	err = writeJson(w, ActionResult{Status: "RUNNING", RunId: j.id})
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+	}
+}
+
+// backendSettingErrors refuses the settings of the back-end itself in a request. They choose the programs
+// the back-end runs as root and where it sends the installation, only its configuration file and environment set them.
+func backendSettingErrors(values map[string]string) []config.ConfigError {
+	var configErrors []config.ConfigError
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		if config.IsBackendSetting(k) {
+			configErrors = append(configErrors, config.ConfigError{File: "request", Key: k,
+				Message: "is a setting of the back-end, set it in its configuration file or environment"})
+		}
+	}
+	return configErrors
+}
+
+// ActionResult is the response of the endpoints that change the state of the back-end
+type ActionResult struct {
+	Status string `json:"status"`
+	RunId  string `json:"run_id,omitempty"`
+}
+
+// writeAction sends the new state after a successful action
+func writeAction(w http.ResponseWriter, status string) {
+	err := writeJson(w, ActionResult{Status: status})
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+	}
+}
+
+func isDryRun(r *http.Request) bool {
+	return queryFlag(r, "dry_run")
+}
+
+func queryFlag(r *http.Request, name string) bool {
+	value := r.URL.Query().Get(name)
+	return value == "1" || value == "true"
+}
+
+// ProcessStatusResponse is the state of a job
+type ProcessStatusResponse struct {
+	Status     string             `json:"status"`
+	Output     string             `json:"output"`
+	ReturnCode int                `json:"return_code"`
+	Command    string             `json:"command"`
+	Progress   installer.Progress `json:"progress"`
+	RunId      string             `json:"run_id"`
+	// Verification lists the checks of the installed system, with VERIFY_INSTALL=true
+	Verification []installer.VerificationCheck `json:"verification,omitempty"`
+	// Error explains a failed installation
+	Error *installer.InstallError `json:"error,omitempty"`
+}
+
+// ProcessStatus reports the job in the job query parameter, or the latest one
+func (c *BackendContext) ProcessStatus(w http.ResponseWriter, r *http.Request) {
+	j, ok := c.requestJob(w, r)
+	if !ok {
+		return
+	}
+	output := j.output.Bytes()
+	s := ProcessStatusResponse{
+		Output:   string(output),
+		Command:  "",
+		Progress: j.installer.Progress(),
+		RunId:    j.id,
+	}
+	s.Status, s.ReturnCode = j.status()
+	if finished, _ := j.installer.Result(); finished {
+		s.Command = j.installer.Command()
+		if s.ReturnCode != 0 {
+			explanation := installer.ExplainFailure(s.ReturnCode, s.Progress, output)
+			s.Error = &explanation
+		}
+	}
+	s.Verification, _ = j.report.verification()
+
+	err := writeJson(w, s)
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		return
+	}
+}
+
+func (c *BackendContext) DownloadLog(w http.ResponseWriter, r *http.Request) {
+	output := c.messages
+	id := r.URL.Query().Get("job")
+	if j := c.findJob(id); j != nil {
+		output = j.output
+	} else if id != "" {
+		writeError(w, http.StatusNotFound, ErrNotFound, "no such job", nil)
+		return
+	}
+	w.Header().Add("Content-Type", "text/plain;charset=UTF-8")
+	w.Header().Add("Content-Disposition", "attachment;filename=installer.log")
+	_, err := w.Write(output.Bytes())
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		return
+	}
+}
+
+// DownloadImage sends the disk image of the job once it finished successfully, it stays where DISK points to
+func (c *BackendContext) DownloadImage(w http.ResponseWriter, r *http.Request) {
+	j, ok := c.requestJob(w, r)
+	if !ok {
+		return
+	}
+	path, ok := j.image()
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrNotFound, "the job has no finished disk image", nil)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		slog.Error("failed to open the disk image", "path", path, "error", err)
+		writeError(w, http.StatusNotFound, ErrNotFound, "the disk image is gone", nil)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		slog.Error("failed to read the disk image", "path", path, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to read the disk image", nil)
+		return
+	}
+	w.Header().Add("Content-Type", "application/octet-stream")
+	w.Header().Add("Content-Disposition", "attachment;filename="+filepath.Base(path))
+	// ServeContent supports ranges, so that an interrupted download of a large image can be resumed
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// Clear stops the running job, forgets a finished one and frees its resources,
+// or aborts a pending automatic installation
+func (c *BackendContext) Clear(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("job")
+	j := c.findJob(id)
+	if j == nil {
+		if id != "" {
+			writeError(w, http.StatusNotFound, ErrNotFound, "no such job", nil)
+			return
+		}
+		if c.autoInstall.Abort() {
+			writeAction(w, "ABORTED")
+			return
+		}
+		writeError(w, http.StatusNotFound, ErrNotRunning, "no running process", nil)
+		return
+	}
+	switch status, _ := j.status(); status {
+	case "FINISHED":
+		if queryFlag(r, "keep_finished") {
+			writeError(w, http.StatusConflict, ErrNotRunning, fmt.Sprintf("job %s is not running, it finished already", j.id), nil)
+			return
+		}
+		c.removeJob(j)
+		j.output.reset()
+		writeAction(w, "CLEARED")
+		return
+	case "VERIFYING":
+		// the installation is over, its result is not known yet
+		writeError(w, http.StatusConflict, ErrConflict, fmt.Sprintf("job %s is verifying the installed system, clear it when it is finished", j.id), nil)
+		return
+	}
+	err := j.installer.Cancel()
+	if err != nil {
+		slog.Error("failed to stop the process", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to stop the process", nil)
+		return
+	}
+	writeAction(w, "STOPPING")
+}
+
+// stepController returns the installer of the job if it allows controlling its steps
+func (c *BackendContext) stepController(w http.ResponseWriter, r *http.Request) (installer.StepController, bool) {
+	j, ok := c.requestJob(w, r)
+	if !ok {
+		return nil, false
+	}
+	controller, ok := j.installer.(installer.StepController)
+	if !ok {
+		writeError(w, http.StatusConflict, ErrNotSupported, "the installer does not support controlling steps", nil)
+		return nil, false
+	}
+	return controller, true
+}
+
+func (c *BackendContext) GetSteps(w http.ResponseWriter, r *http.Request) {
+	controller, ok := c.stepController(w, r)
+	if !ok {
+		return
+	}
+	err := writeJson(w, controller.Steps())
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		return
+	}
+}
+
+func (c *BackendContext) RetryStep(w http.ResponseWriter, r *http.Request) {
+	c.controlStep(w, r, installer.StepController.Retry)
+}
+
+func (c *BackendContext) SkipStep(w http.ResponseWriter, r *http.Request) {
+	c.controlStep(w, r, installer.StepController.Skip)
+}
+
+func (c *BackendContext) controlStep(w http.ResponseWriter, r *http.Request, action func(installer.StepController, string) error) {
+	controller, ok := c.stepController(w, r)
+	if !ok {
+		return
+	}
+	err := action(controller, r.PathValue("name"))
+	if errors.Is(err, installer.ErrStepNotFound) {
+		writeError(w, http.StatusNotFound, ErrNotFound, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, ErrConflict, err.Error(), nil)
+		return
+	}
+	writeAction(w, "OK")
+}
+
+// requestConfigFormat reads the format from the format query parameter or the content type, INI by default
+func requestConfigFormat(r *http.Request) config.ConfigFormat {
+	switch format := r.URL.Query().Get("format"); format {
+	case "json":
+		return config.ConfigJson
+	case "yaml", "yml":
+		return config.ConfigYaml
+	case "ini":
+		return config.ConfigIni
+	}
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "application/json"):
+		return config.ConfigJson
+	case strings.HasPrefix(contentType, "application/yaml"), strings.HasPrefix(contentType, "application/x-yaml"):
+		return config.ConfigYaml
+	default:
+		return config.ConfigIni
+	}
+}
+
+var configContentTypes = map[config.ConfigFormat]string{
+	config.ConfigIni:  "text/plain;charset=UTF-8",
+	config.ConfigJson: "application/json",
+	config.ConfigYaml: "application/yaml",
+}
+
+func (c *BackendContext) GetConfig(w http.ResponseWriter, r *http.Request) {
+	format := requestConfigFormat(r)
+	secrets := r.URL.Query().Get("secrets")
+	out, err := config.Render(c.parameters(), format, secrets == "1" || secrets == "true")
+	if err != nil {
+		slog.Error("failed to render the configuration", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to render the configuration", nil)
+		return
+	}
+	w.Header().Set("Content-Type", configContentTypes[format])
+	w.Header().Set("Content-Disposition", "attachment;filename=odi-preseed."+string(format))
+	_, err = w.Write(out)
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		return
+	}
+}
+
+// PutConfig replaces the pending configuration values present in the request, secrets left out keep their values.
+// The running jobs keep the values they were started with.
+func (c *BackendContext) PutConfig(w http.ResponseWriter, r *http.Request) {
+	parameters, configErrors := config.Parse(r.Body, "request", requestConfigFormat(r))
+	if len(configErrors) > 0 {
+		slog.Error("invalid configuration", "errors", configErrors)
+		writeError(w, http.StatusBadRequest, ErrInvalidConfig, "invalid configuration", configErrors)
+		return
+	}
+	if configErrors = backendSettingErrors(parameters); len(configErrors) > 0 {
+		slog.Error("back-end settings in the request", "errors", configErrors)
+		writeError(w, http.StatusBadRequest, ErrInvalidConfig, "the settings of the back-end can not be changed by a request", configErrors)
+		return
+	}
+	c.setParameters(parameters, config.SourceUser)
+	slog.Info("configuration updated", "parameters", config.Redact(parameters))
+	writeAction(w, "OK")
+}
+
+// RememberConfig writes the installation parameters from the form into the configuration file,
+// so that the installer boots with them next time. Secrets and the back-end settings are never written.
+func (c *BackendContext) RememberConfig(w http.ResponseWriter, r *http.Request) {
+	if c.configPath == "" || config.FormatOf(c.configPath) != config.ConfigIni {
+		writeError(w, http.StatusConflict, ErrNotSupported, "the back-end was not started with an INI configuration file", nil)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		slog.Error("failed to parse form", "error", err)
+		writeError(w, http.StatusBadRequest, ErrBadRequest, "failed to parse form", nil)
+		return
+	}
+	values := make(map[string]string)
+	for k, v := range r.Form {
+		p, found := config.FindParameter(k)
+		if !found || p.Secret || p.Backend {
+			continue
+		}
+		if err = config.CheckValue(k, v[0]); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidConfig, fmt.Sprintf("%s %v", k, err),
+				[]config.ConfigError{{File: "request", Key: k, Message: err.Error()}})
+			return
+		}
+		values[k] = v[0]
+	}
+	err = config.UpdateIniFile(c.configPath, values)
+	if err != nil {
+		slog.Error("failed to update the configuration file", "path", c.configPath, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to update the configuration file", nil)
+		return
+	}
+	slog.Info("configuration remembered", "path", c.configPath, "parameters", values)
+	writeAction(w, "OK")
+}
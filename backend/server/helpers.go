@@ -1,4 +1,4 @@
-package main
+package server
 
 /*
 Opinionated Debian Installer
@@ -21,7 +21,6 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 import (
 	"encoding/json"
 	"net/http"
-	"os/exec"
 )
 
 func writeJson(w http.ResponseWriter, data any) error {
@@ -33,15 +32,3 @@ func writeJson(w http.ResponseWriter, data any) error {
 	_, err = w.Write(jData)
 	return err
 }
-
-func runAndGiveStdout(command ...string) ([]byte, error) {
-	path, err := exec.LookPath(command[0])
-	if err != nil {
-		return nil, err
-	}
-	out, err := exec.Command(path, command[1:]...).Output()
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
@@ -0,0 +1,353 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// job is one installation, it holds its resources until it is cleared
+type job struct {
+	id            string
+	slot          int
+	resources     installer.Resources
+	parameters    map[string]string
+	installer     installer.Installer
+	started       time.Time
+	notifications *notifications
+	report        *runReport
+	output        *outputLog
+	backend       *BackendContext
+}
+
+// JobStatus summarizes one of the installations of the back-end
+type JobStatus struct {
+	Id         string              `json:"id"`
+	Disk       string              `json:"disk"`
+	Hostname   string              `json:"hostname"`
+	Status     string              `json:"status"`
+	ReturnCode int                 `json:"return_code"`
+	Progress   installer.Progress  `json:"progress"`
+	Started    time.Time           `json:"started"`
+	Resources  installer.Resources `json:"resources"`
+	// Image is the finished disk image of an installation with TARGET_TYPE image
+	Image string `json:"image,omitempty"`
+}
+
+// startJob reserves the resources for an installation on the disk in the parameters and starts it.
+// The error is an *ApiError when the resources are in use.
+func (c *BackendContext) startJob(parameters map[string]string) (*job, error) {
+	j, err := c.reserveJob(parameters)
+	if err != nil {
+		return nil, err
+	}
+	// the lock is not held while the runner starts, the remote runner waits for another back-end
+	err = c.runJob(j)
+	c.jobsLock.Lock()
+	c.starting = slices.DeleteFunc(c.starting, func(other *job) bool { return other == j })
+	if err == nil {
+		c.jobs = append(c.jobs, j)
+	}
+	c.jobsLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	go j.waitForInstallerFinished()
+	return j, nil
+}
+
+// reserveJob picks the slot and the resources for the installation and holds them while it starts
+func (c *BackendContext) reserveJob(parameters map[string]string) (*job, error) {
+	c.jobsLock.Lock()
+	defer c.jobsLock.Unlock()
+
+	disk := parameters["DISK"]
+	if resolved, err := c.hardware.ResolveDevice(disk); err == nil {
+		// the same disk may be selected by its /dev/disk/by-id link and by its name
+		disk = resolved
+	}
+	reserved := append(slices.Clone(c.jobs), c.starting...)
+	slot := 0
+	for slices.ContainsFunc(reserved, func(j *job) bool { return j.slot == slot }) {
+		slot++
+	}
+	resources := installer.SlotResources(disk, slot)
+	// the commands of the installer run in the working directory, the paths passed to them must not be relative to it
+	if workDir, err := filepath.Abs(resources.WorkDir); err == nil {
+		resources.WorkDir = workDir
+	}
+	for _, other := range reserved {
+		conflicts := resources.Conflicts(other.resources)
+		if len(conflicts) == 0 {
+			continue
+		}
+		if other.resources.Disk == disk {
+			return nil, &ApiError{Status: http.StatusConflict, Code: ErrAlreadyRunning,
+				Message: fmt.Sprintf("already running on %s in job %s", disk, other.id)}
+		}
+		return nil, &ApiError{Status: http.StatusConflict, Code: ErrConflict,
+			Message: fmt.Sprintf("job %s uses the same %s", other.id, strings.Join(conflicts, ", "))}
+	}
+
+	// the first job continues the messages of the back-end, so that the clients which followed them,
+	// like the web front-end, see its output. The jobs running next to it have their own.
+	output := c.messages
+	if slices.ContainsFunc(reserved, func(j *job) bool { return j.output == c.messages }) {
+		output = newOutputLog()
+	}
+	j := &job{
+		id:         uuid.New().String(),
+		slot:       slot,
+		resources:  resources,
+		parameters: parameters,
+		started:    time.Now(),
+		output:     output,
+		backend:    c,
+	}
+	c.starting = append(c.starting, j)
+	return j, nil
+}
+
+// runJob creates the installer of the reserved job and starts it
+func (c *BackendContext) runJob(j *job) error {
+	runner, err := c.newInstaller(j.parameters, j.resources)
+	if err != nil {
+		slog.Error("failed to create the installer", "error", err)
+		return fmt.Errorf("failed to create the installer: %w", err)
+	}
+	if c.simulation == nil {
+		if err = os.MkdirAll(j.resources.WorkDir, 0700); err != nil {
+			return fmt.Errorf("failed to create the working directory: %w", err)
+		}
+	}
+	j.installer = runner
+	j.notifications = startNotifications(c.ctx, newNotifiers(j.parameters),
+		intParameter(j.parameters, "NOTIFY_RETRIES", defaultNotifyRetries))
+	j.report = newRunReport(j.id, j.started, j.parameters)
+	// the remote back-end has the installed system, not this machine, and a disk image is detached when it is finished
+	if j.parameters["VERIFY_INSTALL"] == "true" && j.parameters["INSTALLER_RUNNER"] != config.RunnerRemote &&
+		j.parameters["TARGET_TYPE"] != config.TargetImage {
+		j.report.startVerification()
+	}
+	j.notify(EventStarted, "", nil)
+	err = runner.Start(c.ctx, j.parameters, j)
+	if err != nil {
+		slog.Error("failed to start the installer", "error", err)
+		exitCode := -1
+		j.report.finish(time.Now(), exitCode)
+		j.notify(EventFailed, "", &exitCode)
+		j.notifications.Close()
+		return fmt.Errorf("failed to start the installer: %w", err)
+	}
+	slog.Info("installation started", "job", j.id, "disk", j.resources.Disk, "work_dir", j.resources.WorkDir, "target", j.resources.Target)
+	return nil
+}
+
+// newInstaller creates the Installer selected by INSTALLER_RUNNER, or the FakeInstaller when simulating
+func (c *BackendContext) newInstaller(parameters map[string]string, resources installer.Resources) (installer.Installer, error) {
+	if c.simulation != nil {
+		return &installer.FakeInstaller{StepDelay: c.simulation.StepDelay, FailAt: c.simulation.FailAt}, nil
+	}
+	return installer.New(parameters, resources)
+}
+
+// findJob returns the job with the id, or the latest one when the id is empty
+func (c *BackendContext) findJob(id string) *job {
+	c.jobsLock.Lock()
+	defer c.jobsLock.Unlock()
+	if id == "" {
+		if len(c.jobs) == 0 {
+			return nil
+		}
+		return c.jobs[len(c.jobs)-1]
+	}
+	i := slices.IndexFunc(c.jobs, func(j *job) bool { return j.id == id })
+	if i < 0 {
+		return nil
+	}
+	return c.jobs[i]
+}
+
+// requestJob returns the job in the job query parameter, or the latest one,
+// it sends the error when there is none
+func (c *BackendContext) requestJob(w http.ResponseWriter, r *http.Request) (*job, bool) {
+	id := r.URL.Query().Get("job")
+	j := c.findJob(id)
+	switch {
+	case j != nil:
+		return j, true
+	case id != "":
+		writeError(w, http.StatusNotFound, ErrNotFound, "no such job", nil)
+	default:
+		writeError(w, http.StatusNotFound, ErrNotRunning, "no running process", nil)
+	}
+	return nil, false
+}
+
+// removeJob forgets a finished job and frees its resources
+func (c *BackendContext) removeJob(j *job) {
+	c.jobsLock.Lock()
+	defer c.jobsLock.Unlock()
+	c.jobs = slices.DeleteFunc(c.jobs, func(other *job) bool { return other == j })
+}
+
+// hasJobs is true while any installation holds its resources
+func (c *BackendContext) hasJobs() bool {
+	c.jobsLock.Lock()
+	defer c.jobsLock.Unlock()
+	return len(c.jobs) > 0 || len(c.starting) > 0
+}
+
+func (j *job) Write(p []byte) (int, error) {
+	return j.output.Write(p)
+}
+
+func (j *job) StepStarted(name string) {
+	slog.Info("installation step started", "job", j.id, "step", name)
+	j.report.stepStarted(name, time.Now())
+	if name == "cleanup" {
+		// the last step unmounts the installed system
+		j.writeInstalledReport()
+	}
+	j.notify(EventStep, name, nil)
+}
+
+// notify sends an event of the job to the notification targets
+func (j *job) notify(event NotificationEvent, step string, exitCode *int) {
+	hostname, _ := j.backend.hardware.Hostname()
+	n := Notification{
+		Event:          event,
+		RunId:          j.id,
+		Hostname:       hostname,
+		TargetHostname: j.parameters["HOSTNAME"],
+		Disk:           j.parameters["DISK"],
+		Step:           step,
+		Time:           time.Now(),
+		Duration:       time.Since(j.started).Seconds(),
+		ExitCode:       exitCode,
+	}
+	if event == EventSucceeded || event == EventFailed {
+		n.LastLines = lastLines(j.output.Bytes(), notificationLogLines)
+		n.Verification, _ = j.report.verification()
+		if event == EventFailed {
+			explanation := installer.ExplainFailure(*exitCode, j.installer.Progress(), j.output.Bytes())
+			n.Error = &explanation
+		}
+	}
+	j.notifications.Send(n)
+}
+
+func (j *job) waitForInstallerFinished() {
+	slog.Debug("waiting for the installer to finish", "job", j.id)
+	err := j.installer.Wait()
+	_, exitCode := j.installer.Result()
+	if _, verifying := j.report.verification(); verifying {
+		var checks []installer.VerificationCheck
+		if err == nil {
+			checks = j.verifyInstallation()
+		}
+		j.report.finishVerification(checks)
+	}
+	j.report.finish(time.Now(), exitCode)
+	if err != nil {
+		slog.Error("command failed", "job", j.id, "error", err)
+		j.notify(EventFailed, "", &exitCode)
+	} else {
+		slog.Info("command finished successfully", "job", j.id)
+		j.notify(EventSucceeded, "", &exitCode)
+	}
+	// the clients following the output must not wait for the delivery of the notifications, which may be retried for long
+	j.output.finish()
+	j.notifications.Close()
+}
+
+// status is the state of the job as reported by process_status, with the exit code once it finished
+func (j *job) status() (string, int) {
+	finished, returnCode := j.installer.Result()
+	if !finished {
+		return "RUNNING", -1
+	}
+	if _, verifying := j.report.verification(); verifying {
+		return "VERIFYING", returnCode
+	}
+	return "FINISHED", returnCode
+}
+
+// image is the finished disk image of the job
+func (j *job) image() (string, bool) {
+	if i, ok := j.installer.(installer.ImageOutput); ok {
+		return i.Image()
+	}
+	return "", false
+}
+
+func (j *job) jobStatus() JobStatus {
+	status, returnCode := j.status()
+	image, _ := j.image()
+	return JobStatus{
+		Id:         j.id,
+		Disk:       j.parameters["DISK"],
+		Hostname:   j.parameters["HOSTNAME"],
+		Status:     status,
+		ReturnCode: returnCode,
+		Progress:   j.installer.Progress(),
+		Started:    j.started,
+		Resources:  j.resources,
+		Image:      image,
+	}
+}
+
+// GetJobs lists the installations, the oldest first
+func (c *BackendContext) GetJobs(w http.ResponseWriter, _ *http.Request) {
+	c.jobsLock.Lock()
+	jobs := slices.Clone(c.jobs)
+	c.jobsLock.Unlock()
+	statuses := make([]JobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		statuses = append(statuses, j.jobStatus())
+	}
+	err := writeJson(w, statuses)
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		return
+	}
+}
+
+// writeStartError sends the error of startJob
+func writeStartError(w http.ResponseWriter, err error) {
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		slog.Error("refusing the installation", "error", apiErr.Message)
+		writeError(w, apiErr.Status, apiErr.Code, apiErr.Message, apiErr.Details)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, ErrInternal, err.Error(), nil)
+}
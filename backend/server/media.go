@@ -0,0 +1,102 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// maxMediaFileSize limits the files written to the esp and the usb sticks, the diagnostics bundle is the largest
+const maxMediaFileSize = 64 << 20
+
+// The front-ends do not run as root, so they save and load the preseeds, reports and diagnostics
+// on the esp and the usb sticks through the back-end.
+
+// GetMedia lists the directories files can be saved to, the esp first
+func (c *BackendContext) GetMedia(w http.ResponseWriter, _ *http.Request) {
+	err := writeJson(w, c.hardware.MediaLocations())
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		return
+	}
+}
+
+// mediaFile returns the path of the file in the location and name query parameters,
+// it sends the error when they are not one of the MediaLocations and a plain file name
+func (c *BackendContext) mediaFile(w http.ResponseWriter, r *http.Request) (string, bool) {
+	location, name := r.URL.Query().Get("location"), r.URL.Query().Get("name")
+	if !slices.Contains(c.hardware.MediaLocations(), location) {
+		writeError(w, http.StatusBadRequest, ErrBadRequest, fmt.Sprintf("%q is not a media location", location), nil)
+		return "", false
+	}
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		writeError(w, http.StatusBadRequest, ErrBadRequest, fmt.Sprintf("%q is not a file name", name), nil)
+		return "", false
+	}
+	return filepath.Join(location, name), true
+}
+
+// GetMediaFile sends a file from the esp or a usb stick
+func (c *BackendContext) GetMediaFile(w http.ResponseWriter, r *http.Request) {
+	path, ok := c.mediaFile(w, r)
+	if !ok {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, ErrNotFound, fmt.Sprintf("%s does not exist", path), nil)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to read the file", "path", path, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, fmt.Sprintf("failed to read %s", path), nil)
+		return
+	}
+	w.Header().Add("Content-Type", "application/octet-stream")
+	if _, err = w.Write(data); err != nil {
+		slog.Error("failed to write data", "error", err)
+	}
+}
+
+// PutMediaFile saves the request body to the esp or a usb stick, readable only by root
+func (c *BackendContext) PutMediaFile(w http.ResponseWriter, r *http.Request) {
+	path, ok := c.mediaFile(w, r)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMediaFileSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrBadRequest, fmt.Sprintf("failed to read the file: %v", err), nil)
+		return
+	}
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		slog.Error("failed to save the file", "path", path, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, fmt.Sprintf("failed to save %s", path), nil)
+		return
+	}
+	slog.Info("file saved", "path", path, "size", len(data))
+	writeAction(w, "SAVED")
+}
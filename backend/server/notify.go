@@ -0,0 +1,229 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type NotificationEvent string
+
+const (
+	EventStarted   NotificationEvent = "started"
+	EventStep      NotificationEvent = "step"
+	EventSucceeded NotificationEvent = "succeeded"
+	EventFailed    NotificationEvent = "failed"
+)
+
+const (
+	defaultNotifyRetries = 3
+	notificationLogLines = 20
+)
+
+var notifyRetryDelay = time.Second
+
+// Notification is the JSON payload sent to every notification target
+type Notification struct {
+	Event          NotificationEvent             `json:"event"`
+	RunId          string                        `json:"run_id"`
+	Hostname       string                        `json:"hostname"`
+	TargetHostname string                        `json:"target_hostname"`
+	Disk           string                        `json:"disk"`
+	Step           string                        `json:"step,omitempty"`
+	Time           time.Time                     `json:"time"`
+	Duration       float64                       `json:"duration_seconds"`
+	ExitCode       *int                          `json:"exit_code,omitempty"`
+	LastLines      []string                      `json:"last_lines,omitempty"`
+	Verification   []installer.VerificationCheck `json:"verification,omitempty"`
+	Error          *installer.InstallError       `json:"error,omitempty"`
+}
+
+// Notifier delivers notifications to one target
+type Notifier interface {
+	Notify(ctx context.Context, payload []byte) error
+	String() string
+}
+
+type WebhookNotifier struct {
+	Url    string
+	Client *http.Client
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (n WebhookNotifier) String() string {
+	return "webhook " + n.Url
+}
+
+// FileNotifier appends the notifications to a file, one JSON document per line
+type FileNotifier struct {
+	Path string
+}
+
+func (n FileNotifier) Notify(_ context.Context, payload []byte) error {
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(payload, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (n FileNotifier) String() string {
+	return "file " + n.Path
+}
+
+// CommandNotifier runs a shell command with the notification on its standard input
+type CommandNotifier struct {
+	Command string
+}
+
+func (n CommandNotifier) Notify(ctx context.Context, payload []byte) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", n.Command)
+	cmd.Stdin = bytes.NewReader(payload)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (n CommandNotifier) String() string {
+	return "command " + n.Command
+}
+
+func newNotifiers(parameters map[string]string) []Notifier {
+	var notifiers []Notifier
+	if u := parameters["NOTIFY_WEBHOOK_URL"]; u != "" {
+		notifiers = append(notifiers, WebhookNotifier{Url: u, Client: &http.Client{Timeout: 30 * time.Second}})
+	}
+	if path := parameters["NOTIFY_FILE"]; path != "" {
+		notifiers = append(notifiers, FileNotifier{Path: path})
+	}
+	if command := parameters["NOTIFY_COMMAND"]; command != "" {
+		notifiers = append(notifiers, CommandNotifier{Command: command})
+	}
+	return notifiers
+}
+
+// notifications delivers the events of one installation in order, retrying every target
+type notifications struct {
+	notifiers []Notifier
+	retries   int
+	events    chan Notification
+	done      chan struct{}
+}
+
+func startNotifications(ctx context.Context, notifiers []Notifier, retries int) *notifications {
+	n := &notifications{
+		notifiers: notifiers,
+		retries:   retries,
+		events:    make(chan Notification, 100),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(n.done)
+		for event := range n.events {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("failed to encode the notification", "error", err)
+				continue
+			}
+			for _, notifier := range n.notifiers {
+				n.deliver(ctx, notifier, payload)
+			}
+		}
+	}()
+	return n
+}
+
+func (n *notifications) deliver(ctx context.Context, notifier Notifier, payload []byte) {
+	delay := notifyRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := notifier.Notify(ctx, payload)
+		if err == nil {
+			return
+		}
+		if attempt > n.retries {
+			slog.Error("failed to deliver the notification", "target", notifier.String(), "error", err)
+			return
+		}
+		slog.Warn("failed to deliver the notification, retrying", "target", notifier.String(), "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (n *notifications) Send(event Notification) {
+	if len(n.notifiers) == 0 {
+		return
+	}
+	select {
+	case n.events <- event:
+	default:
+		slog.Warn("too many notifications pending, dropping one", "event", event.Event)
+	}
+}
+
+// Close waits until the pending notifications are delivered
+func (n *notifications) Close() {
+	close(n.events)
+	<-n.done
+}
+
+// lastLines returns up to n last lines of the output
+func lastLines(output []byte, n int) []string {
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,281 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/r0b0/debian-installer/backend/hardware"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PreflightStatus string
+
+const (
+	PreflightPass PreflightStatus = "PASS"
+	PreflightWarn PreflightStatus = "WARN"
+	PreflightFail PreflightStatus = "FAIL"
+)
+
+const (
+	defaultMinDiskSizeGb = 16
+	defaultMinRamMb      = 1024
+)
+
+type PreflightResult struct {
+	Name    string          `json:"name"`
+	Status  PreflightStatus `json:"status"`
+	Message string          `json:"message"`
+}
+
+type PreflightResponse struct {
+	Passed  bool              `json:"passed"`
+	Results []PreflightResult `json:"results"`
+}
+
+// PreflightCheck inspects the machine against the parameters of the pending installation.
+// Checks must not modify anything.
+type PreflightCheck func(hw hardware.Hardware, parameters map[string]string) PreflightResult
+
+var preflightChecks = []PreflightCheck{
+	checkEfi,
+	checkDiskSize,
+	checkRam,
+	checkRequiredBinaries,
+	checkTpm,
+	checkAcPower,
+	checkMirror,
+	checkDiskNotMounted,
+}
+
+func RegisterPreflightCheck(check PreflightCheck) {
+	preflightChecks = append(preflightChecks, check)
+}
+
+func runPreflightChecks(hw hardware.Hardware, parameters map[string]string) PreflightResponse {
+	resp := PreflightResponse{Passed: true}
+	for _, check := range preflightChecks {
+		result := check(hw, parameters)
+		slog.Debug("preflight check", "name", result.Name, "status", result.Status, "message", result.Message)
+		if result.Status == PreflightFail {
+			resp.Passed = false
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp
+}
+
+// Preflight runs all the checks. Query parameters override the configured ones,
+// so that the front-ends can check the values the user has selected but not yet submitted.
+func (c *BackendContext) Preflight(w http.ResponseWriter, r *http.Request) {
+	parameters := c.parameters()
+	for k, v := range r.URL.Query() {
+		parameters[k] = v[0]
+	}
+	err := writeJson(w, runPreflightChecks(c.hardware, parameters))
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		return
+	}
+}
+
+func checkEfi(hw hardware.Hardware, _ map[string]string) PreflightResult {
+	r := PreflightResult{Name: "EFI"}
+	hasEfi, err := hw.HasEfi()
+	switch {
+	case err != nil:
+		r.Status, r.Message = PreflightFail, fmt.Sprintf("failed to detect efi: %v", err)
+	case !hasEfi:
+		r.Status, r.Message = PreflightFail, "this system does not appear to use EFI"
+	default:
+		r.Status, r.Message = PreflightPass, "booted in EFI mode"
+	}
+	return r
+}
+
+func checkDiskSize(hw hardware.Hardware, parameters map[string]string) PreflightResult {
+	r := PreflightResult{Name: "Disk size"}
+	disk := parameters["DISK"]
+	if disk == "" {
+		r.Status, r.Message = PreflightFail, "no target disk selected"
+		return r
+	}
+	minSize := intParameter(parameters, "MIN_DISK_SIZE_GB", defaultMinDiskSizeGb)
+	if parameters["TARGET_TYPE"] == config.TargetImage {
+		imageSize := intParameter(parameters, "IMAGE_SIZE", 0)
+		if imageSize < minSize {
+			r.Status, r.Message = PreflightFail, fmt.Sprintf("the disk image has %d GiB, at least %d GiB required", imageSize, minSize)
+		} else {
+			r.Status, r.Message = PreflightPass, fmt.Sprintf("the disk image will have %d GiB", imageSize)
+		}
+		return r
+	}
+	size, err := hw.DiskSize(disk)
+	if err != nil {
+		r.Status, r.Message = PreflightFail, fmt.Sprintf("failed to detect size of %s: %v", disk, err)
+		return r
+	}
+	sizeGb := size >> 30
+	if sizeGb < int64(minSize) {
+		r.Status, r.Message = PreflightFail, fmt.Sprintf("%s has %d GiB, at least %d GiB required", disk, sizeGb, minSize)
+	} else {
+		r.Status, r.Message = PreflightPass, fmt.Sprintf("%s has %d GiB", disk, sizeGb)
+	}
+	return r
+}
+
+func checkRam(hw hardware.Hardware, parameters map[string]string) PreflightResult {
+	r := PreflightResult{Name: "Memory"}
+	minRam := intParameter(parameters, "MIN_RAM_MB", defaultMinRamMb)
+	ram, err := hw.MemTotal()
+	if err != nil {
+		r.Status, r.Message = PreflightWarn, fmt.Sprintf("failed to detect memory size: %v", err)
+		return r
+	}
+	ramMb := ram >> 20
+	if ramMb < int64(minRam) {
+		r.Status, r.Message = PreflightFail, fmt.Sprintf("%d MiB of memory, at least %d MiB required", ramMb, minRam)
+	} else {
+		r.Status, r.Message = PreflightPass, fmt.Sprintf("%d MiB of memory", ramMb)
+	}
+	return r
+}
+
+func checkRequiredBinaries(hw hardware.Hardware, parameters map[string]string) PreflightResult {
+	r := PreflightResult{Name: "Required tools"}
+	binaries := []string{"systemd-repart", "cryptsetup", "btrfs", "debootstrap"}
+	if parameters["TARGET_TYPE"] == config.TargetImage {
+		binaries = append(binaries, "losetup")
+	}
+	var missing []string
+	for _, binary := range binaries {
+		if !hw.HasCommand(binary) {
+			missing = append(missing, binary)
+		}
+	}
+	if len(missing) > 0 {
+		r.Status, r.Message = PreflightFail, fmt.Sprintf("missing %s", strings.Join(missing, ", "))
+	} else {
+		r.Status, r.Message = PreflightPass, "all required tools found"
+	}
+	return r
+}
+
+func checkTpm(hw hardware.Hardware, parameters map[string]string) PreflightResult {
+	r := PreflightResult{Name: "TPM"}
+	if parameters["ENABLE_TPM"] != "true" || parameters["DISABLE_LUKS"] == "true" {
+		r.Status, r.Message = PreflightPass, "TPM unlock not requested"
+		return r
+	}
+	if parameters["TARGET_TYPE"] == config.TargetImage {
+		r.Status, r.Message = PreflightFail, "TPM unlock would only work on this machine, not where the disk image boots"
+		return r
+	}
+	if hw.HasTpm() {
+		r.Status, r.Message = PreflightPass, "TPM device found"
+	} else {
+		r.Status, r.Message = PreflightFail, "TPM unlock requested but no TPM device found"
+	}
+	return r
+}
+
+func checkAcPower(hw hardware.Hardware, _ map[string]string) PreflightResult {
+	r := PreflightResult{Name: "AC power"}
+	hasBattery, onAc := hw.PowerSupply()
+	switch {
+	case !hasBattery:
+		r.Status, r.Message = PreflightPass, "no battery"
+	case onAc:
+		r.Status, r.Message = PreflightPass, "connected to AC power"
+	default:
+		r.Status, r.Message = PreflightWarn, "running on battery, connect the AC adapter"
+	}
+	return r
+}
+
+func checkMirror(hw hardware.Hardware, parameters map[string]string) PreflightResult {
+	r := PreflightResult{Name: "Debian mirror"}
+	if hw.HasBootstrapImage() {
+		r.Status, r.Message = PreflightPass, "bootstrap image available, mirror not needed"
+		return r
+	}
+	releaseUrl := fmt.Sprintf("%s/dists/%s/Release", installer.DebianMirror, installer.DebianVersion(parameters))
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Head(releaseUrl)
+	if err != nil {
+		r.Status, r.Message = PreflightFail, fmt.Sprintf("mirror not reachable: %v", err)
+		return r
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		r.Status, r.Message = PreflightFail, fmt.Sprintf("mirror returned %s for %s", resp.Status, releaseUrl)
+	} else {
+		r.Status, r.Message = PreflightPass, fmt.Sprintf("%s reachable", installer.DebianMirror)
+	}
+	return r
+}
+
+func checkDiskNotMounted(hw hardware.Hardware, parameters map[string]string) PreflightResult {
+	r := PreflightResult{Name: "Disk not in use"}
+	disk := parameters["DISK"]
+	if disk == "" {
+		r.Status, r.Message = PreflightFail, "no target disk selected"
+		return r
+	}
+	if parameters["TARGET_TYPE"] == config.TargetImage {
+		// the image file itself is created, or overwritten, by the installation
+		if !hw.DirExists(filepath.Dir(disk)) {
+			r.Status, r.Message = PreflightFail, fmt.Sprintf("the directory of the disk image %s does not exist", disk)
+		} else {
+			r.Status, r.Message = PreflightPass, fmt.Sprintf("%s is a disk image file", disk)
+		}
+		return r
+	}
+	mounted, err := hw.DiskMountpoints(disk)
+	if err != nil {
+		r.Status, r.Message = PreflightWarn, fmt.Sprintf("failed to check mounts of %s: %v", disk, err)
+		return r
+	}
+	if len(mounted) > 0 {
+		r.Status, r.Message = PreflightFail, fmt.Sprintf("%s is in use: %s", disk, strings.Join(mounted, ", "))
+	} else {
+		r.Status, r.Message = PreflightPass, fmt.Sprintf("%s is not mounted", disk)
+	}
+	return r
+}
+
+func intParameter(parameters map[string]string, name string, def int) int {
+	value, found := parameters[name]
+	if !found || value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("invalid number, using default", "name", name, "value", value, "default", def)
+		return def
+	}
+	return i
+}
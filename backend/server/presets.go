@@ -0,0 +1,198 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/r0b0/debian-installer/backend/hardware"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// presetFileNames are looked for in the root of every removable file system, in this order
+var presetFileNames = []string{"odi-preseed.json", "odi-preseed.yaml", "odi-preseed.yml", "odi-preseed.ini", "installer.ini"}
+
+const presetsPollInterval = 5 * time.Second
+
+// Preset is a preseed found on removable media. Applying it never starts the installation.
+type Preset struct {
+	Id         string               `json:"id"`
+	Device     string               `json:"device"`
+	Label      string               `json:"label"`
+	File       string               `json:"file"`
+	Parameters map[string]string    `json:"parameters"`
+	Errors     []config.ConfigError `json:"errors"`
+}
+
+type presetDevice struct {
+	Path        string         `json:"path"`
+	Label       string         `json:"label"`
+	Fstype      string         `json:"fstype"`
+	Removable   bool           `json:"rm"`
+	Hotplug     bool           `json:"hotplug"`
+	Mountpoints []*string      `json:"mountpoints"`
+	Children    []presetDevice `json:"children"`
+}
+
+func (d presetDevice) mountpoint() string {
+	for _, m := range d.Mountpoints {
+		if m != nil {
+			return *m
+		}
+	}
+	return ""
+}
+
+// removableFileSystems finds the file systems on removable disks in the output of lsblk -OJ.
+// The disk the installer itself runs from is left out.
+func removableFileSystems(lsblk []byte) ([]presetDevice, error) {
+	var devices struct {
+		Blockdevices []presetDevice `json:"blockdevices"`
+	}
+	err := json.Unmarshal(lsblk, &devices)
+	if err != nil {
+		return nil, err
+	}
+	var fileSystems []presetDevice
+	for _, disk := range devices.Blockdevices {
+		if !disk.Removable && !disk.Hotplug {
+			continue
+		}
+		candidates := append([]presetDevice{disk}, disk.Children...)
+		if slices.ContainsFunc(candidates, func(d presetDevice) bool {
+			return d.mountpoint() == "/" || d.mountpoint() == "/boot/efi"
+		}) {
+			continue
+		}
+		for _, d := range candidates {
+			if d.Fstype != "" && d.Fstype != "swap" && d.Fstype != "crypto_LUKS" {
+				fileSystems = append(fileSystems, d)
+			}
+		}
+	}
+	return fileSystems, nil
+}
+
+func discoverPresets(hw hardware.Hardware, fileSystems []presetDevice) []Preset {
+	var presets []Preset
+	for _, d := range fileSystems {
+		files, err := hw.ReadDeviceFiles(d.Path, d.mountpoint(), presetFileNames)
+		if err != nil {
+			slog.Warn("failed to look for presets", "device", d.Path, "error", err)
+			continue
+		}
+		for _, name := range presetFileNames {
+			data, found := files[name]
+			if !found {
+				continue
+			}
+			p := Preset{
+				Id:     path.Base(d.Path) + "-" + name,
+				Device: d.Path,
+				Label:  d.Label,
+				File:   name,
+			}
+			p.Parameters, p.Errors = config.Parse(bytes.NewReader(data), d.Path+":"+name, config.FormatOf(name))
+			// a stick must not decide how the back-end runs, e.g. start the installation by itself
+			for k := range p.Parameters {
+				if config.IsBackendSetting(k) {
+					delete(p.Parameters, k)
+					p.Errors = append(p.Errors, config.ConfigError{File: d.Path + ":" + name, Key: k, Message: fmt.Sprintf("%s is not allowed in a preset", k)})
+				}
+			}
+			presets = append(presets, p)
+		}
+	}
+	return presets
+}
+
+// watchPresets looks for presets at startup and whenever removable file systems appear or disappear
+func (c *BackendContext) watchPresets(ctx context.Context, interval time.Duration) {
+	seen := ""
+	for {
+		lsblk, err := c.hardware.BlockDevices()
+		if err != nil {
+			slog.Warn("failed to list block devices for presets", "error", err)
+		} else if fileSystems, err := removableFileSystems(lsblk); err != nil {
+			slog.Warn("failed to parse block devices for presets", "error", err)
+		} else {
+			var paths []string
+			for _, d := range fileSystems {
+				paths = append(paths, d.Path)
+			}
+			if key := strings.Join(paths, " "); key != seen {
+				seen = key
+				presets := discoverPresets(c.hardware, fileSystems)
+				for _, p := range presets {
+					slog.Info("found preset", "device", p.Device, "label", p.Label, "file", p.File)
+				}
+				c.presetsLock.Lock()
+				c.presets = presets
+				c.presetsLock.Unlock()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c *BackendContext) GetPresets(w http.ResponseWriter, _ *http.Request) {
+	c.presetsLock.Lock()
+	presets := slices.Clone(c.presets)
+	c.presetsLock.Unlock()
+	for i := range presets {
+		presets[i].Parameters = config.Redact(presets[i].Parameters)
+	}
+	err := writeJson(w, presets)
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		return
+	}
+}
+
+// ApplyPreset sets the parameters of the preset, like the user would, for the next job
+func (c *BackendContext) ApplyPreset(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	c.presetsLock.Lock()
+	i := slices.IndexFunc(c.presets, func(p Preset) bool { return p.Id == id })
+	var preset Preset
+	if i >= 0 {
+		preset = c.presets[i]
+	}
+	c.presetsLock.Unlock()
+	if i < 0 {
+		writeError(w, http.StatusNotFound, ErrNotFound, "no such preset", nil)
+		return
+	}
+	slog.Info("applying preset", "device", preset.Device, "file", preset.File)
+	c.setParameters(preset.Parameters, config.SourcePreset)
+	writeAction(w, "OK")
+}
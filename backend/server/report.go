@@ -0,0 +1,208 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/r0b0/debian-installer/backend/hardware"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// installedReportPath is where the report is written in the installed system, relative to its root
+const installedReportPath = "var/log/opinionated-installer/report.json"
+
+const maxReportWarnings = 100
+
+// InstallReport records the facts about one installation
+type InstallReport struct {
+	RunId         string                        `json:"run_id"`
+	Disk          string                        `json:"disk"`
+	Started       time.Time                     `json:"started"`
+	Finished      *time.Time                    `json:"finished,omitempty"`
+	ExitCode      *int                          `json:"exit_code,omitempty"`
+	Parameters    map[string]string             `json:"parameters"`
+	System        *hardware.InstalledSystem     `json:"system,omitempty"`
+	MokEnrollment string                        `json:"mok_enrollment"`
+	Steps         []StepTiming                  `json:"steps"`
+	Verification  []installer.VerificationCheck `json:"verification,omitempty"`
+	Warnings      []string                      `json:"warnings"`
+}
+
+type StepTiming struct {
+	Name     string    `json:"name"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+}
+
+// runReport collects the report while the installation is running
+type runReport struct {
+	lock      sync.Mutex
+	report    InstallReport
+	warnings  []string
+	verifying bool
+	// secrets are the secret parameters, their values are replaced in the warnings from the output
+	secrets map[string]string
+}
+
+func newRunReport(runId string, started time.Time, parameters map[string]string) *runReport {
+	secrets := maps.Clone(parameters)
+	maps.DeleteFunc(secrets, func(k string, _ string) bool { return !config.IsSecret(k) })
+	return &runReport{report: InstallReport{
+		RunId:         runId,
+		Disk:          parameters["DISK"],
+		Started:       started,
+		Parameters:    config.Redact(parameters),
+		MokEnrollment: mokEnrollment(parameters, nil),
+	}, secrets: secrets}
+}
+
+func (r *runReport) stepStarted(name string, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.endStep(now)
+	r.report.Steps = append(r.report.Steps, StepTiming{Name: name, Started: now})
+}
+
+func (r *runReport) endStep(now time.Time) {
+	if n := len(r.report.Steps); n > 0 && r.report.Steps[n-1].Duration == 0 {
+		r.report.Steps[n-1].Duration = now.Sub(r.report.Steps[n-1].Started).Seconds()
+	}
+}
+
+// collect records the facts about the installed system, or a warning when they are not available
+func (r *runReport) collect(hw hardware.Hardware, resources installer.Resources, parameters map[string]string) {
+	system, err := hw.InstalledSystem(resources)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err != nil {
+		slog.Warn("failed to describe the installed system", "error", err)
+		r.warnings = append(r.warnings, fmt.Sprintf("the installed system could not be described: %v", err))
+	}
+	r.report.System = &system
+	r.report.MokEnrollment = mokEnrollment(parameters, &system)
+}
+
+func (r *runReport) finish(now time.Time, exitCode int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.endStep(now)
+	r.report.Finished = &now
+	r.report.ExitCode = &exitCode
+}
+
+func (r *runReport) startVerification() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.verifying = true
+}
+
+func (r *runReport) finishVerification(checks []installer.VerificationCheck) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.verifying = false
+	r.report.Verification = checks
+}
+
+// verification returns the checks of the installed system and whether they are still pending
+func (r *runReport) verification() ([]installer.VerificationCheck, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return slices.Clone(r.report.Verification), r.verifying
+}
+
+// snapshot returns a copy of the report, with the warnings found in the installer output so far
+func (r *runReport) snapshot(output []byte) InstallReport {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	report := r.report
+	report.Steps = slices.Clone(r.report.Steps)
+	report.Verification = slices.Clone(r.report.Verification)
+	report.Warnings = append(slices.Clone(r.warnings), outputWarnings(redactOutput(output, r.secrets))...)
+	if len(report.Warnings) > maxReportWarnings {
+		report.Warnings = report.Warnings[:maxReportWarnings]
+	}
+	return report
+}
+
+func mokEnrollment(parameters map[string]string, system *hardware.InstalledSystem) string {
+	switch {
+	case parameters["ENABLE_MOK_SIGNED_UKI"] != "true":
+		return "disabled"
+	case system == nil:
+		return "unknown"
+	case system.MokKeysPending > 0:
+		return "pending, confirm with the MOK password on the next boot"
+	default:
+		return "not pending, the key was not imported"
+	}
+}
+
+// outputWarnings finds the warnings of apt, dpkg and the other tools in the installer output
+func outputWarnings(output []byte) []string {
+	var warnings []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "W: ") || strings.Contains(strings.ToLower(line), "warning") {
+			if !slices.Contains(warnings, line) {
+				warnings = append(warnings, line)
+			}
+		}
+	}
+	return warnings
+}
+
+// writeInstalledReport writes the report into the installed system before it gets unmounted
+func (j *job) writeInstalledReport() {
+	j.report.collect(j.backend.hardware, j.resources, j.parameters)
+	data, err := json.MarshalIndent(j.report.snapshot(j.output.Bytes()), "", "  ")
+	if err != nil {
+		slog.Error("failed to encode the report", "error", err)
+		return
+	}
+	err = j.backend.hardware.WriteInstalledFile(j.resources, installedReportPath, data)
+	if err != nil {
+		slog.Error("failed to write the report into the installed system", "error", err)
+		return
+	}
+	slog.Info("report written into the installed system", "job", j.id, "path", "/"+installedReportPath)
+}
+
+func (c *BackendContext) GetRunReport(w http.ResponseWriter, r *http.Request) {
+	// a run is a job, its report is kept until it is cleared
+	j := c.findJob(r.PathValue("id"))
+	if j == nil || r.PathValue("id") == "" {
+		writeError(w, http.StatusNotFound, ErrNotFound, "no such run", nil)
+		return
+	}
+	err := writeJson(w, j.report.snapshot(j.output.Bytes()))
+	if err != nil {
+		slog.Error("failed to write data", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "failed to write data", nil)
+		return
+	}
+}
@@ -0,0 +1,190 @@
+// Package server is the back-end, it serves the REST API and runs the installation.
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/r0b0/debian-installer/backend/hardware"
+	"golang.org/x/net/context"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// SimulationOptions replace the hardware with fixtures and the installer with the FakeInstaller
+type SimulationOptions struct {
+	// Fixtures is a directory with lsblk.json and login.json, empty for the built-in ones
+	Fixtures  string
+	StepDelay time.Duration
+	// FailAt is the name of the simulated step that fails, empty for a successful installation
+	FailAt string
+}
+
+// BackendContext is the back-end service, it serves the REST API and runs the installations,
+// several at a time when each has its own disk
+type BackendContext struct {
+	hardware   hardware.Hardware
+	simulation *SimulationOptions
+	jobs       []*job
+	// starting are the jobs that hold their resources while their installer starts, jobsLock guards both
+	starting          []*job
+	jobsLock          sync.Mutex
+	runningParameters map[string]string
+	parameterSources  map[string]config.ParameterSource
+	// parametersLock guards runningParameters and parameterSources, the handlers and the automatic installation change them
+	parametersLock sync.Mutex
+	configPath     string
+	configErrors   []config.ConfigError
+	presets        []Preset
+	presetsLock    sync.Mutex
+	autoInstall    autoInstall
+	// messages are the output of the back-end outside the jobs, the automatic installation continues it
+	messages *outputLog
+	ctx      context.Context
+}
+
+// setParameters changes the running parameters and remembers where their values came from
+func (c *BackendContext) setParameters(parameters map[string]string, source config.ParameterSource) {
+	c.parametersLock.Lock()
+	defer c.parametersLock.Unlock()
+	for k, v := range parameters {
+		c.runningParameters[k] = v
+		c.parameterSources[k] = source
+	}
+}
+
+// parameters returns a copy of the running parameters
+func (c *BackendContext) parameters() map[string]string {
+	c.parametersLock.Lock()
+	defer c.parametersLock.Unlock()
+	return maps.Clone(c.runningParameters)
+}
+
+// parameter returns one of the running parameters
+func (c *BackendContext) parameter(name string) string {
+	c.parametersLock.Lock()
+	defer c.parametersLock.Unlock()
+	return c.runningParameters[name]
+}
+
+// sources returns a copy of where the running parameters came from
+func (c *BackendContext) sources() map[string]config.ParameterSource {
+	c.parametersLock.Lock()
+	defer c.parametersLock.Unlock()
+	return maps.Clone(c.parameterSources)
+}
+
+// New creates a back-end with the parameters from the environment, the configuration file at configPath
+// (if not empty) and the kernel command line. With simulation, nothing is installed.
+func New(ctx context.Context, configPath string, simulation *SimulationOptions) (*BackendContext, error) {
+	app := &BackendContext{
+		hardware:          hardware.Local{},
+		simulation:        simulation,
+		runningParameters: map[string]string{"NON_INTERACTIVE": "yes"},
+		parameterSources:  map[string]config.ParameterSource{"NON_INTERACTIVE": config.SourceDefault},
+		messages:          newOutputLog(),
+		ctx:               ctx,
+	}
+
+	if simulation != nil {
+		slog.Warn("simulating the hardware and the installer, nothing will be installed")
+		hw, err := hardware.NewSimulated(simulation.Fixtures)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the simulation fixtures: %w", err)
+		}
+		app.hardware = hw
+	}
+
+	app.setParameters(config.Environ(), config.SourceEnvironment)
+	app.configPath = configPath
+	if configPath != "" {
+		parameters, configErrors, err := config.LoadFile(configPath)
+		if err != nil {
+			slog.Warn("failed to read the configuration file", "path", configPath, "error", err)
+		}
+		app.configErrors = configErrors
+		app.setParameters(parameters, config.SourceIni)
+	}
+	cmdline, err := os.ReadFile(config.KernelCmdlinePath)
+	if err != nil {
+		slog.Warn("failed to read the kernel command line", "error", err)
+	}
+	app.loadKernelCmdline(app.ctx, &http.Client{Timeout: preseedRequestTimeout}, string(cmdline))
+	for _, configError := range app.configErrors {
+		slog.Error("invalid configuration", "error", configError)
+	}
+	return app, nil
+}
+
+// Handler serves the REST API and the static files of the web interface from staticPath
+func (c *BackendContext) Handler(staticPath string) http.Handler {
+	mux := http.NewServeMux()
+	c.registerRoutes(mux, staticPath)
+	return mux
+}
+
+// Start watches the removable media for presets and starts the countdown of AUTO_INSTALL
+func (c *BackendContext) Start() {
+	go c.watchPresets(c.ctx, presetsPollInterval)
+
+	if c.parameter("AUTO_INSTALL") == "true" {
+		// the countdown runs while the http server starts, so that clients can abort it
+		go c.startAutoInstall()
+	}
+}
+
+// Backend runs the back-end service until its http server fails
+func Backend(listenPort *int, staticPath *string, configPath *string, simulation *SimulationOptions) {
+	slog.SetLogLoggerLevel(slog.LevelDebug)
+
+	app, err := New(context.Background(), *configPath, simulation)
+	if err != nil {
+		slog.Error("failed to create the back-end", "error", err)
+		os.Exit(1)
+	}
+
+	backendIp := app.parameter("BACK_END_IP_ADDRESS")
+	if backendIp == "" {
+		slog.Warn("BACK_END_IP_ADDRESS not configured, using localhost")
+		backendIp = "localhost"
+	}
+
+	handler := app.Handler(*staticPath)
+	app.Start()
+
+	err = hardware.SystemdNotifyReady()
+	if err != nil {
+		slog.Error("failed to notify systemd", "error", err)
+	}
+
+	slog.Info("Starting backend http server", "backendIp", backendIp, "port", *listenPort)
+	err = http.ListenAndServe(fmt.Sprintf("%s:%d", backendIp, *listenPort), handler)
+	if errors.Is(err, http.ErrServerClosed) {
+		slog.Info("Server closed")
+	} else {
+		slog.Error("Failed to start server", "error", err)
+		os.Exit(1)
+	}
+}
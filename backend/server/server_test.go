@@ -0,0 +1,959 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/r0b0/debian-installer/backend/hardware"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadKernelCmdline(t *testing.T) {
+	const PRESEED = "DISK: /dev/vda\nHOSTNAME: pc1\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/preseed.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(PRESEED))
+	}))
+	defer server.Close()
+
+	cmdline := fmt.Sprintf("BOOT_IMAGE=/vmlinuz quiet odi.preseed=%s/preseed.yaml odi.HOSTNAME=pc2 odi.swap_size=x\n", server.URL)
+	c := BackendContext{runningParameters: map[string]string{}, parameterSources: map[string]config.ParameterSource{}}
+	c.loadKernelCmdline(context.Background(), server.Client(), cmdline)
+	if c.runningParameters["DISK"] != "/dev/vda" || c.parameterSources["DISK"] != config.SourceUrl {
+		t.Errorf("DISK = %q from %s; want /dev/vda from the url", c.runningParameters["DISK"], c.parameterSources["DISK"])
+	}
+	if c.runningParameters["HOSTNAME"] != "pc2" || c.parameterSources["HOSTNAME"] != config.SourceCmdline {
+		t.Errorf("HOSTNAME = %q from %s; want pc2 from the cmdline", c.runningParameters["HOSTNAME"], c.parameterSources["HOSTNAME"])
+	}
+	if len(c.configErrors) != 1 || c.configErrors[0].Key != "SWAP_SIZE" {
+		t.Errorf("Errors = %v; want one for SWAP_SIZE", c.configErrors)
+	}
+
+	// a preseed server that is not there, its credentials must not end up in the errors
+	server.Close()
+	failing := strings.Replace(server.URL, "http://", "http://bob:pa55@", 1) + "/preseed.yaml?token=s3cret"
+	c = BackendContext{runningParameters: map[string]string{}, parameterSources: map[string]config.ParameterSource{}}
+	c.loadKernelCmdline(context.Background(), server.Client(), "odi.preseed="+failing+" odi.preseed.retries=1")
+	if len(c.configErrors) != 1 || strings.Contains(c.configErrors[0].Error(), "s3cret") || strings.Contains(c.configErrors[0].Error(), "pa55") {
+		t.Errorf("Errors = %v; want one without the credentials", c.configErrors)
+	}
+}
+
+func TestDiscoverPresets(t *testing.T) {
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatalf("Failed to load the fixtures: %v", err)
+	}
+	lsblk, _ := hw.BlockDevices()
+	fileSystems, err := removableFileSystems(lsblk)
+	if err != nil {
+		t.Fatalf("Failed to parse lsblk: %v", err)
+	}
+	if len(fileSystems) != 1 || fileSystems[0].Path != "/dev/sdc1" {
+		t.Fatalf("File systems = %v; want only /dev/sdc1, not the installer stick", fileSystems)
+	}
+	presets := discoverPresets(hw, fileSystems)
+	if len(presets) != 1 || presets[0].Label != "SITE-VIENNA" || presets[0].Parameters["HOSTNAME"] != "vienna-ws" {
+		t.Fatalf("Presets = %v; want the one from SITE-VIENNA", presets)
+	}
+	if _, found := presets[0].Parameters["AUTO_INSTALL"]; found || len(presets[0].Errors) != 1 {
+		t.Errorf("Preset = %v; want AUTO_INSTALL refused", presets[0])
+	}
+}
+
+func TestResolveStableDisk(t *testing.T) {
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatalf("Failed to load the fixtures: %v", err)
+	}
+	tests := []struct {
+		disk, serial, want string
+	}{
+		{"/dev/nvme0n1", "", ""},
+		{"/dev/disk/by-id/nvme-Samsung_SSD_980_PRO_1TB_S5GXNF0R812345A", "", "/dev/nvme0n1"},
+		{"", "S5GXNF0R812345A", "/dev/nvme0n1"},
+		{"/dev/sda", "S5GXNF0R812345A", ""},
+		{"", "NOT-PRESENT", ""},
+	}
+	for _, test := range tests {
+		got, err := resolveStableDisk(hw, test.disk, test.serial)
+		if got != test.want || (test.want == "") != (err != nil) {
+			t.Errorf("resolveStableDisk(%q, %q) = %q, %v; want %q", test.disk, test.serial, got, err, test.want)
+		}
+	}
+}
+
+func TestAutoInstallParameters(t *testing.T) {
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &BackendContext{
+		hardware:   hw,
+		simulation: &SimulationOptions{StepDelay: time.Millisecond},
+		runningParameters: map[string]string{"DISK": "/dev/disk/by-id/nvme-Samsung_SSD_980_PRO_1TB_S5GXNF0R812345A",
+			"HOSTNAME": "pc1", "AUTO_INSTALL_COUNTDOWN": "1"},
+		parameterSources: map[string]config.ParameterSource{},
+		messages:         newOutputLog(),
+		ctx:              context.Background(),
+	}
+	done := make(chan struct{})
+	go func() {
+		c.startAutoInstall()
+		close(done)
+	}()
+	for !c.autoInstall.State().Pending {
+		time.Sleep(time.Millisecond)
+	}
+	// changed during the countdown, after the disk was resolved and shown
+	c.setParameters(map[string]string{"DISK": "/dev/sda", "HOSTNAME": "pc2"}, config.SourceUser)
+	<-done
+
+	j := c.findJob("")
+	if j == nil {
+		t.Fatal("No job; want the automatic installation")
+	}
+	_ = j.installer.Wait()
+	if p := j.parameters; p["DISK"] != "/dev/nvme0n1" || p["HOSTNAME"] != "pc1" {
+		t.Errorf("Parameters = %v; want the resolved disk and the values from before the countdown", config.Redact(p))
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	var received []Notification
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "not yet", http.StatusServiceUnavailable)
+			return
+		}
+		var n Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("Failed to decode the notification: %v", err)
+		}
+		received = append(received, n)
+	}))
+	defer server.Close()
+	notifyRetryDelay = time.Millisecond
+
+	file := filepath.Join(t.TempDir(), "events.jsonl")
+	notifiers := []Notifier{WebhookNotifier{Url: server.URL, Client: server.Client()}, FileNotifier{Path: file}}
+	n := startNotifications(context.Background(), notifiers, 2)
+	exitCode := 0
+	n.Send(Notification{Event: EventStarted, RunId: "run"})
+	n.Send(Notification{Event: EventSucceeded, RunId: "run", ExitCode: &exitCode, LastLines: lastLines([]byte("a\nb\nc\n"), 2)})
+	n.Close()
+
+	if len(received) != 2 || received[0].Event != EventStarted || received[1].Event != EventSucceeded {
+		t.Fatalf("Received = %v; want started and succeeded", received)
+	}
+	if !slices.Equal(received[1].LastLines, []string{"b", "c"}) || received[1].ExitCode == nil || *received[1].ExitCode != 0 {
+		t.Errorf("Received = %+v; want exit code 0 and the last two lines", received[1])
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", file, err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Errorf("File has %d lines; want 2", len(lines))
+	}
+}
+
+func TestOutputEndsBeforeNotifications(t *testing.T) {
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer webhook.Close()
+	defer close(release)
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &BackendContext{
+		hardware:          hw,
+		simulation:        &SimulationOptions{StepDelay: time.Millisecond},
+		runningParameters: map[string]string{"NOTIFY_WEBHOOK_URL": webhook.URL, "DISABLE_LUKS": "true"},
+		parameterSources:  map[string]config.ParameterSource{},
+		messages:          newOutputLog(),
+		ctx:               context.Background(),
+	}
+	mux := http.NewServeMux()
+	c.registerRoutes(mux, t.TempDir())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	api, err := client.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, err := api.Install(ctx, map[string]string{"DISK": "/dev/vda"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the webhook never answers, the log stream must still end with the installation
+	if err = api.Job(started.RunId).StreamLog(ctx, io.Discard); err != nil {
+		t.Errorf("StreamLog with a hanging webhook = %v", err)
+	}
+}
+
+// preflightHardware is the simulated machine with the facts the pre-flight checks look at
+type preflightHardware struct {
+	*hardware.Simulated
+	efi, tpm, battery, onAc bool
+	ramMb, diskGb           int64
+	mounts, missing         []string
+}
+
+func (h preflightHardware) HasEfi() (bool, error) { return h.efi, nil }
+func (h preflightHardware) HasTpm() bool          { return h.tpm }
+func (h preflightHardware) PowerSupply() (bool, bool) {
+	return h.battery, h.onAc
+}
+func (h preflightHardware) MemTotal() (int64, error)                 { return h.ramMb << 20, nil }
+func (h preflightHardware) DiskSize(string) (int64, error)           { return h.diskGb << 30, nil }
+func (h preflightHardware) DiskMountpoints(string) ([]string, error) { return h.mounts, nil }
+func (h preflightHardware) HasCommand(name string) bool {
+	return !slices.Contains(h.missing, name)
+}
+
+func TestPreflight(t *testing.T) {
+	simulated, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the simulation has the bootstrap image, the mirror is not contacted
+	good := preflightHardware{Simulated: simulated, efi: true, tpm: true, ramMb: 4096, diskGb: 64}
+	dir := t.TempDir()
+	image := map[string]string{"TARGET_TYPE": config.TargetImage, "DISK": filepath.Join(dir, "debian.img"), "IMAGE_SIZE": "20"}
+	tests := []struct {
+		name       string
+		change     func(h *preflightHardware)
+		parameters map[string]string
+		passed     bool
+		// statuses are the results of the checks by name, the ones not listed must pass
+		statuses map[string]PreflightStatus
+	}{
+		{"all good", nil, map[string]string{"DISK": "/dev/vda"}, true, nil},
+		{"on battery", func(h *preflightHardware) { h.battery = true }, map[string]string{"DISK": "/dev/vda"}, true,
+			map[string]PreflightStatus{"AC power": PreflightWarn}},
+		{"no disk", nil, map[string]string{}, false,
+			map[string]PreflightStatus{"Disk size": PreflightFail, "Disk not in use": PreflightFail}},
+		{"small disk", func(h *preflightHardware) { h.diskGb = 8 }, map[string]string{"DISK": "/dev/vda"}, false,
+			map[string]PreflightStatus{"Disk size": PreflightFail}},
+		{"smaller minimum", func(h *preflightHardware) { h.diskGb = 8 }, map[string]string{"DISK": "/dev/vda", "MIN_DISK_SIZE_GB": "8"}, true, nil},
+		{"little memory", func(h *preflightHardware) { h.ramMb = 512 }, map[string]string{"DISK": "/dev/vda"}, false,
+			map[string]PreflightStatus{"Memory": PreflightFail}},
+		{"bios", func(h *preflightHardware) { h.efi = false }, map[string]string{"DISK": "/dev/vda"}, false,
+			map[string]PreflightStatus{"EFI": PreflightFail}},
+		{"no tpm", func(h *preflightHardware) { h.tpm = false }, map[string]string{"DISK": "/dev/vda", "ENABLE_TPM": "true"}, false,
+			map[string]PreflightStatus{"TPM": PreflightFail}},
+		{"no tpm without luks", func(h *preflightHardware) { h.tpm = false },
+			map[string]string{"DISK": "/dev/vda", "ENABLE_TPM": "true", "DISABLE_LUKS": "true"}, true, nil},
+		{"mounted", func(h *preflightHardware) { h.mounts = []string{"/media/usb"} }, map[string]string{"DISK": "/dev/vda"}, false,
+			map[string]PreflightStatus{"Disk not in use": PreflightFail}},
+		{"no debootstrap", func(h *preflightHardware) { h.missing = []string{"debootstrap"} }, map[string]string{"DISK": "/dev/vda"}, false,
+			map[string]PreflightStatus{"Required tools": PreflightFail}},
+		{"image", func(h *preflightHardware) { h.diskGb, h.mounts = 0, []string{"/"} }, image, true, nil},
+		{"image without losetup", func(h *preflightHardware) { h.missing = []string{"losetup"} }, image, false,
+			map[string]PreflightStatus{"Required tools": PreflightFail}},
+		{"small image", nil, merged(image, map[string]string{"IMAGE_SIZE": "4"}), false,
+			map[string]PreflightStatus{"Disk size": PreflightFail}},
+		{"image in a missing directory", nil, merged(image, map[string]string{"DISK": filepath.Join(dir, "missing", "debian.img")}), false,
+			map[string]PreflightStatus{"Disk not in use": PreflightFail}},
+		{"image with tpm", nil, merged(image, map[string]string{"ENABLE_TPM": "true"}), false,
+			map[string]PreflightStatus{"TPM": PreflightFail}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hw := good
+			if test.change != nil {
+				test.change(&hw)
+			}
+			resp := runPreflightChecks(hw, test.parameters)
+			if resp.Passed != test.passed {
+				t.Errorf("Passed = %v; want %v in %+v", resp.Passed, test.passed, resp.Results)
+			}
+			for _, result := range resp.Results {
+				want, found := test.statuses[result.Name]
+				if !found {
+					want = PreflightPass
+				}
+				if result.Status != want {
+					t.Errorf("%s = %s (%s); want %s", result.Name, result.Status, result.Message, want)
+				}
+			}
+		})
+	}
+
+	// the query parameters override the pending ones
+	hw := good
+	hw.tpm = false
+	c := &BackendContext{
+		hardware:          hw,
+		runningParameters: map[string]string{"DISK": "/dev/vda", "ENABLE_TPM": "true"},
+		parameterSources:  map[string]config.ParameterSource{},
+		messages:          newOutputLog(),
+	}
+	mux := http.NewServeMux()
+	c.registerRoutes(mux, t.TempDir())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	api, err := client.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := api.Preflight(context.Background(), nil); err != nil || resp.Passed {
+		t.Errorf("Preflight of the pending parameters = %+v, %v; want the TPM check to fail", resp, err)
+	}
+	if resp, err := api.Preflight(context.Background(), map[string]string{"ENABLE_TPM": "false"}); err != nil || !resp.Passed {
+		t.Errorf("Preflight with ENABLE_TPM=false = %+v, %v; want it to pass", resp, err)
+	}
+}
+
+// merged returns a copy of parameters with the overrides
+func merged(parameters map[string]string, overrides map[string]string) map[string]string {
+	m := maps.Clone(parameters)
+	maps.Copy(m, overrides)
+	return m
+}
+
+func TestRunReport(t *testing.T) {
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatalf("Failed to load the fixtures: %v", err)
+	}
+	started := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	r := newRunReport("run", started, map[string]string{"DISK": "/dev/sda", "ROOT_PASSWORD": "secret", "ENABLE_MOK_SIGNED_UKI": "true"})
+	r.stepStarted("partition", started)
+	r.stepStarted("packages", started.Add(10*time.Second))
+	r.collect(hw, installer.Resources{}, map[string]string{"ENABLE_MOK_SIGNED_UKI": "true"})
+	r.finish(started.Add(70*time.Second), 0)
+
+	report := r.snapshot([]byte("Setting up foo ...\nW: Possible missing firmware x\nW: Possible missing firmware x\nwarning: password secret is weak\n"))
+	if report.Parameters["ROOT_PASSWORD"] == "secret" {
+		t.Errorf("ROOT_PASSWORD is not redacted")
+	}
+	if len(report.Steps) != 2 || report.Steps[0].Duration != 10 || report.Steps[1].Duration != 60 {
+		t.Errorf("Steps = %+v; want partition for 10s and packages for 60s", report.Steps)
+	}
+	if report.System == nil || report.System.SwapfileOffset != "533760" || len(report.System.Packages) == 0 {
+		t.Errorf("System = %+v; want the facts from installed.json", report.System)
+	}
+	if !strings.HasPrefix(report.MokEnrollment, "not pending") {
+		t.Errorf("MokEnrollment = %q; want not pending", report.MokEnrollment)
+	}
+	if !slices.Equal(report.Warnings, []string{"W: Possible missing firmware x", "warning: password *** is weak"}) {
+		t.Errorf("Warnings = %q; want the apt warning once and the redacted one", report.Warnings)
+	}
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	parameters := map[string]string{"LUKS_PASSWORD": "hunter2", "DISK": "/dev/vda"}
+	log := redactOutput([]byte("cryptsetup with hunter2 on /dev/vda\n"), parameters)
+	if bytes.Contains(log, []byte("hunter2")) {
+		t.Errorf("redactOutput() = %q; want the password replaced", log)
+	}
+
+	var b bytes.Buffer
+	files := map[string][]byte{"run.log": log, "repart.d/01_efi.conf": []byte("[Partition]\n")}
+	if err := writeDiagnostics(&b, "odi-diagnostics-x", files, time.Now()); err != nil {
+		t.Fatalf("Failed to write the diagnostics: %v", err)
+	}
+	gz, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatalf("Failed to read the diagnostics: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read the diagnostics: %v", err)
+		}
+		names = append(names, h.Name)
+	}
+	want := []string{"odi-diagnostics-x/repart.d/01_efi.conf", "odi-diagnostics-x/run.log"}
+	if !slices.Equal(names, want) {
+		t.Errorf("Names = %v; want %v", names, want)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	c := &BackendContext{runningParameters: map[string]string{"DEBIAN_VERSION": "trixie"}}
+	w := httptest.NewRecorder()
+	c.Clear(w, httptest.NewRequest(http.MethodGet, "/clear", nil))
+	resp := w.Result()
+	var apiErr *client.ApiError
+	if err := client.ResponseError(resp); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != client.ErrNotRunning {
+		t.Errorf("Clear with nothing running = %v; want %s", err, ErrNotRunning)
+	}
+
+	body := `{"DISK": "/dev/vda", "SWAP_SIZE": 2, "DISABLE_LUKS": true}`
+	r := httptest.NewRequest(http.MethodPost, "/install?dry_run=1", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	c.Install(w, r)
+	var plan installer.InstallPlan
+	if err := json.NewDecoder(w.Result().Body).Decode(&plan); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"DISK": "/dev/vda", "SWAP_SIZE": "2", "DISABLE_LUKS": "true", "DEBIAN_VERSION": "trixie"}
+	for k, v := range want {
+		if plan.Parameters[k] != v {
+			t.Errorf("Parameters[%s] = %q; want %q", k, plan.Parameters[k], v)
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/install?dry_run=1", strings.NewReader(`{"DISK": ["/dev/vda"]}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	c.Install(w, r)
+	if err := client.ResponseError(w.Result()); !errors.As(err, &apiErr) || apiErr.Code != client.ErrBadRequest || string(apiErr.Details) != `["DISK"]` {
+		t.Errorf("Install with a nested value = %v; want %s with details", err, ErrBadRequest)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/install", strings.NewReader(`{"DISK": "/dev/vda", "SWAP_SIZE": "many", "DISABLE_LUKS": true}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	c.Install(w, r)
+	err := client.ResponseError(w.Result())
+	if !errors.As(err, &apiErr) || apiErr.Code != client.ErrInvalidConfig ||
+		len(apiErr.ConfigErrors()) != 1 || apiErr.ConfigErrors()[0].Key != "SWAP_SIZE" {
+		t.Errorf("Install with an invalid value = %v; want %s for SWAP_SIZE", err, ErrInvalidConfig)
+	}
+}
+
+func TestBackendSettingsRefused(t *testing.T) {
+	c := &BackendContext{runningParameters: map[string]string{}, parameterSources: map[string]config.ParameterSource{}}
+	var apiErr *client.ApiError
+
+	r := httptest.NewRequest(http.MethodPost, "/install", strings.NewReader("DISK=/dev/vda&INSTALLER_RUNNER=script&INSTALLER_SCRIPT=/tmp/evil.sh"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	c.Install(w, r)
+	err := client.ResponseError(w.Result())
+	if !errors.As(err, &apiErr) || apiErr.Code != client.ErrInvalidConfig {
+		t.Fatalf("Install with INSTALLER_SCRIPT = %v; want %s", err, ErrInvalidConfig)
+	}
+	var keys []string
+	for _, configError := range apiErr.ConfigErrors() {
+		keys = append(keys, configError.Key)
+	}
+	if want := []string{"INSTALLER_RUNNER", "INSTALLER_SCRIPT"}; !slices.Equal(keys, want) {
+		t.Errorf("Refused keys = %v; want %v", keys, want)
+	}
+	if len(c.jobs) > 0 {
+		t.Errorf("Jobs = %d; want none", len(c.jobs))
+	}
+
+	r = httptest.NewRequest(http.MethodPut, "/config?format=yaml", strings.NewReader("HOSTNAME: pc1\nNOTIFY_COMMAND: touch /tmp/pwned\n"))
+	w = httptest.NewRecorder()
+	c.PutConfig(w, r)
+	if err = client.ResponseError(w.Result()); !errors.As(err, &apiErr) || apiErr.Code != client.ErrInvalidConfig {
+		t.Errorf("PutConfig with NOTIFY_COMMAND = %v; want %s", err, ErrInvalidConfig)
+	}
+	if len(c.parameters()) > 0 {
+		t.Errorf("Parameters = %v; want none from the refused request", c.parameters())
+	}
+}
+
+func TestApiRoutes(t *testing.T) {
+	c := &BackendContext{runningParameters: map[string]string{}}
+	mux := http.NewServeMux()
+	c.registerRoutes(mux, t.TempDir())
+
+	// only the endpoints of the first release keep their unversioned aliases
+	for _, test := range []struct {
+		path       string
+		code       int
+		deprecated bool
+	}{
+		{"/api/v1/auto_install", http.StatusOK, false},
+		{"/auto_install", http.StatusNotFound, false},
+		{"/process_status", http.StatusNotFound, true},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if w.Code != test.code {
+			t.Errorf("GET %s = %d; want %d", test.path, w.Code, test.code)
+		}
+		if deprecated := w.Header().Get("Deprecation") == "true"; deprecated != test.deprecated {
+			t.Errorf("GET %s Deprecation = %q; want %v", test.path, w.Header().Get("Deprecation"), test.deprecated)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	var document struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	body := w.Body.Bytes()
+	if err := json.Unmarshal(body, &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range c.apiRoutes() {
+		if _, found := document.Paths[apiPrefix+route.path][strings.ToLower(route.method)]; !found {
+			t.Errorf("%s %s is missing in the OpenAPI document", route.method, route.path)
+		}
+	}
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/(\w+)"`).FindAllSubmatch(body, -1) {
+		if _, found := document.Components.Schemas[string(ref[1])]; !found {
+			t.Errorf("schema %s is referenced but not defined", ref[1])
+		}
+	}
+	var model map[string]any
+	if err := json.Unmarshal(document.Components.Schemas["Model"], &model); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := model["properties"].(map[string]any)["DISK"]; !found {
+		t.Errorf("Model schema %v has no DISK property", model)
+	}
+}
+
+func TestClient(t *testing.T) {
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &BackendContext{
+		hardware:          hw,
+		simulation:        &SimulationOptions{StepDelay: time.Millisecond},
+		runningParameters: map[string]string{"API_TOKEN": "secret"},
+		parameterSources:  map[string]config.ParameterSource{},
+		messages:          newOutputLog(),
+		ctx:               context.Background(),
+	}
+	mux := http.NewServeMux()
+	c.registerRoutes(mux, t.TempDir())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	anonymous, err := client.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *client.ApiError
+	if _, err = anonymous.Login(ctx); !errors.As(err, &apiErr) || apiErr.Code != client.ErrUnauthorized {
+		t.Errorf("Login without a token = %v; want %s", err, client.ErrUnauthorized)
+	}
+
+	api, err := client.New(server.URL, client.WithToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err := api.Login(ctx)
+	if err != nil || !login.HasEfi {
+		t.Fatalf("Login = %+v, %v", login, err)
+	}
+	parameters := map[string]string{"DISK": "/dev/vda", "LUKS_PASSWORD": "luke", "USER_PASSWORD": "a", "ROOT_PASSWORD": "b"}
+	plan, err := api.DryRun(ctx, parameters)
+	if err != nil || plan.Parameters["DISK"] != "/dev/vda" {
+		t.Errorf("DryRun = %+v, %v", plan, err)
+	}
+	started, err := api.Install(ctx, parameters)
+	if err != nil || started.RunId == "" {
+		t.Fatalf("Install = %+v, %v", started, err)
+	}
+	if _, err = api.Install(ctx, parameters); !errors.As(err, &apiErr) || apiErr.Code != client.ErrAlreadyRunning {
+		t.Errorf("second Install = %v; want %s", err, client.ErrAlreadyRunning)
+	}
+	var log bytes.Buffer
+	if err = api.StreamLog(ctx, &log); err != nil {
+		t.Fatal(err)
+	}
+	status, err := api.ProcessStatus(ctx)
+	if err != nil || status.Status != client.StatusFinished || status.Failed() {
+		t.Errorf("ProcessStatus = %+v, %v", status, err)
+	}
+	if log.String() != status.Output {
+		t.Errorf("StreamLog wrote %d bytes; want the %d bytes of the output", log.Len(), len(status.Output))
+	}
+	if _, err = api.Report(ctx, started.RunId); err != nil {
+		t.Errorf("Report = %v", err)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &BackendContext{
+		hardware:          hw,
+		simulation:        &SimulationOptions{StepDelay: 20 * time.Millisecond},
+		runningParameters: map[string]string{"DISABLE_LUKS": "true"},
+		parameterSources:  map[string]config.ParameterSource{},
+		messages:          newOutputLog(),
+		ctx:               context.Background(),
+	}
+	mux := http.NewServeMux()
+	c.registerRoutes(mux, t.TempDir())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	api, err := client.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := api.Install(ctx, map[string]string{"DISK": "/dev/vda"})
+	if err != nil {
+		t.Fatalf("Install on /dev/vda = %v", err)
+	}
+	second, err := api.Install(ctx, map[string]string{"DISK": "/dev/vdb"})
+	if err != nil {
+		t.Fatalf("Install on /dev/vdb = %v", err)
+	}
+	var apiErr *client.ApiError
+	if _, err = api.Install(ctx, map[string]string{"DISK": "/dev/vda"}); !errors.As(err, &apiErr) || apiErr.Code != client.ErrAlreadyRunning {
+		t.Errorf("Install on /dev/vda again = %v; want %s", err, client.ErrAlreadyRunning)
+	}
+	jobs, err := api.Jobs(ctx)
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("Jobs = %+v, %v; want 2 jobs", jobs, err)
+	}
+	if jobs[0].Id != first.RunId || jobs[1].Id != second.RunId || jobs[0].Resources.Target == jobs[1].Resources.Target ||
+		jobs[0].Resources.LuksDevice == jobs[1].Resources.LuksDevice {
+		t.Errorf("Jobs = %+v; want both in the order they started, with their own resources", jobs)
+	}
+
+	for _, runId := range []string{first.RunId, second.RunId} {
+		job := api.Job(runId)
+		var log bytes.Buffer
+		if err = job.StreamLog(ctx, &log); err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.ProcessStatus(ctx)
+		if err != nil || status.RunId != runId || status.Status != client.StatusFinished || status.Output != log.String() {
+			t.Errorf("ProcessStatus of %s = %+v, %v; want the finished job with its own output", runId, status, err)
+		}
+	}
+	if _, err = api.Job(first.RunId).Cancel(ctx); !errors.As(err, &apiErr) || apiErr.Code != client.ErrNotRunning {
+		t.Errorf("Cancel of a finished job = %v; want %s", err, client.ErrNotRunning)
+	}
+	if result, err := api.Job(first.RunId).Clear(ctx); err != nil || result.Status != "CLEARED" {
+		t.Errorf("Clear = %+v, %v; want CLEARED", result, err)
+	}
+	if jobs, err = api.Jobs(ctx); err != nil || len(jobs) != 1 || jobs[0].Id != second.RunId {
+		t.Errorf("Jobs after Clear = %+v, %v; want the second job", jobs, err)
+	}
+	if _, err = api.Job(first.RunId).ProcessStatus(ctx); !errors.As(err, &apiErr) || apiErr.Code != client.ErrNotFound {
+		t.Errorf("ProcessStatus of a cleared job = %v; want %s", err, client.ErrNotFound)
+	}
+	if _, err = api.Install(ctx, map[string]string{"DISK": "/dev/vda"}); err != nil {
+		t.Errorf("Install on the disk of the cleared job = %v", err)
+	}
+}
+
+type remoteOutput struct {
+	bytes.Buffer
+}
+
+func (o *remoteOutput) StepStarted(string) {}
+
+func TestRemoteInstaller(t *testing.T) {
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &BackendContext{
+		hardware:          hw,
+		simulation:        &SimulationOptions{StepDelay: 20 * time.Millisecond},
+		runningParameters: map[string]string{"DISABLE_LUKS": "true"},
+		parameterSources:  map[string]config.ParameterSource{},
+		messages:          newOutputLog(),
+		ctx:               context.Background(),
+	}
+	mux := http.NewServeMux()
+	c.registerRoutes(mux, t.TempDir())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	api, err := client.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := installer.NewRemoteInstaller(server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Start(ctx, map[string]string{"DISK": "/dev/vda"}, &remoteOutput{}); err != nil {
+		t.Fatalf("Start = %v", err)
+	}
+	// the latest job of the remote back-end is not the proxied one
+	other, err := api.Install(ctx, map[string]string{"DISK": "/dev/vdb"})
+	if err != nil {
+		t.Fatalf("Install on /dev/vdb = %v", err)
+	}
+	if err = remote.Cancel(); err != nil {
+		t.Errorf("Cancel = %v", err)
+	}
+	_ = remote.Wait()
+	if finished, _ := remote.Result(); !finished {
+		t.Errorf("Result of the cancelled installation is not finished")
+	}
+
+	job := api.Job(other.RunId)
+	if err = job.StreamLog(ctx, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := job.ProcessStatus(ctx); err != nil || status.ReturnCode != 0 {
+		t.Errorf("ProcessStatus of the other job = %+v, %v; want it to succeed", status, err)
+	}
+}
+
+func TestClearVerifying(t *testing.T) {
+	fake := &installer.FakeInstaller{}
+	if err := fake.Start(context.Background(), map[string]string{}, &remoteOutput{}); err != nil {
+		t.Fatal(err)
+	}
+	_ = fake.Wait()
+	j := &job{id: "verifying", installer: fake, report: newRunReport("verifying", time.Now(), map[string]string{}), output: newOutputLog()}
+	j.report.startVerification()
+	c := &BackendContext{jobs: []*job{j}}
+
+	w := httptest.NewRecorder()
+	c.Clear(w, httptest.NewRequest(http.MethodPost, "/clear", nil))
+	var apiErr *client.ApiError
+	if err := client.ResponseError(w.Result()); !errors.As(err, &apiErr) || apiErr.Code != client.ErrConflict || !c.hasJobs() {
+		t.Errorf("Clear while verifying = %v; want %s and the job kept", err, ErrConflict)
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	// the remote back-end takes its time to accept the installation
+	release := make(chan struct{})
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		writeError(w, http.StatusServiceUnavailable, ErrInternal, "not today", nil)
+	}))
+	defer remote.Close()
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &BackendContext{hardware: hw, messages: newOutputLog(), ctx: context.Background()}
+	started := make(chan error)
+	go func() {
+		_, err := c.startJob(map[string]string{"DISK": "/dev/vda", "INSTALLER_RUNNER": config.RunnerRemote, "INSTALLER_REMOTE_URL": remote.URL})
+		started <- err
+	}()
+	for !c.hasJobs() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the other requests are answered while it starts, and its disk stays reserved
+	w := httptest.NewRecorder()
+	c.GetJobs(w, httptest.NewRequest(http.MethodGet, "/jobs", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("GetJobs while a job starts = %d %s; want no jobs yet", w.Code, w.Body.String())
+	}
+	var apiErr *ApiError
+	if _, err = c.startJob(map[string]string{"DISK": "/dev/vda", "INSTALLER_RUNNER": config.RunnerSimulated}); !errors.As(err, &apiErr) || apiErr.Code != ErrAlreadyRunning {
+		t.Errorf("startJob on the same disk = %v; want %s", err, ErrAlreadyRunning)
+	}
+	close(release)
+	if err = <-started; err == nil {
+		t.Errorf("startJob with a refusing remote back-end = nil; want an error")
+	}
+	if c.hasJobs() {
+		t.Errorf("The failed job still holds its resources")
+	}
+}
+
+func TestImageStartFailure(t *testing.T) {
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &BackendContext{hardware: hw, messages: newOutputLog(), ctx: context.Background()}
+	dir := t.TempDir()
+	events := filepath.Join(dir, "events.jsonl")
+	_, err = c.startJob(map[string]string{
+		"INSTALLER_RUNNER": config.RunnerScript,
+		"INSTALLER_SCRIPT": "/bin/true",
+		"TARGET_TYPE":      config.TargetImage,
+		"DISK":             filepath.Join(dir, "missing", "debian.img"),
+		"IMAGE_SIZE":       "1",
+		"NOTIFY_FILE":      events,
+	})
+	if err == nil || c.hasJobs() {
+		t.Fatalf("startJob with an image in a missing directory = %v, %d jobs; want an error and no job", err, len(c.jobs))
+	}
+	data, err := os.ReadFile(events)
+	if err != nil || !strings.Contains(string(data), `"event":"failed"`) {
+		t.Errorf("Notifications = %s, %v; want the failed event", data, err)
+	}
+}
+
+// mediaHardware has a usb stick mounted on dir
+type mediaHardware struct {
+	*hardware.Simulated
+	dir string
+}
+
+func (h mediaHardware) MediaLocations() []string {
+	return []string{"/boot/efi", h.dir}
+}
+
+func TestMediaFiles(t *testing.T) {
+	hw, err := hardware.NewSimulated("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	c := &BackendContext{hardware: mediaHardware{hw, dir}, messages: newOutputLog()}
+	mux := http.NewServeMux()
+	c.registerRoutes(mux, t.TempDir())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	ctx := context.Background()
+	api, err := client.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if locations, err := api.MediaLocations(ctx); err != nil || !slices.Equal(locations, []string{"/boot/efi", dir}) {
+		t.Errorf("MediaLocations = %v, %v; want the esp and the usb stick", locations, err)
+	}
+	if err = api.WriteMediaFile(ctx, dir, "odi-preseed.ini", []byte("DISK=/dev/vda\n")); err != nil {
+		t.Fatalf("WriteMediaFile = %v", err)
+	}
+	if data, err := api.ReadMediaFile(ctx, dir, "odi-preseed.ini"); err != nil || string(data) != "DISK=/dev/vda\n" {
+		t.Errorf("ReadMediaFile = %q, %v; want the saved preseed", data, err)
+	}
+	var apiErr *client.ApiError
+	if _, err = api.ReadMediaFile(ctx, dir, "missing.ini"); !errors.As(err, &apiErr) || apiErr.Code != client.ErrNotFound {
+		t.Errorf("ReadMediaFile of a missing file = %v; want %s", err, client.ErrNotFound)
+	}
+	for _, file := range [][2]string{{dir, "../escaped"}, {dir, ".."}, {"/etc", "passwd"}} {
+		if err = api.WriteMediaFile(ctx, file[0], file[1], []byte("x")); !errors.As(err, &apiErr) || apiErr.Code != client.ErrBadRequest {
+			t.Errorf("WriteMediaFile to %s %s = %v; want %s", file[0], file[1], err, client.ErrBadRequest)
+		}
+	}
+}
+
+// imageInstaller is a finished installation that left the disk image in path
+type imageInstaller struct {
+	installer.FakeInstaller
+	path string
+}
+
+func (i *imageInstaller) Image() (string, bool) {
+	return i.path, true
+}
+
+func TestDownloadImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "template.qcow2")
+	if err := os.WriteFile(path, []byte("QFI\xfb"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := &BackendContext{
+		messages: newOutputLog(),
+		jobs: []*job{
+			{id: "image", installer: &imageInstaller{path: path}},
+			{id: "disk", installer: &installer.FakeInstaller{}},
+		},
+	}
+	mux := http.NewServeMux()
+	c.registerRoutes(mux, t.TempDir())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	api, err := client.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var image bytes.Buffer
+	fileName, err := api.Job("image").DownloadImage(context.Background(), &image)
+	if err != nil || fileName != "template.qcow2" || image.String() != "QFI\xfb" {
+		t.Errorf("DownloadImage = %s, %q, %v; want the image file", fileName, image.String(), err)
+	}
+	var apiErr *client.ApiError
+	if _, err = api.Job("disk").DownloadImage(context.Background(), io.Discard); !errors.As(err, &apiErr) || apiErr.Code != client.ErrNotFound {
+		t.Errorf("DownloadImage of a disk installation = %v; want %s", err, client.ErrNotFound)
+	}
+}
+
+// TestClientTypes checks that the client decodes what the handlers send
+
+func TestClientTypes(t *testing.T) {
+	pairs := []struct{ server, client any }{
+		{LoginResponse{}, client.LoginResponse{}},
+		{PreflightResponse{}, client.PreflightResponse{}},
+		{installer.InstallPlan{}, client.InstallPlan{}},
+		{ProcessStatusResponse{}, client.ProcessStatus{}},
+		{InstallReport{}, client.InstallReport{}},
+		{Preset{}, client.Preset{}},
+		{installer.StepResult{}, client.StepResult{}},
+		{ActionResult{}, client.ActionResult{}},
+		{JobStatus{}, client.JobStatus{}},
+		{ErrorEnvelope{}, client.ErrorEnvelope{}},
+	}
+	serverSchemas, clientSchemas := make(map[string]any), make(map[string]any)
+	for _, pair := range pairs {
+		s := structSchema(reflect.TypeOf(pair.server), serverSchemas)
+		c := structSchema(reflect.TypeOf(pair.client), clientSchemas)
+		if !reflect.DeepEqual(s, c) {
+			t.Errorf("%T = %v; want %v like %T", pair.client, c, s, pair.server)
+		}
+	}
+	for name, c := range clientSchemas {
+		if s := serverSchemas[name]; !reflect.DeepEqual(s, c) {
+			t.Errorf("client.%s = %v; want %v", name, c, s)
+		}
+	}
+}
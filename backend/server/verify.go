@@ -0,0 +1,65 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/installer"
+	"log/slog"
+)
+
+// verifyInstallation mounts the installed system again and reports the checks to the output
+func (j *job) verifyInstallation() []installer.VerificationCheck {
+	_, _ = fmt.Fprintf(j, "verifying the installed system\n")
+	checks := j.runVerification()
+	for _, check := range checks {
+		result := "PASS"
+		if !check.Passed {
+			result = "FAIL"
+		}
+		slog.Info("verification", "check", check.Name, "passed", check.Passed, "message", check.Message)
+		_, _ = fmt.Fprintf(j, "verification %s %s: %s\n", result, check.Name, check.Message)
+	}
+	return checks
+}
+
+func (j *job) runVerification() []installer.VerificationCheck {
+	hw := j.backend.hardware
+	system := j.report.snapshot(nil).System
+	if system == nil {
+		return []installer.VerificationCheck{installer.Failed("identifiers", "the identifiers of the installed system are not known")}
+	}
+	root, unmount, err := hw.MountInstalledSystem(j.resources, j.parameters)
+	if err != nil {
+		return []installer.VerificationCheck{installer.Failed("mount", "%v", err)}
+	}
+	defer func() {
+		if err := unmount(); err != nil {
+			slog.Warn("failed to unmount the installed system", "error", err)
+		}
+	}()
+	ids := installer.Identifiers{
+		EfiPartUuid:    system.EfiPartUuid,
+		MainPartUuid:   system.MainPartUuid,
+		BtrfsUuid:      system.BtrfsUuid,
+		SwapfileOffset: system.SwapfileOffset,
+	}
+	return append([]installer.VerificationCheck{installer.Passed("mount", "%s", j.resources.Target)},
+		installer.VerifyInstalledSystem(root, j.parameters, ids, hw.ResolveDevice)...)
+}
@@ -0,0 +1,150 @@
+package server
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// outputLog keeps the output of an installation and sends it to the connected websockets
+type outputLog struct {
+	lock       sync.Mutex
+	buf        bytes.Buffer
+	websockets map[string]*websocket.Conn
+	wsHandlers map[string]chan string
+	// finished is set when the installation finished, the websockets connecting later only get the output so far
+	finished bool
+}
+
+func newOutputLog() *outputLog {
+	return &outputLog{websockets: make(map[string]*websocket.Conn), wsHandlers: make(map[string]chan string)}
+}
+
+func (o *outputLog) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.buf.Write(p)
+
+	slog.Debug("writing a message to all web sockets", "data", p)
+	for name, ws := range o.websockets {
+		_, err := ws.Write(p)
+		if err != nil {
+			slog.Warn("failed to write to websocket, closing", "socket_addr", name, "error", err)
+			o.closeWebSocket(name)
+		}
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the output so far
+func (o *outputLog) Bytes() []byte {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return bytes.Clone(o.buf.Bytes())
+}
+
+// addWebsocket sends the output so far to the websocket and then every new output,
+// the returned channel gets the name of the websocket when it is closed. It is nil after the installation finished.
+func (o *outputLog) addWebsocket(ws *websocket.Conn) (chan string, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if _, err := ws.Write(o.buf.Bytes()); err != nil || o.finished {
+		return nil, err
+	}
+	name := uuid.New().String()
+	o.websockets[name] = ws
+	n := make(chan string)
+	o.wsHandlers[name] = n
+	return n, nil
+}
+
+// reset forgets the output so far, so that the log can be used for the next installation
+func (o *outputLog) reset() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.buf.Reset()
+	o.finished = false
+}
+
+func (o *outputLog) closeWebSocket(name string) {
+	done := o.wsHandlers[name]
+	done <- name
+	delete(o.websockets, name)
+	delete(o.wsHandlers, name)
+}
+
+// finish closes the websockets when the installation finished
+func (o *outputLog) finish() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.finished = true
+	for name := range o.websockets {
+		o.closeWebSocket(name)
+	}
+}
+
+// GetProcessOutput streams the output of the job in the job query parameter, or of the latest one.
+// Without any job, it streams the messages of the automatic installation, which become the output of its job.
+func (c *BackendContext) GetProcessOutput(ws *websocket.Conn) {
+	slog.Debug("new websocket connected", "addr", ws.RemoteAddr().String())
+	output := c.messages
+	id := ws.Request().URL.Query().Get("job")
+	if j := c.findJob(id); j != nil {
+		output = j.output
+	} else if id != "" {
+		slog.Warn("no such job", "job", id)
+		return
+	}
+	done, err := output.addWebsocket(ws)
+	if err != nil {
+		slog.Warn("failed to write existing buffer to the new socket", "error", err)
+		return
+	}
+	if done == nil {
+		slog.Debug("the installation already finished, closing websocket connection")
+		return
+	}
+	go c.readWebsocket(ws)
+	name := <-done
+	slog.Debug("closing websocket connection", "name", name)
+}
+
+// readWebsocket handles the messages from the clients, "abort" stops the countdown of the automatic installation
+func (c *BackendContext) readWebsocket(ws *websocket.Conn) {
+	buf := make([]byte, 64)
+	for {
+		n, err := ws.Read(buf)
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(string(buf[:n])) == "abort" {
+			c.autoInstall.Abort()
+		}
+	}
+}
+
+// Write sends the messages of the back-end, like the countdown of the automatic installation, to the websockets
+func (c *BackendContext) Write(p []byte) (int, error) {
+	return c.messages.Write(p)
+}
@@ -0,0 +1,106 @@
+package tui
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/rivo/tview"
+	"time"
+)
+
+// jobsViewHeight fits the header of the job list and three jobs, it scrolls with more
+const jobsViewHeight = 4
+
+const jobsPollInterval = 2 * time.Second
+
+// jobRows are the cells of the job list, the header first
+func jobRows(jobs []client.JobStatus) [][]string {
+	rows := [][]string{{"Job", "Disk", "Status", "Progress", "Target"}}
+	for _, j := range jobs {
+		status := j.Status
+		if j.Status == client.StatusFinished && j.ReturnCode != 0 {
+			status = fmt.Sprintf("FAILED (%d)", j.ReturnCode)
+		}
+		progress := ""
+		if j.Progress.Total > 0 {
+			progress = fmt.Sprintf("%d/%d %s", j.Progress.Current, j.Progress.Total, j.Progress.Step)
+		}
+		id := j.Id
+		if len(id) > 8 {
+			id = id[:8]
+		}
+		rows = append(rows, []string{id, j.Disk, status, progress, j.Resources.Target})
+	}
+	return rows
+}
+
+// newJobTable lists the jobs of the back-end, onSelected is called with the id of the job chosen with enter or a click
+func newJobTable(onSelected func(id string, disk string)) *tview.Table {
+	table := tview.NewTable().
+		SetSelectable(true, false).
+		SetFixed(1, 0)
+	table.SetSelectedFunc(func(row int, _ int) {
+		// the text of the cells is padded and escaped, the reference has the values from the back-end
+		if j, ok := table.GetCell(row, 0).GetReference().(client.JobStatus); ok {
+			onSelected(j.Id, j.Disk)
+		}
+	})
+	showJobs(table, nil)
+	return table
+}
+
+func showJobs(table *tview.Table, jobs []client.JobStatus) {
+	table.Clear()
+	for r, cells := range jobRows(jobs) {
+		for c, text := range cells {
+			cell := tview.NewTableCell(" " + tview.Escape(text)).SetExpansion(1)
+			if r == 0 {
+				cell.SetSelectable(false).SetAttributes(tcell.AttrBold)
+			} else {
+				cell.SetReference(jobs[r-1])
+			}
+			table.SetCell(r, c, cell)
+		}
+	}
+	if len(jobs) == 0 {
+		table.SetCell(1, 0, tview.NewTableCell(" No installation started yet").SetSelectable(false))
+	}
+}
+
+// watchJobs refreshes the job list until ctx is done
+func watchJobs(ctx context.Context, api *client.Client, app *tview.Application, table *tview.Table) {
+	go func() {
+		for {
+			jobs, err := api.Jobs(ctx)
+			if err == nil {
+				app.QueueUpdateDraw(func() {
+					showJobs(table, jobs)
+				})
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(jobsPollInterval):
+			}
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package tui
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/config"
+	"io"
+)
+
+// Model is what the text mode interface edits, the installation parameters and where they came from
+type Model struct {
+	client.Model
+	// Sources and ConfigErrors describe where the parameters came from, they are not sent back
+	Sources      map[string]client.ParameterSource `json:"-"`
+	ConfigErrors []client.ConfigError              `json:"-"`
+	AutoInstall  client.AutoInstallState           `json:"-"`
+}
+
+// modelFromLogin takes the values of the model from the pending parameters of the back-end
+func modelFromLogin(login client.LoginResponse) (Model, error) {
+	var m Model
+	environ, err := json.Marshal(login.Environ)
+	if err != nil {
+		return Model{}, err
+	}
+	if err = json.Unmarshal(environ, &m); err != nil {
+		return Model{}, err
+	}
+	m.Sources = login.ParameterSources
+	m.ConfigErrors = login.ConfigErrors
+	m.AutoInstall = login.AutoInstall
+	return m, nil
+}
+
+func parseLoginJson(data io.Reader) (Model, error) {
+	var login client.LoginResponse
+	err := json.NewDecoder(data).Decode(&login)
+	if err != nil {
+		return Model{}, err
+	}
+	return modelFromLogin(login)
+}
+
+func parseLsblkJson(data io.Reader) (client.BlockDevices, error) {
+	var devices client.BlockDevices
+	err := json.NewDecoder(data).Decode(&devices)
+	if err != nil {
+		return client.BlockDevices{}, err
+	}
+	return devices, nil
+}
+
+func getTimeZoneOffset(tz string) int {
+	return getSliceIndex(tz, config.Timezones)
+}
+
+func getSliceIndex(what string, where []string) int {
+	for i, t := range where {
+		if what == t {
+			return i
+		}
+	}
+	return 0
+}
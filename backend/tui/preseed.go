@@ -0,0 +1,119 @@
+package tui
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/rivo/tview"
+)
+
+const defaultPreseedFileName = "odi-preseed.ini"
+
+// mediaLocations are the esp and the usb sticks of the back-end, the tui does not run as root
+// and saves and loads the files through the back-end
+func mediaLocations(ctx context.Context, api *client.Client) []string {
+	locations, err := api.MediaLocations(ctx)
+	if err != nil || len(locations) == 0 {
+		return []string{"/boot/efi"}
+	}
+	return locations
+}
+
+func (m *Model) parameters() map[string]string {
+	parameters := make(map[string]string)
+	for k, v := range m.formValues() {
+		parameters[k] = v[0]
+	}
+	return parameters
+}
+
+func (m *Model) savePreseed(ctx context.Context, api *client.Client, location string, fileName string, includeSecrets bool) error {
+	out, err := config.Render(m.parameters(), config.FormatOf(fileName), includeSecrets)
+	if err != nil {
+		return err
+	}
+	return api.WriteMediaFile(ctx, location, fileName, out)
+}
+
+// makePreseedForm asks where to save the answers to or load them from. onLoaded is called after the back-end
+// accepted the loaded file.
+func makePreseedForm(ctx context.Context, api *client.Client, m *Model, log io.Writer, onLoaded func(), onClose func()) *tview.Form {
+	locations := mediaLocations(ctx, api)
+	location := locations[0]
+	fileName := defaultPreseedFileName
+	includeSecrets := false
+	form := tview.NewForm().
+		AddDropDown("Location", locations, 0, func(option string, _ int) {
+			location = option
+		}).
+		AddInputField("File Name", fileName, 0, nil, func(text string) {
+			fileName = text
+		}).
+		AddCheckbox("Include Passwords", includeSecrets, func(checked bool) {
+			includeSecrets = checked
+		})
+	form.AddButton("Save Preseed", func() {
+		path := filepath.Join(location, fileName)
+		err := m.savePreseed(ctx, api, location, fileName, includeSecrets)
+		if err != nil {
+			LOG(log, "Failed to save preseed: %v", err)
+			return
+		}
+		LOG(log, "Saved preseed to %s", path)
+		onClose()
+	}).
+		AddButton("Load Preseed", func() {
+			path := filepath.Join(location, fileName)
+			data, err := api.ReadMediaFile(ctx, location, fileName)
+			if err != nil {
+				LOG(log, "Failed to load preseed: %v", err)
+				return
+			}
+			configErrors, err := putConfig(ctx, api, data, config.FormatOf(path))
+			if err != nil {
+				LOG(log, "Failed to load preseed: %v", err)
+				return
+			}
+			if len(configErrors) > 0 {
+				for _, configError := range configErrors {
+					LOG(log, "%s: %s", path, configError.Error())
+				}
+				return
+			}
+			LOG(log, "Loaded preseed from %s", path)
+			onLoaded()
+		}).
+		AddButton("Remember These Settings", func() {
+			err := api.RememberConfig(ctx, m.parameters())
+			if err != nil {
+				LOG(log, "Failed to remember the settings: %v", err)
+				return
+			}
+			LOG(log, "Settings remembered in installer.ini, passwords were not saved")
+			onClose()
+		}).
+		AddButton("Close", onClose)
+	form.SetBorder(true).SetTitle("Preseed")
+	return form
+}
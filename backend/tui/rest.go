@@ -0,0 +1,144 @@
+package tui
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/config"
+	"io"
+	"net/url"
+)
+
+func loginToBackend(ctx context.Context, api *client.Client) (Model, error) {
+	login, err := api.Login(ctx)
+	if err != nil {
+		return Model{}, err
+	}
+	return modelFromLogin(login)
+}
+
+func getAvailableDrives(ctx context.Context, api *client.Client) ([]string, []string, error) {
+	devices, err := api.BlockDevices(ctx)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+	var drives []string
+	var driveDescriptions []string
+	for _, device := range devices.Blockdevices {
+		drives = append(drives, device.Path)
+		driveDescription := fmt.Sprintf("%s %s (%s)", device.Path, device.Model, device.Size)
+		driveDescriptions = append(driveDescriptions, driveDescription)
+	}
+	return drives, driveDescriptions, nil
+}
+
+func (m *Model) preflight(ctx context.Context, api *client.Client) (client.PreflightResponse, error) {
+	return api.Preflight(ctx, map[string]string{
+		"DISK":           m.Disk,
+		"TARGET_TYPE":    m.TargetType,
+		"IMAGE_SIZE":     m.ImageSize,
+		"DEBIAN_VERSION": m.DebianVersion,
+		"DISABLE_LUKS":   m.DisableLuks,
+		"ENABLE_TPM":     m.EnableTpm,
+	})
+}
+
+// processOutput copies the installer output to log, onFinished is called when the installer finishes.
+// Cancelling ctx stops following the output quietly.
+func processOutput(ctx context.Context, api *client.Client, log io.Writer, onFinished func()) {
+	go func() {
+		err := api.StreamLog(ctx, log)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			LOG(log, "Error reading the installer output: %v", err)
+			return
+		}
+		LOG(log, "Finished")
+		onFinished()
+	}()
+}
+
+func (m *Model) formValues() url.Values {
+	post := url.Values{}
+
+	post.Set("DISK", m.Disk)
+	post.Set("TARGET_TYPE", m.TargetType)
+	// the image settings are ignored for a disk, leave them out of the saved preseeds
+	if m.TargetType == config.TargetImage {
+		post.Set("IMAGE_SIZE", m.ImageSize)
+		post.Set("IMAGE_FORMAT", m.ImageFormat)
+	}
+	post.Set("DEBIAN_VERSION", m.DebianVersion)
+	post.Set("USERNAME", m.Username)
+	post.Set("USER_FULL_NAME", m.UserFullName)
+	post.Set("USER_PASSWORD", m.UserPassword)
+	post.Set("ROOT_PASSWORD", m.RootPassword)
+	post.Set("DISABLE_LUKS", m.DisableLuks)
+	post.Set("LUKS_PASSWORD", m.LuksPassword)
+	post.Set("ENABLE_MOK_SIGNED_UKI", m.EnableMokUki)
+	post.Set("MOK_ENROLL_PASSWORD", m.MokPassword)
+	post.Set("ENABLE_TPM", m.EnableTpm)
+	post.Set("HOSTNAME", m.Hostname)
+	post.Set("TIMEZONE", m.Timezone)
+	post.Set("SWAP_SIZE", m.SwapSize)
+	post.Set("NVIDIA_PACKAGE", m.NvidiaPackage)
+	post.Set("ENABLE_FLATHUB", m.EnableFlathub)
+	post.Set("ENABLE_POPCON", m.EnablePopcon)
+	return post
+}
+
+// startInstallation starts a job on the disk of the model and returns its run ID
+func (m *Model) startInstallation(ctx context.Context, api *client.Client, log io.Writer) (string, error) {
+	started, err := api.Install(ctx, m.parameters())
+	if err != nil {
+		return "", err
+	}
+	LOG(log, "Installation %s started on %s", started.RunId, m.Disk)
+	return started.RunId, nil
+}
+
+// putConfig sends a preseed file to the back-end, the returned ConfigErrors are the reasons it was refused
+func putConfig(ctx context.Context, api *client.Client, data []byte, format config.ConfigFormat) ([]client.ConfigError, error) {
+	err := api.PutConfig(ctx, data, string(format))
+	var apiErr *client.ApiError
+	if errors.As(err, &apiErr) && apiErr.Code == client.ErrInvalidConfig {
+		return apiErr.ConfigErrors(), nil
+	}
+	return nil, err
+}
+
+// getReport downloads the report of the installation the client follows and returns it with its run ID
+func getReport(ctx context.Context, api *client.Client) ([]byte, string, error) {
+	status, err := api.ProcessStatus(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	report, err := api.Report(ctx, status.RunId)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	return data, status.RunId, err
+}
@@ -0,0 +1,533 @@
+// Package tui is the text mode interface, it drives a back-end through the client package.
+package tui
+
+/*
+Opinionated Debian Installer
+Copyright (C) 2022-2025 Robert T.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/r0b0/debian-installer/backend/config"
+	"github.com/r0b0/debian-installer/backend/hardware"
+	"github.com/r0b0/debian-installer/backend/widgets"
+	"github.com/rivo/tview"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// preflightViewHeight fits the pre-flight checks of the back-end, one per line, and the summary
+const preflightViewHeight = 9
+
+// autoInstallPollInterval is how often the countdown of the automatic installation is checked
+const autoInstallPollInterval = time.Second
+
+func LOG(l io.Writer, format string, args ...any) {
+	_, _ = l.Write([]byte(fmt.Sprintf(format+"\n", args...)))
+}
+
+func Tui(baseUrlString *string, token *string) {
+	api, err := client.New(*baseUrlString, client.WithToken(*token))
+	if err != nil {
+		panic(err)
+	}
+	// loading a preseed changes the configuration in the back-end, start over to show the new values
+	for runTui(api) {
+	}
+}
+
+func runTui(api *client.Client) (reload bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	devices, deviceNames, err := getAvailableDrives(ctx, api)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to get available drives from back-end: %v", err))
+	}
+
+	m, err := loginToBackend(ctx, api)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to get configuration from back-end: %v", err))
+	}
+
+	greenColour := tcell.NewRGBColor(0x51, 0xa1, 0xd0)
+
+	app := tview.NewApplication()
+	rootPages := tview.NewPages()
+
+	logView := tview.NewTextView().
+		SetScrollable(true).
+		ScrollToEnd().
+		SetLabelWidth(10).
+		SetLabel(" Log").
+		SetChangedFunc(func() {
+			app.Draw()
+		})
+
+	showConfigSources(logView, &m)
+
+	dataOk := true
+
+	diskForm := tview.NewForm()
+	presets, err := api.Presets(ctx)
+	if err != nil {
+		LOG(logView, "Failed to get presets from back-end: %v", err)
+	}
+	if len(presets) > 0 {
+		options := []string{"(none)"}
+		for _, p := range presets {
+			options = append(options, fmt.Sprintf("%s %s (%s)", p.Label, p.Device, p.File))
+			for _, configError := range p.Errors {
+				LOG(logView, "Preset error: %s", configError.Error())
+			}
+		}
+		diskForm.AddDropDown("Preset", options, 0, func(_ string, optionIndex int) {
+			if optionIndex <= 0 {
+				return
+			}
+			err := api.ApplyPreset(ctx, presets[optionIndex-1].Id)
+			if err != nil {
+				LOG(logView, "Failed to apply preset: %v", err)
+				return
+			}
+			// start over to show the values of the preset
+			reload = true
+			app.Stop()
+		})
+	}
+	// the device and the image file are both the DISK, the one of the selected target is sent
+	diskDevice, imageFile := m.Disk, ""
+	if m.TargetType == config.TargetImage {
+		diskDevice, imageFile = "", m.Disk
+	}
+	targetTypes := []string{config.TargetDisk, config.TargetImage}
+	imageFormats := []string{config.ImageRaw, config.ImageQcow2}
+	diskForm.AddDropDown("Target", []string{"Disk", "Disk image file"}, getSliceIndex(m.TargetType, targetTypes), func(_ string, optionIndex int) {
+		m.TargetType = targetTypes[optionIndex]
+		if m.TargetType == config.TargetImage {
+			m.Disk = imageFile
+		} else {
+			m.Disk = diskDevice
+		}
+	}).
+		AddDropDown("Device", deviceNames, getSliceIndex(diskDevice, devices), func(_ string, optionIndex int) {
+			diskDevice = devices[optionIndex]
+			if m.TargetType != config.TargetImage {
+				m.Disk = diskDevice
+			}
+		}).
+		AddInputField("Image File", imageFile, 0, nil, func(text string) {
+			imageFile = text
+			if m.TargetType == config.TargetImage {
+				m.Disk = imageFile
+			}
+		}).
+		AddInputField("Image Size (GB)", m.ImageSize, 0, func(textToCheck string, lastChar rune) bool {
+			_, err := strconv.Atoi(textToCheck)
+			return err == nil
+		}, func(text string) {
+			m.ImageSize = text
+		}).
+		AddDropDown("Image Format", imageFormats, getSliceIndex(m.ImageFormat, imageFormats), func(option string, _ int) {
+			m.ImageFormat = option
+		}).
+		AddCheckbox("Disable Encryption", m.DisableLuks == "true", func(checked bool) {
+			if checked {
+				m.DisableLuks = "true"
+				// the tpm unlocks the encrypted disk, the back-end refuses both together
+				m.EnableTpm = "false"
+				if tpm, ok := diskForm.GetFormItemByLabel("Unlock with TPM").(*tview.Checkbox); ok {
+					tpm.SetChecked(false)
+				}
+			} else {
+				m.DisableLuks = "false"
+			}
+		})
+	widgets.AddPasswordToForm(diskForm, "Disk Encryption Passphrase", m.LuksPassword, func(text string) {
+		m.LuksPassword = text
+	}, func(valid bool) {
+		dataOk = valid
+	})
+	diskForm.AddCheckbox("Unlock with TPM", m.EnableTpm == "true", func(checked bool) {
+		if checked {
+			m.EnableTpm = "true"
+		} else {
+			m.EnableTpm = "false"
+		}
+	})
+
+	usersForm := tview.NewForm()
+	widgets.AddPasswordToForm(usersForm, "Root Password", m.RootPassword, func(text string) {
+		m.RootPassword = text
+	}, func(valid bool) {
+		dataOk = valid
+	})
+	usersForm.AddInputField("Regular User Name", m.Username, 0, nil, func(text string) {
+		m.Username = text
+	}).
+		AddInputField("Full Name", m.UserFullName, 0, nil, func(text string) {
+			m.UserFullName = text
+		})
+	widgets.AddPasswordToForm(usersForm, "Regular User Password", m.UserPassword, func(text string) {
+		m.UserPassword = text
+	}, func(valid bool) {
+		dataOk = valid
+	})
+
+	configForm := tview.NewForm().
+		AddInputField("Hostname", m.Hostname, 0, nil, func(text string) {
+			m.Hostname = text
+		}).
+		AddDropDown("Time Zone", config.Timezones, getTimeZoneOffset(m.Timezone), func(option string, _ int) {
+			m.Timezone = option
+		}).
+		AddInputField("Swap Size", m.SwapSize, 0, func(textToCheck string, lastChar rune) bool {
+			_, err := strconv.Atoi(textToCheck)
+			return err == nil
+		}, func(text string) {
+			m.SwapSize = text
+		}).
+		AddCheckbox("Enable NVIDIA", m.NvidiaPackage == "nvidia-driver", func(checked bool) {
+			if checked {
+				m.NvidiaPackage = "nvidia-driver"
+			} else {
+				m.NvidiaPackage = ""
+			}
+		}).
+		AddCheckbox("Enable Flathub", m.EnableFlathub == "true", func(checked bool) {
+			if checked {
+				m.EnableFlathub = "true"
+			} else {
+				m.EnableFlathub = "false"
+			}
+		}).
+		AddCheckbox("Enable Popcon", m.EnablePopcon == "true", func(checked bool) {
+			if checked {
+				m.EnablePopcon = "true"
+			} else {
+				m.EnablePopcon = "false"
+			}
+		})
+
+	secureBootForm := tview.NewForm().
+		AddCheckbox("MOK-Signed UKI", m.EnableMokUki == "true", func(checked bool) {
+			if checked {
+				m.EnableMokUki = "true"
+			} else {
+				m.EnableMokUki = "false"
+			}
+		})
+	widgets.AddPasswordToForm(secureBootForm, "MOK Password", m.MokPassword, func(text string) {
+		m.MokPassword = text
+	}, func(valid bool) {
+		dataOk = valid
+	})
+
+	preflightView := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(" Pre-flight checks not run yet")
+
+	runPreflight := func() bool {
+		preflight, err := m.preflight(ctx, api)
+		if err != nil {
+			LOG(logView, "Failed to run pre-flight checks: %v", err)
+			return false
+		}
+		showPreflight(preflightView, preflight)
+		return preflight.Passed
+	}
+
+	// the log view follows one job, the latest one until another is chosen in the job list
+	var following string
+	stopFollowing := func() {}
+	var follow func(id string)
+	job := func() *client.Client {
+		return api.Job(following)
+	}
+	jobTable := newJobTable(func(id string, disk string) {
+		logView.Clear()
+		LOG(logView, "Output of the installation %s on %s", id, disk)
+		follow(id)
+	})
+
+	processingForm := tview.NewForm().
+		AddButton("Pre-flight Check", func() {
+			runPreflight()
+		}).
+		AddButton("Install OVERWRITING THE WHOLE DRIVE", func() {
+			if !dataOk {
+				LOG(logView, "Data not consistent") // TODO
+				return
+			}
+			if !runPreflight() {
+				LOG(logView, "Pre-flight checks failed, not starting the installation")
+				return
+			}
+			runId, err := m.startInstallation(ctx, api, logView)
+			if err != nil {
+				LOG(logView, "Failed to start installation: %v", err)
+				return
+			}
+			// the first job continues the output the log view already follows
+			if jobs, err := api.Jobs(ctx); following != "" || (err == nil && len(jobs) > 1) {
+				follow(runId)
+			}
+		}).
+		AddButton("Dry Run", func() {
+			plan, err := api.DryRun(ctx, m.parameters())
+			if err != nil {
+				LOG(logView, "Failed to get the installation plan: %v", err)
+				return
+			}
+			showPlan(logView, plan)
+		}).
+		AddButton("Stop", func() {
+			_, err := job().Clear(ctx)
+			if err != nil {
+				LOG(logView, "Failed to stop installation: %v", err)
+			}
+		})
+	processingForm.AddButton("Report", func() {
+		report, runId, err := getReport(ctx, job())
+		if err != nil {
+			LOG(logView, "Failed to get the report: %v", err)
+			return
+		}
+		location, fileName := saveLocation(ctx, api), "odi-report-"+runId+".json"
+		path := filepath.Join(location, fileName)
+		if err = api.WriteMediaFile(ctx, location, fileName, report); err != nil {
+			LOG(logView, "Failed to save the report: %v", err)
+			return
+		}
+		LOG(logView, "Saved the report to %s", path)
+	})
+	processingForm.AddButton("Preseed", func() {
+		preseedForm := makePreseedForm(ctx, api, &m, logView, func() {
+			reload = true
+			app.Stop()
+		}, func() {
+			rootPages.RemovePage("preseed")
+			app.SetFocus(processingForm)
+		})
+		rootPages.AddPage("preseed", centered(preseedForm, 78, 11), true, true)
+		app.SetFocus(preseedForm)
+	})
+
+	saveDiagnostics := func() {
+		data, fileName, err := job().Diagnostics(ctx)
+		if err != nil {
+			LOG(logView, "Failed to get the diagnostics: %v", err)
+			return
+		}
+		location := saveLocation(ctx, api)
+		path := filepath.Join(location, fileName)
+		if err = api.WriteMediaFile(ctx, location, fileName, data); err != nil {
+			LOG(logView, "Failed to save the diagnostics: %v", err)
+			return
+		}
+		LOG(logView, "Saved the diagnostics to %s", path)
+	}
+	onFinished := func(api *client.Client) {
+		status, err := api.ProcessStatus(ctx)
+		if err != nil || !status.Failed() {
+			return
+		}
+		text := "The installation failed."
+		if e := status.Error; e != nil {
+			showInstallError(logView, e)
+			text = fmt.Sprintf("The installation failed: %s.\n%s.", e.Cause, e.Remedy)
+		}
+		app.QueueUpdateDraw(func() {
+			modal := tview.NewModal().
+				SetText(text + "\n\nThe diagnostics bundle (redacted log, disks, mounts, kernel messages, boot entries) helps to find out why.").
+				AddButtons([]string{"Save Diagnostics to USB", "Close"}).
+				SetDoneFunc(func(buttonIndex int, _ string) {
+					if buttonIndex == 0 {
+						saveDiagnostics()
+					}
+					rootPages.RemovePage("diagnostics")
+					app.SetFocus(processingForm)
+				})
+			rootPages.AddPage("diagnostics", modal, false, true)
+			app.SetFocus(modal)
+		})
+	}
+	follow = func(id string) {
+		stopFollowing()
+		var followCtx context.Context
+		followCtx, stopFollowing = context.WithCancel(ctx)
+		following = id
+		api := api.Job(id)
+		processOutput(followCtx, api, logView, func() { onFinished(api) })
+	}
+	follow("")
+	watchJobs(ctx, api, app, jobTable)
+
+	wizard := widgets.NewWizard()
+	wizard.AddForm("Device", diskForm).
+		AddForm("Users", usersForm).
+		AddForm("Configuration", configForm).
+		AddForm("Secure Boot", secureBootForm).
+		AddPage("Processing", processingForm, tview.NewFlex().
+			SetDirection(tview.FlexRow).
+			AddItem(tview.NewTextView().
+				SetText(" Processing"), 3, 0, false).
+			AddItem(processingForm, 3, 0, true).
+			AddItem(preflightView, preflightViewHeight, 0, false).
+			AddItem(jobTable, jobsViewHeight, 0, false).
+			AddItem(logView, 0, 100, false))
+
+	mainFlex := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(wizard.MakePages(), 0, 100, true).
+		AddItem(wizard.Footer, 1, 0, false)
+	mainFlex.SetBorder(true).
+		SetTitle("Opinionated Debian Installer").
+		SetTitleColor(greenColour).
+		SetTitleAlign(tview.AlignCenter)
+
+	rootPages.AddPage("main", mainFlex, true, true)
+	autoInstallPending := m.AutoInstall.Pending
+	if autoInstallPending {
+		LOG(logView, "Automatic installation OVERWRITING %s is about to start, press any key to abort", m.AutoInstall.Disk)
+		// once the countdown ends the keys go back to the wizard
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(autoInstallPollInterval):
+				}
+				state, err := api.AutoInstall(ctx)
+				if err == nil && state.Pending {
+					continue
+				}
+				app.QueueUpdate(func() {
+					autoInstallPending = false
+				})
+				return
+			}
+		}()
+	}
+	if m.AutoInstall.Error != "" {
+		LOG(logView, "Automatic installation refused: %s", m.AutoInstall.Error)
+	}
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if autoInstallPending {
+			autoInstallPending = false
+			err := api.AbortAutoInstall(ctx)
+			if err != nil {
+				LOG(logView, "Failed to abort the automatic installation: %v", err)
+			}
+			return nil
+		}
+		return wizard.InputCapture(event)
+	})
+
+	_ = hardware.SystemdNotifyReady()
+
+	if err := app.SetRoot(rootPages, true).EnableMouse(true).SetFocus(mainFlex).Run(); err != nil {
+		panic(err)
+	}
+	return reload
+}
+
+func showInstallError(logView io.Writer, e *client.InstallError) {
+	if e.Step != "" {
+		LOG(logView, "Installation failed in the %s step (exit code %d): %s", e.Step, e.ExitCode, e.Cause)
+	} else {
+		LOG(logView, "Installation failed (exit code %d): %s", e.ExitCode, e.Cause)
+	}
+	LOG(logView, "Suggested remedy: %s", e.Remedy)
+}
+
+// saveLocation prefers a mounted usb stick to the esp for saving files
+func saveLocation(ctx context.Context, api *client.Client) string {
+	locations := mediaLocations(ctx, api)
+	return locations[len(locations)-1]
+}
+
+func centered(p tview.Primitive, width int, height int) tview.Primitive {
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().
+			SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(p, height, 0, true).
+			AddItem(nil, 0, 1, false), width, 0, true).
+		AddItem(nil, 0, 1, false)
+}
+
+// showConfigSources tells where the values that were not typed in the wizard came from
+func showConfigSources(log io.Writer, m *Model) {
+	for _, configError := range m.ConfigErrors {
+		LOG(log, "Configuration error: %s", configError.Error())
+	}
+	var names []string
+	for name, source := range m.Sources {
+		if source != client.SourceEnvironment && source != client.SourceDefault {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		LOG(log, "%s set by %s", name, m.Sources[name])
+	}
+}
+
+func showPreflight(view *tview.TextView, preflight client.PreflightResponse) {
+	view.Clear()
+	for _, result := range preflight.Results {
+		var colour string
+		switch result.Status {
+		case client.PreflightPass:
+			colour = "green"
+		case client.PreflightWarn:
+			colour = "yellow"
+		default:
+			colour = "red"
+		}
+		_, _ = fmt.Fprintf(view, " [%s]%-4s[-] %s: %s\n", colour, result.Status, result.Name, tview.Escape(result.Message))
+	}
+}
+
+func showPlan(log io.Writer, plan client.InstallPlan) {
+	if plan.Valid {
+		LOG(log, "Dry run: parameters are valid")
+	} else {
+		LOG(log, "Dry run: parameters are NOT valid")
+	}
+	for _, issue := range plan.Issues {
+		LOG(log, "  %s: %s", issue.Key, issue.Message)
+	}
+	files := append(plan.RepartDefinitions, plan.Fstab)
+	files = append(files, plan.Sources...)
+	for _, file := range files {
+		LOG(log, "--- %s\n%s", file.Path, file.Content)
+	}
+	LOG(log, "--- kernel command line\n%s", plan.KernelCommandLine)
+	LOG(log, "--- packages\n%s", strings.Join(plan.Packages.Base, " "))
+	LOG(log, "--- backports packages\n%s", strings.Join(plan.Packages.Backports, " "))
+	if len(plan.Packages.Optional) > 0 {
+		LOG(log, "--- optional packages\n%s", strings.Join(plan.Packages.Optional, " "))
+	}
+}
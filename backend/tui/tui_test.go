@@ -1,4 +1,4 @@
-package main
+package tui
 
 /*
 Opinionated Debian Installer
@@ -19,7 +19,11 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 */
 
 import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/r0b0/debian-installer/backend/client"
+	"github.com/rivo/tview"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -70,3 +74,34 @@ func TestGetSliceIndex(t *testing.T) {
 		t.Errorf("Slice index = %d; want %d", o, 2)
 	}
 }
+
+func TestJobRows(t *testing.T) {
+	jobs := []client.JobStatus{
+		{Id: "0123456789ab", Disk: "/dev/vda", Status: client.StatusRunning,
+			Progress: client.Progress{Step: "packages", Current: 8, Total: 10}, Resources: client.Resources{Target: "/target"}},
+		{Id: "fedcba987654", Disk: "/dev/vdb", Status: client.StatusFinished, ReturnCode: 2, Resources: client.Resources{Target: "/target-1"}},
+	}
+	rows := jobRows(jobs)
+	if len(rows) != 3 {
+		t.Fatalf("Rows = %v; want the header and 2 jobs", rows)
+	}
+	if got := strings.Join(rows[1], "|"); got != "01234567|/dev/vda|RUNNING|8/10 packages|/target" {
+		t.Errorf("Row of the running job = %s", got)
+	}
+	if rows[2][2] != "FAILED (2)" {
+		t.Errorf("Status of the failed job = %s; want FAILED (2)", rows[2][2])
+	}
+}
+
+func TestJobTableSelection(t *testing.T) {
+	var id, disk string
+	table := newJobTable(func(selectedId string, selectedDisk string) {
+		id, disk = selectedId, selectedDisk
+	})
+	showJobs(table, []client.JobStatus{{Id: "0123456789ab", Disk: "/dev/disk/by-id/[nvme]", Status: client.StatusRunning}})
+	table.Select(1, 0)
+	table.InputHandler()(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), func(tview.Primitive) {})
+	if id != "0123456789ab" || disk != "/dev/disk/by-id/[nvme]" {
+		t.Errorf("Selected %q on %q; want the job with its disk as the back-end sent it", id, disk)
+	}
+}
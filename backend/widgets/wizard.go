@@ -1,4 +1,5 @@
-package main
+// Package widgets has the tview components of the text mode interface: a wizard of forms and a password field with confirmation.
+package widgets
 
 /*
 Opinionated Debian Installer
@@ -100,15 +100,19 @@ efi_part_uuid=$(cat efi-part.uuid)
 main_part_uuid=$(cat main-part.uuid)
 efi_partition=/dev/disk/by-partuuid/${efi_part_uuid}
 main_partition=/dev/disk/by-partuuid/${main_part_uuid}
-top_level_mount=/mnt/top_level_mount
-target=/target
+# the back-end passes other mount points and device names when it runs several installations at the same time
+top_level_mount=${TOP_LEVEL_MOUNT:-/mnt/top_level_mount}
+target=${TARGET:-/target}
 kernel_params="rw quiet rootfstype=btrfs rootflags=${FSFLAGS},subvol=@ rd.auto=1 splash"
 if [ "${DISABLE_LUKS}" != "true" ]; then
   kernel_params="rd.luks.options=tpm2-device=auto ${kernel_params}"
-  luks_device_name=root
+  luks_device_name=${LUKS_DEVICE_NAME:-root}
   root_device=/dev/mapper/${luks_device_name}
+  # the installed system opens the partition as root, whatever name it has during the installation
+  installed_root_device=/dev/mapper/root
 else
   root_device=${main_partition}
+  installed_root_device=${main_partition}
 fi
 
 notify setting up partitions on ${DISK}
@@ -166,9 +170,8 @@ wait_for_file ${main_partition}
 
 if [ "${DISABLE_LUKS}" != "true" ]; then
   notify setup luks password on ${main_partition}
-  echo -n "${LUKS_PASSWORD}" > /tmp/passwd
-  cryptsetup --key-file=luks.key luksAddKey "${main_partition}" /tmp/passwd
-  rm -f /tmp/passwd
+  # the passphrase goes through stdin, a file in /tmp would be shared with the installations running next to this one
+  echo -n "${LUKS_PASSWORD}" | cryptsetup --key-file=luks.key luksAddKey "${main_partition}" -
   cryptsetup luksUUID "${main_partition}" > luks.uuid
   root_uuid=$(cat luks.uuid)
   if [ ! -e ${root_device} ]; then
@@ -178,6 +181,7 @@ if [ "${DISABLE_LUKS}" != "true" ]; then
 fi
 
 btrfs_uuid=$(lsblk -no UUID ${root_device})
+echo ${btrfs_uuid} > btrfs.uuid
 
 if mountpoint -q "${top_level_mount}" ; then
     echo top-level subvolume already mounted on ${top_level_mount}
@@ -234,7 +238,8 @@ if [ ${SWAP_SIZE} -gt 0 ]; then
       swapon ${target}/swap/swapfile
     fi
     swapfile_offset=$(btrfs inspect-internal map-swapfile -r ${target}/swap/swapfile)
-    kernel_params="${kernel_params} resume=${root_device} resume_offset=${swapfile_offset}"
+    echo ${swapfile_offset} > swapfile.offset
+    kernel_params="${kernel_params} resume=${installed_root_device} resume_offset=${swapfile_offset}"
 fi
 
 if [ ! -f ${target}/etc/debian_version ]; then
@@ -252,6 +257,10 @@ else
     mount --make-rslave --rbind /run ${target}/run
     mount --bind /etc/resolv.conf ${target}/etc/resolv.conf
 fi
+if [ "${TARGET_TYPE}" = "image" ] && mountpoint -q "${target}/sys/firmware/efi/efivars" ; then
+    # the boot entries of a disk image belong to the machine it boots on, not to the firmware of this one
+    umount ${target}/sys/firmware/efi/efivars
+fi
 
 if mountpoint -q "${target}/boot/efi" ; then
     echo efi esp partition ${efi_partition} already mounted on ${target}/boot/efi